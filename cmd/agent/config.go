@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/internal/runnerconfig"
+
+	gconfig "github.com/gookit/config/v2"
+	gyaml "github.com/gookit/config/v2/yaml"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+const DefaultConfigPath = "agent.yml"
+
+type LogFormat string
+
+const (
+	PrettyLogFormat LogFormat = "pretty"
+	JSONLogFormat   LogFormat = "json"
+)
+
+type Config struct {
+	LogLevel  string    `mapstructure:"log_level"`
+	LogFormat LogFormat `mapstructure:"log_format"`
+
+	// Name of the agent runner, it's used in metrics and container labels.
+	Name string `mapstructure:"name"`
+
+	ListeningAddress string `mapstructure:"address"`
+
+	// TLSCert and TLSKey are paths to the PEM certificate and key of the agent.
+	// If they are set, the agent serves HTTPS.
+	TLSCert string `mapstructure:"tls_cert"`
+	TLSKey  string `mapstructure:"tls_key"`
+
+	// Token authenticates the coordinator.
+	Token string `mapstructure:"token"`
+
+	PrometheusExportAddress string `mapstructure:"prometheus_address"`
+
+	DefaultFormat *string                `mapstructure:"default_format"`
+	Datasets      []runnerconfig.Dataset `mapstructure:"datasets"`
+	Clusters      runnerconfig.Clusters  `mapstructure:"clusters"`
+
+	DockerEngine runnerconfig.DockerEngine `mapstructure:"docker_engine"`
+}
+
+func (c *Config) datasets() []dataset.Dataset {
+	return runnerconfig.Datasets(c.Datasets)
+}
+
+// runnerConfig converts the config to the config of the Docker engine runner wrapped by the agent.
+func (c *Config) runnerConfig() dockerengine.Config {
+	return c.DockerEngine.RunnerConfig(c.datasets(), c.Clusters, c.DefaultFormat)
+}
+
+func LoadConfig() (*Config, error) {
+	path := os.Getenv("CONFIG_PATH")
+	if path == "" {
+		path = DefaultConfigPath
+	}
+
+	gconfig.WithOptions(
+		gconfig.ParseEnv,
+		gconfig.Readonly,
+		func(opts *gconfig.Options) {
+			opts.DecoderConfig = &mapstructure.DecoderConfig{
+				TagName:          "mapstructure",
+				WeaklyTypedInput: true,
+				DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+			}
+		},
+	)
+	gconfig.AddDriver(gyaml.Driver)
+
+	err := gconfig.LoadFiles(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load config")
+	}
+
+	cfg := new(Config)
+	err = gconfig.BindStruct("", cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "config binding failed")
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	return cfg, nil
+}
+
+// validate verifies the loaded config and sets default values for missed fields.
+func (c *Config) validate() error {
+	if c.LogLevel == "" {
+		c.LogLevel = "debug"
+	}
+
+	switch c.LogFormat {
+	case "":
+		c.LogFormat = JSONLogFormat
+
+	case JSONLogFormat, PrettyLogFormat:
+
+	default:
+		return fmt.Errorf("invalid log format (available: %s, %s)", JSONLogFormat, PrettyLogFormat)
+	}
+
+	if c.Name == "" {
+		c.Name = "agent"
+	}
+	if c.ListeningAddress == "" {
+		c.ListeningAddress = ":9090"
+	}
+	if c.PrometheusExportAddress == "" {
+		c.PrometheusExportAddress = ":2112"
+	}
+
+	if c.Token == "" {
+		return errors.New("token is required")
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls_cert and tls_key must be set together")
+	}
+
+	if c.Clusters.MaxNodes < 0 {
+		return errors.New("clusters.max_nodes must be >= 0")
+	}
+
+	_, err := dataset.NewRegistry(c.datasets())
+	if err != nil {
+		return errors.Wrap(err, "datasets validation")
+	}
+
+	err = c.DockerEngine.Validate()
+	if err != nil {
+		return errors.Wrap(err, "docker_engine")
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/pkg/agentapi"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// The agent runs queries on a worker host with a local Docker engine runner.
+// The coordinator sends runs to the agent with the REMOTE_AGENT runner.
+func main() {
+	// Listen to termination signals.
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Initialize config.
+	config, err := LoadConfig()
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("config cannot be loaded")
+	}
+
+	// Initialize logger.
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	if config.LogFormat == PrettyLogFormat {
+		zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+
+	lvl, err := zerolog.ParseLevel(config.LogLevel)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("invalid log level")
+	}
+
+	zlog.Logger = zlog.Logger.Level(lvl)
+	logger := zlog.Logger
+
+	// Images are chosen by the coordinator and sent with runs, so the runner has no image storage.
+	runner, err := dockerengine.New(ctx, logger, config.Name, config.runnerConfig(), nil)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("failed to create docker engine runner")
+	}
+
+	err = runner.Start()
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("failed to start runner")
+	}
+
+	// Runs are streamed, so the write timeout is not set.
+	srv := &http.Server{
+		Addr: config.ListeningAddress,
+		Handler: agentapi.NewHandler(agentapi.HandlerOpts{
+			Logger: logger,
+			Runner: runner,
+			Token:  config.Token,
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		zlog.Info().Str("address", config.ListeningAddress).Bool("tls", config.TLSCert != "").Msg("starting the agent")
+
+		var err error
+		if config.TLSCert != "" {
+			err = srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		} else {
+			zlog.Warn().Msg("tls is disabled, the token and queries are sent in cleartext")
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			zlog.Fatal().Err(err).Msg("agent listen failed")
+		}
+	}()
+
+	// Export Prometheus metrics.
+	go func() {
+		zlog.Info().Str("address", config.PrometheusExportAddress).Msg("starting the prometheus exporter")
+
+		metricSrv := &http.Server{
+			Addr:              config.PrometheusExportAddress,
+			Handler:           http.DefaultServeMux,
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+
+		http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
+		err := metricSrv.ListenAndServe()
+		if err != nil {
+			zlog.Error().Err(err).Msg("prometheus exporter failed")
+		}
+	}()
+
+	<-stop
+
+	shutdownCtx, shutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdown()
+
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		zlog.Error().Err(err).Msg("agent shutdown failed")
+	}
+
+	cancel()
+
+	err = runner.Stop(shutdownCtx)
+	if err != nil {
+		zlog.Err(err).Msg("runner cannot be stopped")
+	}
+}
@@ -3,12 +3,20 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/asyncrun"
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
 	"github.com/lodthe/clickhouse-playground/internal/dockertag"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/kuberunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/resultcache"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/internal/runnerconfig"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	gconfig "github.com/gookit/config/v2"
@@ -16,18 +24,38 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	zlog "github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const DefaultConfigPath = "config.yml"
 const DefaultMaxQueryLength = 2500
 const DefaultMaxOutputLength = 25000
+const DefaultMaxFiles = 5
+const DefaultMaxFilesSize = 1 << 20
+const DefaultMaxComparedVersions = 10
+
+// ExecutionModeAuto executes queries without a server if they don't need server features.
+const ExecutionModeAuto = "AUTO"
 
 type RunnerType string
 
 const (
 	RunnerTypeDockerEngine RunnerType = "DOCKER_ENGINE"
+	RunnerTypeKubernetes   RunnerType = "KUBERNETES"
+	RunnerTypeRemoteAgent  RunnerType = "REMOTE_AGENT"
+)
+
+type StorageType string
+
+const (
+	StorageTypeDynamoDB   StorageType = "DYNAMODB"
+	StorageTypePostgres   StorageType = "POSTGRES"
+	StorageTypeFilesystem StorageType = "FILESYSTEM"
+	StorageTypeMemory     StorageType = "MEMORY"
 )
 
+const DefaultPostgresTable = "query_runs"
+
 type LogFormat string
 
 const (
@@ -46,9 +74,19 @@ type Config struct {
 	Settings CHSettings `mapstucture:"settings"`
 	Limits   Limits     `mapstructure:"limits"`
 
+	AsyncRuns   AsyncRuns              `mapstructure:"async_runs"`
+	ResultCache ResultCache            `mapstructure:"result_cache"`
+	Comparisons Comparisons            `mapstructure:"comparisons"`
+	Datasets    []runnerconfig.Dataset `mapstructure:"datasets"`
+	Clusters    runnerconfig.Clusters  `mapstructure:"clusters"`
+
+	// DefaultExecutionMode is used for requests without a mode: SERVER, LOCAL or AUTO.
+	DefaultExecutionMode string `mapstructure:"default_execution_mode"`
+
 	PrometheusExportAddress string `mapstructure:"prometheus_address"`
 
-	AWS AWS `mapstructure:"aws"`
+	Storage Storage `mapstructure:"storage"`
+	AWS     AWS     `mapstructure:"aws"`
 
 	Coordinator Coordinator `mapstructure:"coordinator"`
 	Runners     []Runner    `mapstructure:"runners"`
@@ -56,11 +94,49 @@ type Config struct {
 
 type CHSettings struct {
 	DefaultFormat *string `mapstructure:"default_format"`
+
+	// Glob patterns of ClickHouse settings users can pass with a query.
+	// Denied patterns take precedence over allowed ones.
+	AllowedSettings []string `mapstructure:"allowed_settings"`
+	DeniedSettings  []string `mapstructure:"denied_settings"`
+}
+
+func (s *CHSettings) policy() chspec.SettingsPolicy {
+	return chspec.SettingsPolicy{
+		Allowed: s.AllowedSettings,
+		Denied:  s.DeniedSettings,
+	}
+}
+
+func (c *Config) datasets() []dataset.Dataset {
+	return runnerconfig.Datasets(c.Datasets)
 }
 
 type Limits struct {
 	MaxQueryLength  uint64 `mapstructure:"max_query_length"`
 	MaxOutputLength uint64 `mapstructure:"max_output_length"`
+
+	// Files uploaded with a run.
+	MaxFiles     int    `mapstructure:"max_files"`
+	MaxFilesSize uint64 `mapstructure:"max_files_size"`
+}
+
+type AsyncRuns struct {
+	Disabled             bool          `mapstructure:"disabled"`
+	Workers              int           `mapstructure:"workers"`
+	QueueSize            int           `mapstructure:"queue_size"`
+	RunTimeout           time.Duration `mapstructure:"run_timeout"`
+	ProgressSaveInterval time.Duration `mapstructure:"progress_save_interval"`
+}
+
+type ResultCache struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	MaxEntries int           `mapstructure:"max_entries"`
+	TTL        time.Duration `mapstructure:"ttl"`
+}
+
+type Comparisons struct {
+	MaxVersions int `mapstructure:"max_versions"`
 }
 
 type DockerAuth struct {
@@ -80,6 +156,9 @@ type API struct {
 	ListeningAddress string        `mapstructure:"address"`
 	ServerTimeout    time.Duration `mapstructure:"server_timeout"`
 	CacheDisabled    bool          `mapstructure:"cache_disabled"`
+
+	// AdminToken protects the admin API. If empty, the admin API is disabled.
+	AdminToken string `mapstructure:"admin_token"`
 }
 
 type AWS struct {
@@ -90,47 +169,90 @@ type AWS struct {
 	QueryRunsTableName string `mapstructure:"query_runs_table"`
 }
 
+type Storage struct {
+	Type StorageType `mapstructure:"type"`
+
+	Postgres   *PostgresStorage   `mapstructure:"postgres"`
+	Filesystem *FilesystemStorage `mapstructure:"filesystem"`
+	Memory     *MemoryStorage     `mapstructure:"memory"`
+}
+
+type PostgresStorage struct {
+	DSN   string `mapstructure:"dsn"`
+	Table string `mapstructure:"table"`
+}
+
+type FilesystemStorage struct {
+	Directory string `mapstructure:"directory"`
+}
+
+type MemoryStorage struct {
+	MaxRuns int           `mapstructure:"max_runs"`
+	TTL     time.Duration `mapstructure:"ttl"`
+}
+
 type Coordinator struct {
 	HealthCheckRetryDelay time.Duration `mapstructure:"health_check_retry_delay"`
+
+	// DrainTimeout limits how long runs being executed are waited for when a runner is drained
+	// or the playground is stopped.
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+
+	// StatePath is a file runner changes made via the admin API are saved to.
+	// If the file exists, runners are loaded from it instead of the runners section.
+	StatePath string `mapstructure:"state_path"`
+
+	// BalancingStrategy defines how a runner is selected for a run.
+	BalancingStrategy coordinator.StrategyType `mapstructure:"balancing_strategy"`
 }
 
 type Runner struct {
-	Type           RunnerType `mapstructure:"type"`
-	Name           string     `mapstructure:"name"`
-	Weight         uint       `mapstructure:"weight"`
-	MaxConcurrency *uint32    `mapstructure:"max_concurrency"`
-
-	DockerEngine *DockerEngine `mapstructure:"docker_engine"`
+	Type           RunnerType `mapstructure:"type" json:"type"`
+	Name           string     `mapstructure:"name" json:"name"`
+	Weight         uint       `mapstructure:"weight" json:"weight"`
+	MaxConcurrency *uint32    `mapstructure:"max_concurrency" json:"max_concurrency"`
+
+	DockerEngine *runnerconfig.DockerEngine `mapstructure:"docker_engine" json:"docker_engine"`
+	Kubernetes   *Kubernetes                `mapstructure:"kubernetes" json:"kubernetes"`
+	RemoteAgent  *RemoteAgent               `mapstructure:"remote_agent" json:"remote_agent"`
 }
 
-type DockerEngine struct {
-	DaemonURL        *string         `mapstructure:"daemon_url"`
-	CustomConfigPath *string         `mapstructure:"custom_config_path"`
-	QuotasPath       *string         `mapstructure:"quotas_path"`
-	GC               *DockerEngineGC `mapstructure:"gc"`
-	Prewarm          *Prewarm        `mapsctucture:"prewarm"`
+type Kubernetes struct {
+	// Kubeconfig is a path to the kubeconfig file. If missed, the in-cluster config is used.
+	Kubeconfig *string            `mapstructure:"kubeconfig" json:"kubeconfig"`
+	Namespace  string             `mapstructure:"namespace" json:"namespace"`
+	GC         *KubernetesGC      `mapstructure:"gc" json:"gc"`
+	Prewarm    *KubernetesPrewarm `mapstructure:"prewarm" json:"prewarm"`
 
-	Container ContainerSettings `mapstructure:"container"`
+	Pod PodSettings `mapstructure:"pod" json:"pod"`
+
+	// IsolateNetwork enables a NetworkPolicy denying all traffic of pods. Default: true.
+	IsolateNetwork *bool `mapstructure:"isolate_network" json:"isolate_network"`
 }
 
-type DockerEngineGC struct {
-	TriggerFrequency time.Duration `mapstructure:"trigger_frequency"`
+type RemoteAgent struct {
+	// URL of the playground agent, e.g. https://worker-1:9090.
+	URL   string `mapstructure:"url" json:"url"`
+	Token string `mapstructure:"token" json:"token"`
 
-	ContainerTTL *time.Duration `mapstructure:"container_ttl"`
+	// CACert is a path to PEM certificates the agent certificate is verified with.
+	CACert string `mapstructure:"ca_cert" json:"ca_cert"`
+}
 
-	ImageGCCountThreshold *uint `mapstructure:"image_count_threshold"`
-	ImageBufferSize       uint  `mapstructure:"image_buffer_size"`
+type KubernetesGC struct {
+	TriggerFrequency time.Duration `mapstructure:"trigger_frequency" json:"trigger_frequency"`
+	PodTTL           time.Duration `mapstructure:"pod_ttl" json:"pod_ttl"`
 }
 
-type Prewarm struct {
-	MaxWarmContainers *uint `mapstructure:"max_warm_containers"`
+type KubernetesPrewarm struct {
+	MaxWarmPods *uint `mapstructure:"max_warm_pods" json:"max_warm_pods"`
 }
 
-type ContainerSettings struct {
-	NetworkMode   *string `mapstucture:"network_mode"`
-	CPULimit      float64 `mapstructure:"cpu_limit"`
-	CPUSet        string  `mapstructure:"cpu_cores_set"`
-	MemoryLimitMB float64 `mapstructure:"memory_limit_mb"`
+type PodSettings struct {
+	CPULimit         float64           `mapstructure:"cpu_limit" json:"cpu_limit"`
+	MemoryLimitMB    float64           `mapstructure:"memory_limit_mb" json:"memory_limit_mb"`
+	NodeSelector     map[string]string `mapstructure:"node_selector" json:"node_selector"`
+	ImagePullSecrets []string          `mapstructure:"image_pull_secrets" json:"image_pull_secrets"`
 }
 
 func (r *Runner) Validate() error {
@@ -149,7 +271,30 @@ func (r *Runner) Validate() error {
 
 	switch r.Type {
 	case RunnerTypeDockerEngine:
-		gc := r.DockerEngine.GC
+		if r.DockerEngine == nil {
+			return errors.Errorf("[%s] runner.docker_engine is required", r.Name)
+		}
+
+		err := r.DockerEngine.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "[%s] runner.docker_engine", r.Name)
+		}
+
+	case RunnerTypeKubernetes:
+		if r.Kubernetes == nil {
+			return errors.Errorf("[%s] runner.kubernetes is required", r.Name)
+		}
+
+		// The runner name is a label value of pods.
+		if msgs := validation.IsValidLabelValue(r.Name); len(msgs) > 0 {
+			return errors.Errorf("[%s] runner.name must be a valid Kubernetes label value: %s", r.Name, strings.Join(msgs, "; "))
+		}
+
+		if r.Kubernetes.Namespace == "" {
+			r.Kubernetes.Namespace = kuberunner.DefaultConfig.Namespace
+		}
+
+		gc := r.Kubernetes.GC
 		if gc == nil {
 			break
 		}
@@ -157,17 +302,35 @@ func (r *Runner) Validate() error {
 		if gc.TriggerFrequency == 0 {
 			gc.TriggerFrequency = 1 * time.Minute
 		}
+		if gc.PodTTL == 0 {
+			gc.PodTTL = kuberunner.DefaultConfig.GC.PodTTL
+		}
+
+	case RunnerTypeRemoteAgent:
+		if r.RemoteAgent == nil {
+			return errors.Errorf("[%s] runner.remote_agent is required", r.Name)
+		}
+		if r.RemoteAgent.URL == "" {
+			return errors.Errorf("[%s] runner.remote_agent.url is required", r.Name)
+		}
 
-		daemonURL := r.DockerEngine.DaemonURL
-		if daemonURL != nil && !strings.HasPrefix(*daemonURL, "ssh://") {
-			return errors.Errorf("[%s] runner.docker_daemon.daemon_url must be empty or start with 'ssh://', but %s found", r.Name, *daemonURL)
+		agentURL, err := url.Parse(r.RemoteAgent.URL)
+		if err != nil || (agentURL.Scheme != "http" && agentURL.Scheme != "https") {
+			return errors.Errorf("[%s] runner.remote_agent.url must be an http or https URL", r.Name)
+		}
+		if r.RemoteAgent.CACert != "" && agentURL.Scheme != "https" {
+			return errors.Errorf("[%s] runner.remote_agent.ca_cert requires an https url", r.Name)
+		}
+		if r.RemoteAgent.Token == "" {
+			return errors.Errorf("[%s] runner.remote_agent.token is required", r.Name)
 		}
 
 	case "":
 		return errors.Errorf("[%s] runner.type is required", r.Name)
 
 	default:
-		return errors.Errorf("unknown runner %s type %s (supported: %s)", r.Name, r.Type, RunnerTypeDockerEngine)
+		return errors.Errorf("unknown runner %s type %s (supported: %s, %s, %s)",
+			r.Name, r.Type, RunnerTypeDockerEngine, RunnerTypeKubernetes, RunnerTypeRemoteAgent)
 	}
 
 	return nil
@@ -259,21 +422,109 @@ func (c *Config) validate() error {
 	if c.Limits.MaxOutputLength == 0 {
 		c.Limits.MaxOutputLength = DefaultMaxOutputLength
 	}
+	if c.Limits.MaxFiles == 0 {
+		c.Limits.MaxFiles = DefaultMaxFiles
+	}
+	if c.Limits.MaxFilesSize == 0 {
+		c.Limits.MaxFilesSize = DefaultMaxFilesSize
+	}
+	if c.Limits.MaxFiles < 0 {
+		return errors.New("limits.max_files must be >= 0")
+	}
+
+	if c.AsyncRuns.Workers == 0 {
+		c.AsyncRuns.Workers = asyncrun.DefaultConfig.Workers
+	}
+	if c.AsyncRuns.QueueSize == 0 {
+		c.AsyncRuns.QueueSize = asyncrun.DefaultConfig.QueueSize
+	}
+	if c.AsyncRuns.RunTimeout == 0 {
+		c.AsyncRuns.RunTimeout = asyncrun.DefaultConfig.RunTimeout
+	}
+	if c.AsyncRuns.ProgressSaveInterval == 0 {
+		c.AsyncRuns.ProgressSaveInterval = asyncrun.DefaultConfig.ProgressSaveInterval
+	}
+	if c.AsyncRuns.Workers < 0 || c.AsyncRuns.QueueSize < 0 {
+		return errors.New("async_runs.workers and async_runs.queue_size must be >= 0")
+	}
+
+	if c.ResultCache.MaxEntries == 0 {
+		c.ResultCache.MaxEntries = resultcache.DefaultConfig.MaxEntries
+	}
+	if c.ResultCache.TTL == 0 {
+		c.ResultCache.TTL = resultcache.DefaultConfig.TTL
+	}
+	if c.ResultCache.MaxEntries < 0 {
+		return errors.New("result_cache.max_entries must be >= 0")
+	}
+
+	if c.Comparisons.MaxVersions == 0 {
+		c.Comparisons.MaxVersions = DefaultMaxComparedVersions
+	}
+	if c.Comparisons.MaxVersions < 0 {
+		return errors.New("comparisons.max_versions must be >= 0")
+	}
+
+	err := c.Settings.policy().Validate()
+	if err != nil {
+		return errors.Wrap(err, "settings.allowed_settings and settings.denied_settings")
+	}
+
+	if c.Clusters.MaxNodes < 0 {
+		return errors.New("clusters.max_nodes must be >= 0")
+	}
+
+	switch c.DefaultExecutionMode {
+	case "":
+		c.DefaultExecutionMode = string(queryrun.ModeServer)
+	case string(queryrun.ModeServer), string(queryrun.ModeLocal), ExecutionModeAuto:
+	default:
+		return errors.Errorf("unknown default_execution_mode %s", c.DefaultExecutionMode)
+	}
+
+	_, err = dataset.NewRegistry(c.datasets())
+	if err != nil {
+		return errors.Wrap(err, "datasets validation")
+	}
 
 	if c.PrometheusExportAddress == "" {
 		c.PrometheusExportAddress = ":2112"
 	}
 
-	if c.AWS.Region == "" {
-		return errors.New("aws.region is required")
-	}
-	if c.AWS.QueryRunsTableName == "" {
-		return errors.New("aws.query_runs_table is required")
+	err = c.Storage.validate(&c.AWS)
+	if err != nil {
+		return errors.Wrap(err, "storage validation")
 	}
 
 	if c.Coordinator.HealthCheckRetryDelay == 0 {
 		c.Coordinator.HealthCheckRetryDelay = coordinator.DefaultHealthCheckRetryDelay
 	}
+	if c.Coordinator.DrainTimeout == 0 {
+		c.Coordinator.DrainTimeout = coordinator.DefaultDrainTimeout
+	}
+
+	switch c.Coordinator.BalancingStrategy {
+	case "":
+		c.Coordinator.BalancingStrategy = coordinator.DefaultStrategy
+	case coordinator.StrategyWeightedRandom, coordinator.StrategyP2C, coordinator.StrategyLeastLatency, coordinator.StrategyRoundRobin:
+	default:
+		return errors.Errorf("unknown coordinator.balancing_strategy %s (supported: %s, %s, %s, %s)",
+			c.Coordinator.BalancingStrategy,
+			coordinator.StrategyWeightedRandom, coordinator.StrategyP2C, coordinator.StrategyLeastLatency, coordinator.StrategyRoundRobin,
+		)
+	}
+
+	// Runners changed via the admin API override the config.
+	if c.Coordinator.StatePath != "" {
+		runners, found, err := loadRunnerState(c.Coordinator.StatePath)
+		if err != nil {
+			return errors.Wrap(err, "coordinator.state_path")
+		}
+		if found {
+			zlog.Info().Str("path", c.Coordinator.StatePath).Int("count", len(runners)).Msg("runners have been loaded from the state file")
+			c.Runners = runners
+		}
+	}
 
 	if len(c.Runners) == 0 {
 		return errors.New("empty runner list")
@@ -297,6 +548,55 @@ func (c *Config) validate() error {
 	return nil
 }
 
+func (s *Storage) validate(awsConfig *AWS) error {
+	if s.Type == "" {
+		s.Type = StorageTypeDynamoDB
+
+		// Fall back to the in-memory storage when AWS is not configured to simplify local runs.
+		if awsConfig.Region == "" && awsConfig.QueryRunsTableName == "" {
+			s.Type = StorageTypeMemory
+			zlog.Warn().Msg("aws is not configured, runs are stored in memory and will be lost on restart")
+		}
+	}
+
+	switch s.Type {
+	case StorageTypeDynamoDB:
+		if awsConfig.Region == "" {
+			return errors.New("aws.region is required")
+		}
+		if awsConfig.QueryRunsTableName == "" {
+			return errors.New("aws.query_runs_table is required")
+		}
+
+	case StorageTypePostgres:
+		if s.Postgres == nil || s.Postgres.DSN == "" {
+			return errors.New("storage.postgres.dsn is required")
+		}
+		if s.Postgres.Table == "" {
+			s.Postgres.Table = DefaultPostgresTable
+		}
+
+	case StorageTypeFilesystem:
+		if s.Filesystem == nil || s.Filesystem.Directory == "" {
+			return errors.New("storage.filesystem.directory is required")
+		}
+
+	case StorageTypeMemory:
+		if s.Memory == nil {
+			s.Memory = &MemoryStorage{}
+		}
+		if s.Memory.MaxRuns < 0 {
+			return errors.New("storage.memory.max_runs must be >= 0")
+		}
+
+	default:
+		return errors.Errorf("unknown storage type %s (supported: %s, %s, %s, %s)",
+			s.Type, StorageTypeDynamoDB, StorageTypePostgres, StorageTypeFilesystem, StorageTypeMemory)
+	}
+
+	return nil
+}
+
 func (c *Config) Retrieve(_ context.Context) (aws.Credentials, error) {
 	return aws.Credentials{
 		AccessKeyID:     c.AWS.AccessKeyID,
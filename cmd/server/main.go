@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/asyncrun"
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
 	"github.com/lodthe/clickhouse-playground/internal/dockertag"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/kuberunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/remoteagent"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/resultcache"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
 	"github.com/lodthe/clickhouse-playground/pkg/dockerhub"
 	api "github.com/lodthe/clickhouse-playground/pkg/restapi"
 
 	awsconf "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
@@ -51,23 +60,10 @@ func main() {
 	zlog.Logger = zlog.Logger.Level(lvl)
 	logger := zlog.Logger
 
-	// Load AWS credentials.
-	var awsOpts []func(*awsconf.LoadOptions) error
-	if config.AWS.AccessKeyID != "" {
-		// Load AWS config with credentials when AccessKeyID is not empty.
-		// Otherwise, we let SDK to pick credentials from available sources automatically.
-		awsOpts = append(awsOpts, awsconf.WithCredentialsProvider(config))
-	}
-
-	awsOpts = append(awsOpts, awsconf.WithRegion(config.AWS.Region))
-
-	awsConfig, err := awsconf.LoadDefaultConfig(ctx, awsOpts...)
-	if err != nil {
-		zlog.Fatal().Err(err).Msg("failed to load AWS config")
-	}
-
 	// Initialize storages.
-	dynamodbClient := dynamodb.NewFromConfig(awsConfig)
+	runRepo, closeRunRepo := initializeRunRepository(ctx, config)
+	defer closeRunRepo()
+
 	dockerhubCli := dockerhub.NewClient(logger, dockerhub.DockerHubURL, dockerhub.DefaultMaxRPS, dockerhub.Auth(config.DockerImage.Auth))
 	tagStorage := dockertag.NewCache(ctx, dockertag.Config{
 		Repositories:   config.DockerImage.Repositories,
@@ -77,14 +73,24 @@ func main() {
 	}, logger, dockerhubCli)
 	tagStorage.RunBackgroundUpdate()
 
+	datasets, err := dataset.NewRegistry(config.datasets())
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("invalid datasets")
+	}
+
 	// Create runners and the coordinator.
-	runners := initializeRunners(ctx, config, tagStorage, logger)
+	runners := initializeRunners(ctx, config, tagStorage, datasets, logger)
 
 	coordinatorCfg := coordinator.Config{
 		HealthChecksEnabled:   true,
 		HealthCheckRetryDelay: config.Coordinator.HealthCheckRetryDelay,
+		DrainTimeout:          config.Coordinator.DrainTimeout,
+		Strategy:              config.Coordinator.BalancingStrategy,
 	}
 	coord := coordinator.New(ctx, logger, runners, coordinatorCfg)
+	runnerManager := newRunnerManager(coord, config.Runners, config.Coordinator.StatePath, func(r Runner) (*coordinator.Runner, error) {
+		return initializeRunner(ctx, config, r, tagStorage, datasets, logger)
+	})
 	go func() {
 		err := coord.Start()
 		if err != nil {
@@ -92,19 +98,58 @@ func main() {
 		}
 	}()
 
-	// Initialize the REST server.
-	runRepo := queryrun.NewRepository(ctx, dynamodbClient, config.AWS.QueryRunsTableName)
+	// Serve identical runs from the result cache.
+	var runner api.QueryRunner = coord
+	if config.ResultCache.Enabled {
+		runner = resultcache.New(logger, coord, tagStorage, resultcache.Config{
+			MaxEntries: config.ResultCache.MaxEntries,
+			TTL:        config.ResultCache.TTL,
+		})
+	}
 
+	// Initialize the background executor for async runs.
+	var asyncRunner api.AsyncRunner
+	var asyncExecutor *asyncrun.Executor
+	if !config.AsyncRuns.Disabled {
+		asyncExecutor = asyncrun.New(ctx, logger, runner, runRepo, asyncrun.Config{
+			Workers:              config.AsyncRuns.Workers,
+			QueueSize:            config.AsyncRuns.QueueSize,
+			RunTimeout:           config.AsyncRuns.RunTimeout,
+			ProgressSaveInterval: config.AsyncRuns.ProgressSaveInterval,
+			MaxOutputLength:      config.Limits.MaxOutputLength,
+		})
+		asyncExecutor.Start()
+		asyncRunner = asyncExecutor
+	}
+
+	// Initialize the REST server.
 	lim := config.Limits
 	router := api.NewRouter(api.RouterOpts{
+		Context:         ctx,
 		Logger:          logger,
-		Runner:          coord,
+		Runner:          runner,
 		TagStorage:      tagStorage,
 		RunRepo:         runRepo,
+		AsyncRunner:     asyncRunner,
 		Timeout:         config.API.ServerTimeout,
 		CacheDisabled:   config.API.CacheDisabled,
 		MaxQueryLength:  lim.MaxOutputLength,
 		MaxOutputLength: lim.MaxOutputLength,
+		FileLimits: api.FileLimits{
+			MaxFiles:     lim.MaxFiles,
+			MaxTotalSize: lim.MaxFilesSize,
+		},
+
+		MaxComparedVersions: config.Comparisons.MaxVersions,
+
+		SettingsPolicy: config.Settings.policy(),
+		Datasets:       datasets,
+
+		MaxClusterNodes: config.Clusters.MaxNodes,
+		DefaultMode:     config.DefaultExecutionMode,
+
+		RunnerManager: runnerManager,
+		AdminToken:    config.API.AdminToken,
 	})
 
 	srv := &http.Server{
@@ -142,14 +187,27 @@ func main() {
 	}()
 
 	<-stop
+
+	// Runners are drained first: new runs are rejected, and runs being executed
+	// are finished until the drain timeout expires.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.Coordinator.DrainTimeout)
+	defer cancelDrain()
+
+	err = coord.Stop(drainCtx)
+	if err != nil {
+		zlog.Err(err).Msg("coordinator cannot be stopped")
+	}
+
 	cancel()
 
 	shutdownCtx, shutdown := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdown()
 
-	err = coord.Stop(shutdownCtx)
-	if err != nil {
-		zlog.Err(err).Msg("coordinator cannot be stopped")
+	if asyncExecutor != nil {
+		err = asyncExecutor.Stop(shutdownCtx)
+		if err != nil {
+			zlog.Err(err).Msg("async executor cannot be stopped")
+		}
 	}
 
 	err = srv.Shutdown(shutdownCtx)
@@ -158,55 +216,152 @@ func main() {
 	}
 }
 
-func initializeRunners(ctx context.Context, config *Config, tagStorage *dockertag.Cache, logger zerolog.Logger) []*coordinator.Runner {
+func initializeRunners(ctx context.Context, config *Config, tagStorage *dockertag.Cache, datasets *dataset.Registry, logger zerolog.Logger) []*coordinator.Runner {
 	var runners []*coordinator.Runner
 	for _, r := range config.Runners {
-		var runner qrunner.Runner
-		switch r.Type {
-		case RunnerTypeDockerEngine:
-			rcfg := dockerengine.DefaultConfig
-			rcfg.DaemonURL = r.DockerEngine.DaemonURL
-			rcfg.CustomConfigPath = r.DockerEngine.CustomConfigPath
-			rcfg.QuotasPath = r.DockerEngine.QuotasPath
-			rcfg.GC = nil
-
-			if config.Settings.DefaultFormat != nil {
-				rcfg.DefaultOutputFormat = *config.Settings.DefaultFormat
-			}
+		runner, err := initializeRunner(ctx, config, r, tagStorage, datasets, logger)
+		if err != nil {
+			zlog.Fatal().Err(err).Str("runner", r.Name).Msg("failed to create runner")
+		}
 
-			gc := r.DockerEngine.GC
-			if gc != nil {
-				rcfg.GC = &dockerengine.GCConfig{
-					TriggerFrequency:      gc.TriggerFrequency,
-					ContainerTTL:          gc.ContainerTTL,
-					ImageGCCountThreshold: gc.ImageGCCountThreshold,
-					ImageBufferSize:       gc.ImageBufferSize,
-				}
-			}
+		runners = append(runners, runner)
+	}
 
-			rcfg.Container = dockerengine.ContainerSettings{
-				NetworkMode: r.DockerEngine.Container.NetworkMode,
-				CPULimit:    uint64(r.DockerEngine.Container.CPULimit * 1e9), // cpu -> nano cpu.
-				CPUSet:      r.DockerEngine.Container.CPUSet,
-				MemoryLimit: uint64(r.DockerEngine.Container.MemoryLimitMB * 1e6), // mb -> bytes.
-			}
+	return runners
+}
+
+// initializeRunner creates a coordinated runner described in the config.
+func initializeRunner(ctx context.Context, config *Config, r Runner, tagStorage *dockertag.Cache, datasets *dataset.Registry, logger zerolog.Logger) (*coordinator.Runner, error) {
+	var runner qrunner.Runner
+	switch r.Type {
+	case RunnerTypeDockerEngine:
+		rcfg := r.DockerEngine.RunnerConfig(datasets.GetAll(), config.Clusters, config.Settings.DefaultFormat)
+
+		var err error
+		runner, err = dockerengine.New(ctx, logger, r.Name, rcfg, tagStorage)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create docker engine runner")
+		}
 
-			if r.DockerEngine.Prewarm != nil && r.DockerEngine.Prewarm.MaxWarmContainers != nil {
-				rcfg.MaxWarmContainers = *r.DockerEngine.Prewarm.MaxWarmContainers
+	case RunnerTypeKubernetes:
+		k := r.Kubernetes
+
+		rcfg := kuberunner.DefaultConfig
+		rcfg.Kubeconfig = k.Kubeconfig
+		rcfg.Namespace = k.Namespace
+		rcfg.GC = nil
+
+		if config.Settings.DefaultFormat != nil {
+			rcfg.DefaultOutputFormat = *config.Settings.DefaultFormat
+		}
+
+		if k.GC != nil {
+			rcfg.GC = &kuberunner.GCConfig{
+				TriggerFrequency: k.GC.TriggerFrequency,
+				PodTTL:           k.GC.PodTTL,
 			}
+		}
+
+		rcfg.Pod = kuberunner.PodSettings{
+			CPULimit:         uint64(k.Pod.CPULimit * 1e3),      // cpu -> millicores.
+			MemoryLimit:      uint64(k.Pod.MemoryLimitMB * 1e6), // mb -> bytes.
+			NodeSelector:     k.Pod.NodeSelector,
+			ImagePullSecrets: k.Pod.ImagePullSecrets,
+		}
+
+		if k.Prewarm != nil && k.Prewarm.MaxWarmPods != nil {
+			rcfg.MaxWarmPods = *k.Prewarm.MaxWarmPods
+		}
+
+		if k.IsolateNetwork != nil {
+			rcfg.IsolateNetwork = *k.IsolateNetwork
+		}
+
+		var err error
+		runner, err = kuberunner.New(ctx, logger, r.Name, rcfg, tagStorage)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create kubernetes runner")
+		}
+
+	case RunnerTypeRemoteAgent:
+		if strings.HasPrefix(r.RemoteAgent.URL, "http://") {
+			logger.Warn().Str("runner", r.Name).Msg("the agent url is not https, the token and queries are sent in cleartext")
+		}
+
+		var err error
+		runner, err = remoteagent.New(logger, r.Name, remoteagent.Config{
+			URL:        r.RemoteAgent.URL,
+			Token:      r.RemoteAgent.Token,
+			CACertPath: r.RemoteAgent.CACert,
+		}, tagStorage)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create remote agent runner")
+		}
+
+	default:
+		return nil, errors.Errorf("invalid runner type %s", r.Type)
+	}
 
-			var err error
-			runner, err = dockerengine.New(ctx, logger, r.Name, rcfg, tagStorage)
+	return coordinator.NewRunner(runner, r.Weight, r.MaxConcurrency), nil
+}
+
+// initializeRunRepository creates the storage for query runs according to the config.
+// The returned function releases resources held by the storage.
+func initializeRunRepository(ctx context.Context, config *Config) (queryrun.Repository, func()) {
+	switch config.Storage.Type {
+	case StorageTypeDynamoDB:
+		// Load AWS credentials.
+		var awsOpts []func(*awsconf.LoadOptions) error
+		if config.AWS.AccessKeyID != "" {
+			// Load AWS config with credentials when AccessKeyID is not empty.
+			// Otherwise, we let SDK to pick credentials from available sources automatically.
+			awsOpts = append(awsOpts, awsconf.WithCredentialsProvider(config))
+		}
+
+		awsOpts = append(awsOpts, awsconf.WithRegion(config.AWS.Region))
+
+		awsConfig, err := awsconf.LoadDefaultConfig(ctx, awsOpts...)
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("failed to load AWS config")
+		}
+
+		dynamodbClient := dynamodb.NewFromConfig(awsConfig)
+
+		return queryrun.NewRepository(ctx, dynamodbClient, config.AWS.QueryRunsTableName), func() {}
+
+	case StorageTypePostgres:
+		db, err := sql.Open("pgx", config.Storage.Postgres.DSN)
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("failed to open postgres connection")
+		}
+
+		repo := queryrun.NewPostgresRepository(ctx, db, config.Storage.Postgres.Table)
+		err = repo.Migrate()
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("failed to migrate postgres storage")
+		}
+
+		return repo, func() {
+			err := db.Close()
 			if err != nil {
-				zlog.Fatal().Err(err).Msg("failed to create docker engine runner")
+				zlog.Error().Err(err).Msg("failed to close postgres connection")
 			}
+		}
 
-		default:
-			zlog.Fatal().Msg("invalid runner type")
+	case StorageTypeFilesystem:
+		repo, err := queryrun.NewFileRepository(config.Storage.Filesystem.Directory)
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("failed to create filesystem storage")
 		}
 
-		runners = append(runners, coordinator.NewRunner(runner, r.Weight, r.MaxConcurrency))
+		return repo, func() {}
+
+	case StorageTypeMemory:
+		return queryrun.NewMemoryRepository(config.Storage.Memory.MaxRuns, config.Storage.Memory.TTL), func() {}
+
+	default:
+		zlog.Fatal().Msg("invalid storage type")
 	}
 
-	return runners
+	return nil, nil
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
+	api "github.com/lodthe/clickhouse-playground/pkg/restapi"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	zlog "github.com/rs/zerolog/log"
+)
+
+// runnerState is the content of the state file.
+type runnerState struct {
+	Runners []Runner `json:"runners"`
+}
+
+// loadRunnerState reads runners saved to the state file.
+// It returns false if the state file doesn't exist.
+func loadRunnerState(path string) ([]Runner, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to read state file")
+	}
+
+	var state runnerState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to decode state file")
+	}
+
+	return state.Runners, true, nil
+}
+
+// saveRunnerState atomically replaces the state file with the provided runners.
+func saveRunnerState(path string, runners []Runner) error {
+	data, err := json.MarshalIndent(runnerState{Runners: runners}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode state")
+	}
+
+	// The state contains runner credentials, so it's readable by the owner only.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write state")
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to write state")
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "failed to replace state file")
+	}
+
+	return nil
+}
+
+// runnerManager changes coordinated runners at runtime and saves the changes to the state file.
+type runnerManager struct {
+	coord *coordinator.Coordinator
+
+	// newRunner creates a runner described in the config.
+	newRunner func(r Runner) (*coordinator.Runner, error)
+
+	// statePath is empty if changes are not saved.
+	statePath string
+
+	// lock serializes changes, so the state file matches the coordinator runners.
+	lock    sync.Mutex
+	runners []Runner
+}
+
+func newRunnerManager(coord *coordinator.Coordinator, runners []Runner, statePath string, newRunner func(r Runner) (*coordinator.Runner, error)) *runnerManager {
+	return &runnerManager{
+		coord:     coord,
+		newRunner: newRunner,
+		statePath: statePath,
+		runners:   append([]Runner(nil), runners...),
+	}
+}
+
+func (m *runnerManager) Runners() []coordinator.RunnerInfo {
+	return m.coord.Runners()
+}
+
+// AddRunner decodes the runner the same way as the runners config section and adds it to the coordinator.
+// If the runner cannot be added, it's stopped.
+func (m *runnerManager) AddRunner(ctx context.Context, spec map[string]interface{}) error {
+	var r Runner
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "mapstructure",
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		Result:           &r,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create decoder")
+	}
+
+	err = decoder.Decode(spec)
+	if err != nil {
+		return errors.Wrap(api.ErrInvalidRunner, err.Error())
+	}
+
+	err = r.Validate()
+	if err != nil {
+		return errors.Wrap(api.ErrInvalidRunner, err.Error())
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.runners {
+		if m.runners[i].Name == r.Name {
+			return coordinator.ErrRunnerAlreadyExists
+		}
+	}
+
+	runner, err := m.newRunner(r)
+	if err != nil {
+		return errors.Wrap(api.ErrInvalidRunner, err.Error())
+	}
+
+	err = m.coord.AddRunner(runner)
+	if err != nil {
+		stopErr := runner.Underlying().Stop(ctx)
+		if stopErr != nil {
+			zlog.Error().Err(stopErr).Str("runner", r.Name).Msg("runner that cannot be added cannot be stopped")
+		}
+
+		return err
+	}
+
+	m.runners = append(m.runners, r)
+
+	return m.save()
+}
+
+// DrainRunner gracefully stops the runner. Drained runners are not saved to the state file,
+// they are started again after a restart unless they are removed.
+func (m *runnerManager) DrainRunner(name string, timeout time.Duration) error {
+	return m.coord.DrainRunner(name, timeout)
+}
+
+// RemoveRunner drains and removes the runner. The lock is not taken while the runner is being drained,
+// so other runners can be changed meanwhile. The name cannot be reused until the runner is removed,
+// because it's kept in the runners list.
+func (m *runnerManager) RemoveRunner(ctx context.Context, name string) error {
+	err := m.coord.RemoveRunner(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.runners {
+		if m.runners[i].Name == name {
+			m.runners = append(m.runners[:i], m.runners[i+1:]...)
+			break
+		}
+	}
+
+	return m.save()
+}
+
+func (m *runnerManager) SetRunnerWeight(name string, weight uint) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	err := m.coord.SetRunnerWeight(name, weight)
+	if err != nil {
+		return err
+	}
+
+	for i := range m.runners {
+		if m.runners[i].Name == name {
+			m.runners[i].Weight = weight
+		}
+	}
+
+	return m.save()
+}
+
+// save writes the current runners to the state file if it's configured.
+// save must be called under the taken lock.
+func (m *runnerManager) save() error {
+	if m.statePath == "" {
+		return nil
+	}
+
+	err := saveRunnerState(m.statePath, m.runners)
+	if err != nil {
+		return errors.Wrap(err, "runners have been changed, but the state cannot be saved")
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package asyncrun
+
+import "time"
+
+type Config struct {
+	// How many runs are executed simultaneously.
+	Workers int
+
+	// How many submitted runs can wait for a free worker.
+	QueueSize int
+
+	// Max duration of a run execution.
+	RunTimeout time.Duration
+
+	// How often the partial output of a running query is saved.
+	ProgressSaveInterval time.Duration
+
+	// If the output exceeds the limit, the run is stopped and marked as failed.
+	MaxOutputLength uint64
+}
+
+var DefaultConfig = Config{
+	Workers:              10,
+	QueueSize:            100,
+	RunTimeout:           5 * time.Minute,
+	ProgressSaveInterval: time.Second,
+	MaxOutputLength:      25000,
+}
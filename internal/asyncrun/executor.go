@@ -0,0 +1,423 @@
+package asyncrun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+var ErrQueueFull = errors.New("too many queued runs, try again later")
+var ErrStopped = errors.New("async executor has been stopped")
+
+type QueryRunner interface {
+	RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error)
+}
+
+// Executor runs queries in background.
+//
+// A submitted run is saved with the QUEUED status and then processed by one of the workers.
+// While the query is being executed, the run has the RUNNING status and its partial output is
+// periodically saved. When the query is finished, the run gets the SUCCEEDED or FAILED status.
+type Executor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger zerolog.Logger
+
+	cfg     Config
+	runner  QueryRunner
+	runRepo queryrun.Repository
+
+	queue   chan *queryrun.Run
+	workers sync.WaitGroup
+
+	// lock guards the active runs and the stopped flag, runs are queued under the lock
+	// so that Stop never misses a run submitted concurrently.
+	lock    sync.Mutex
+	active  map[string]*activeRun
+	stopped bool
+}
+
+// activeRun is a submitted run that is queued or running.
+type activeRun struct {
+	run *queryrun.Run
+
+	// cancel is nil while the run is queued.
+	cancel    context.CancelFunc
+	cancelled bool
+
+	// done is closed when the run gets a final status.
+	done chan struct{}
+}
+
+func New(ctx context.Context, logger zerolog.Logger, runner QueryRunner, runRepo queryrun.Repository, cfg Config) *Executor {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Executor{
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  logger.With().Str("component", "async_executor").Logger(),
+		cfg:     cfg,
+		runner:  runner,
+		runRepo: runRepo,
+		queue:   make(chan *queryrun.Run, cfg.QueueSize),
+		active:  make(map[string]*activeRun),
+	}
+}
+
+// Start starts workers. This function is non-blocking.
+func (e *Executor) Start() {
+	for i := 0; i < e.cfg.Workers; i++ {
+		e.workers.Add(1)
+		go func() {
+			defer e.workers.Done()
+			e.work()
+		}()
+	}
+
+	e.logger.Info().Int("workers", e.cfg.Workers).Msg("async executor has been started")
+}
+
+// Stop interrupts running queries and waits for workers to finish.
+// Runs that are still in the queue are marked as failed.
+func (e *Executor) Stop(shutdownCtx context.Context) error {
+	e.lock.Lock()
+	e.stopped = true
+	e.lock.Unlock()
+
+	e.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		e.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		return errors.Wrap(shutdownCtx.Err(), "workers have not been stopped")
+	}
+
+	for {
+		select {
+		case run := <-e.queue:
+			// Skip runs cancelled while they were queued.
+			if e.finish(run.ID) {
+				e.fail(run, ErrStopped.Error())
+			}
+
+		default:
+			e.logger.Info().Msg("async executor has been stopped")
+			return nil
+		}
+	}
+}
+
+// Submit saves the run as queued and schedules its execution.
+func (e *Executor) Submit(run *queryrun.Run) error {
+	if e.isStopped() {
+		return ErrStopped
+	}
+
+	run.Status = queryrun.StatusQueued
+
+	err := e.runRepo.Create(run)
+	if err != nil {
+		return errors.Wrap(err, "failed to save a queued run")
+	}
+
+	// The executor may have been stopped while the run was being saved.
+	e.lock.Lock()
+	if e.stopped {
+		e.lock.Unlock()
+		e.fail(run, ErrStopped.Error())
+
+		return ErrStopped
+	}
+
+	select {
+	case e.queue <- run:
+		e.active[run.ID] = &activeRun{
+			run:  run,
+			done: make(chan struct{}),
+		}
+		e.lock.Unlock()
+
+		e.logger.Debug().Str("run_id", run.ID).Msg("run has been queued")
+
+		return nil
+
+	default:
+		e.lock.Unlock()
+		e.fail(run, ErrQueueFull.Error())
+
+		return ErrQueueFull
+	}
+}
+
+func (e *Executor) isStopped() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.stopped
+}
+
+// Cancel stops the submitted run. A queued run is cancelled immediately.
+// For a running query, Cancel waits until the run is stopped and saved with the partial output.
+//
+// qrunner.ErrRunNotFound is returned if the run is not queued or running.
+func (e *Executor) Cancel(ctx context.Context, runID string) error {
+	e.lock.Lock()
+
+	active, found := e.active[runID]
+	if !found {
+		e.lock.Unlock()
+		return qrunner.ErrRunNotFound
+	}
+
+	active.cancelled = true
+
+	if active.cancel == nil {
+		// The run is still queued, it will be skipped by workers.
+		delete(e.active, runID)
+		e.lock.Unlock()
+
+		run := *active.run
+		run.Status = queryrun.StatusCancelled
+		e.save(&run)
+		close(active.done)
+
+		e.logger.Info().Str("run_id", runID).Msg("queued run has been cancelled")
+
+		return nil
+	}
+
+	active.cancel()
+	e.lock.Unlock()
+
+	select {
+	case <-active.done:
+		e.logger.Info().Str("run_id", runID).Msg("running run has been cancelled")
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start marks the run as running. It returns false if the run has been cancelled while it was queued.
+func (e *Executor) start(runID string, cancel context.CancelFunc) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	active, found := e.active[runID]
+	if !found || active.cancelled {
+		return false
+	}
+
+	active.cancel = cancel
+
+	return true
+}
+
+func (e *Executor) isCancelled(runID string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	active, found := e.active[runID]
+
+	return found && active.cancelled
+}
+
+// finish excludes the run from the active set and reports whether the run has been active.
+func (e *Executor) finish(runID string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	active, found := e.active[runID]
+	if !found {
+		return false
+	}
+
+	delete(e.active, runID)
+	close(active.done)
+
+	return true
+}
+
+func (e *Executor) work() {
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+
+		case run := <-e.queue:
+			e.execute(run)
+		}
+	}
+}
+
+// progress accumulates the output of a running query.
+type progress struct {
+	lock   sync.Mutex
+	output strings.Builder
+	dirty  bool
+}
+
+func (p *progress) append(chunk qrunner.OutputChunk) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.output.WriteString(chunk.Data)
+	p.dirty = true
+
+	return p.output.Len()
+}
+
+// snapshot returns the output if it has been changed since the last snapshot.
+func (p *progress) snapshot() (output string, changed bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	changed = p.dirty
+	p.dirty = false
+
+	return p.output.String(), changed
+}
+
+func (e *Executor) execute(run *queryrun.Run) {
+	logger := e.logger.With().Str("run_id", run.ID).Logger()
+
+	ctx, cancel := context.WithTimeout(e.ctx, e.cfg.RunTimeout)
+	defer cancel()
+
+	if !e.start(run.ID, cancel) {
+		return
+	}
+	defer e.finish(run.ID)
+
+	run.Status = queryrun.StatusRunning
+	e.save(run)
+
+	var outputExceeded int32
+	prog := new(progress)
+	onOutput := func(chunk qrunner.OutputChunk) {
+		if uint64(prog.append(chunk)) > e.cfg.MaxOutputLength {
+			atomic.StoreInt32(&outputExceeded, 1)
+			cancel()
+		}
+	}
+
+	// Save the partial output periodically while the query is running.
+	// The saver works with a copy, because the runner may fill the run while executing the query.
+	base := *run
+	finished := make(chan struct{})
+	saverDone := make(chan struct{})
+	go func() {
+		defer close(saverDone)
+		e.saveProgress(&base, prog, finished)
+	}()
+
+	startedAt := time.Now()
+	output, err := e.runner.RunQueryStream(ctx, run, onOutput)
+
+	close(finished)
+	<-saverDone
+
+	run.ExecutionTime = time.Since(startedAt)
+
+	switch {
+	case e.isCancelled(run.ID) || errors.Is(err, qrunner.ErrRunCancelled):
+		run.Output, _ = prog.snapshot()
+		run.Status = queryrun.StatusCancelled
+		e.save(run)
+
+		logger.Info().Dur("elapsed", run.ExecutionTime).Msg("async run has been cancelled")
+
+	case atomic.LoadInt32(&outputExceeded) == 1 || uint64(len(output)) > e.cfg.MaxOutputLength:
+		run.Output, _ = prog.snapshot()
+		e.fail(run, fmt.Sprintf("output length cannot exceed %d", e.cfg.MaxOutputLength))
+
+	case err != nil:
+		logger.Error().Err(err).Msg("async query run failed")
+
+		run.Output, _ = prog.snapshot()
+		e.fail(run, failureReason(err))
+
+	default:
+		run.Output = output
+		run.Status = queryrun.StatusSucceeded
+		e.save(run)
+
+		logger.Info().Dur("elapsed", run.ExecutionTime).Msg("async run has been finished")
+	}
+}
+
+// saveProgress saves the run partial output until finished is closed.
+func (e *Executor) saveProgress(base *queryrun.Run, prog *progress, finished <-chan struct{}) {
+	t := time.NewTicker(e.cfg.ProgressSaveInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-finished:
+			return
+
+		case <-t.C:
+		}
+
+		output, changed := prog.snapshot()
+		if !changed {
+			continue
+		}
+
+		snapshot := *base
+		snapshot.Output = output
+		e.save(&snapshot)
+	}
+}
+
+func (e *Executor) fail(run *queryrun.Run, reason string) {
+	run.Status = queryrun.StatusFailed
+	run.FailureReason = reason
+	e.save(run)
+}
+
+func (e *Executor) save(run *queryrun.Run) {
+	err := e.runRepo.Update(run)
+	if err != nil {
+		e.logger.Error().Err(err).Str("run_id", run.ID).Str("status", string(run.Status)).Msg("failed to save run")
+	}
+}
+
+// failureReason returns an error description that can be shown to users.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, qrunner.ErrNoAvailableRunners):
+		return qrunner.ErrNoAvailableRunners.Error()
+
+	case errors.Is(err, qrunner.ErrMemoryLimitExceeded):
+		return qrunner.ErrMemoryLimitExceeded.Error()
+
+	case errors.Is(err, qrunner.ErrRunNotSupported):
+		return qrunner.ErrRunNotSupported.Error()
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return "execution timeout exceeded"
+
+	case errors.Is(err, context.Canceled):
+		return ErrStopped.Error()
+
+	default:
+		return "internal error"
+	}
+}
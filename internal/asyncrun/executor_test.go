@@ -0,0 +1,238 @@
+package asyncrun
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type runnerFunc func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error)
+
+func (f runnerFunc) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+	return f(ctx, run, onOutput)
+}
+
+func newTestExecutor(t *testing.T, runner runnerFunc) (*Executor, *queryrun.MemoryRepo) {
+	repo := queryrun.NewMemoryRepository(100, 0)
+
+	cfg := DefaultConfig
+	cfg.Workers = 2
+	cfg.QueueSize = 2
+	cfg.ProgressSaveInterval = 10 * time.Millisecond
+	cfg.MaxOutputLength = 100
+
+	e := New(context.Background(), zerolog.Nop(), runner, repo, cfg)
+	e.Start()
+	t.Cleanup(func() {
+		assert.NoError(t, e.Stop(context.Background()))
+	})
+
+	return e, repo
+}
+
+func newRun() *queryrun.Run {
+	return queryrun.New("SELECT 1", "clickhouse", "head", &runsettings.ClickHouseSettings{})
+}
+
+// waitStatus waits until the run gets the expected status and returns it.
+func waitStatus(t *testing.T, repo queryrun.Repository, id string, status queryrun.Status) *queryrun.Run {
+	var run *queryrun.Run
+	require.Eventually(t, func() bool {
+		var err error
+		run, err = repo.Get(id)
+		require.NoError(t, err)
+
+		return run.Status == status
+	}, 5*time.Second, 5*time.Millisecond)
+
+	return run
+}
+
+func TestExecutor_Succeeded(t *testing.T) {
+	release := make(chan struct{})
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: "1\n"})
+		<-release
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: "2\n"})
+
+		return "1\n2\n", nil
+	})
+
+	run := newRun()
+	require.NoError(t, e.Submit(run))
+
+	// The partial output must be available while the query is running.
+	require.Eventually(t, func() bool {
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+
+		return found.Status == queryrun.StatusRunning && found.Output == "1\n"
+	}, 5*time.Second, 5*time.Millisecond)
+
+	close(release)
+
+	found := waitStatus(t, repo, run.ID, queryrun.StatusSucceeded)
+	assert.Equal(t, "1\n2\n", found.Output)
+	assert.NotZero(t, found.ExecutionTime)
+}
+
+func TestExecutor_Failed(t *testing.T) {
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		return "", errors.Wrap(qrunner.ErrNoAvailableRunners, "coordinator")
+	})
+
+	run := newRun()
+	require.NoError(t, e.Submit(run))
+
+	found := waitStatus(t, repo, run.ID, queryrun.StatusFailed)
+	assert.Equal(t, qrunner.ErrNoAvailableRunners.Error(), found.FailureReason)
+}
+
+func TestExecutor_OutputLimitExceeded(t *testing.T) {
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		for {
+			onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: "0123456789"})
+
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+
+	run := newRun()
+	require.NoError(t, e.Submit(run))
+
+	found := waitStatus(t, repo, run.ID, queryrun.StatusFailed)
+	assert.Contains(t, found.FailureReason, "output length")
+}
+
+func TestExecutor_QueueFull(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+
+		return "", nil
+	})
+
+	// 2 runs are processed by workers and 2 runs wait in the queue.
+	for i := 0; i < 2; i++ {
+		run := newRun()
+		require.NoError(t, e.Submit(run))
+		waitStatus(t, repo, run.ID, queryrun.StatusRunning)
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, e.Submit(newRun()))
+	}
+
+	run := newRun()
+	assert.ErrorIs(t, e.Submit(run), ErrQueueFull)
+
+	found, err := repo.Get(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusFailed, found.Status)
+}
+
+func TestExecutor_CancelRunning(t *testing.T) {
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: "partial"})
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	})
+
+	run := newRun()
+	require.NoError(t, e.Submit(run))
+	waitStatus(t, repo, run.ID, queryrun.StatusRunning)
+
+	require.NoError(t, e.Cancel(context.Background(), run.ID))
+
+	found, err := repo.Get(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusCancelled, found.Status)
+	assert.Equal(t, "partial", found.Output)
+
+	assert.ErrorIs(t, e.Cancel(context.Background(), run.ID), qrunner.ErrRunNotFound)
+}
+
+func TestExecutor_CancelQueued(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var executed int32
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		atomic.AddInt32(&executed, 1)
+		<-release
+
+		return "", nil
+	})
+
+	// Occupy all workers.
+	for i := 0; i < 2; i++ {
+		run := newRun()
+		require.NoError(t, e.Submit(run))
+		waitStatus(t, repo, run.ID, queryrun.StatusRunning)
+	}
+
+	run := newRun()
+	require.NoError(t, e.Submit(run))
+	require.NoError(t, e.Cancel(context.Background(), run.ID))
+
+	found, err := repo.Get(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusCancelled, found.Status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&executed))
+}
+
+func TestExecutor_SubmitWhileStopping(t *testing.T) {
+	e, repo := newTestExecutor(t, func(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+
+	runs := make(chan *queryrun.Run, 100)
+	var submitters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		submitters.Add(1)
+		go func() {
+			defer submitters.Done()
+			for j := 0; j < 25; j++ {
+				run := newRun()
+				err := e.Submit(run)
+				if errors.Is(err, ErrStopped) && run.Status == "" {
+					// The executor had been stopped before the run was saved.
+					continue
+				}
+				runs <- run
+			}
+		}()
+	}
+
+	require.NoError(t, e.Stop(context.Background()))
+	submitters.Wait()
+	close(runs)
+
+	// No run is left queued after the executor is stopped.
+	for run := range runs {
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+		assert.NotEqual(t, queryrun.StatusQueued, found.Status)
+	}
+}
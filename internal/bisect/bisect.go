@@ -0,0 +1,145 @@
+package bisect
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownVersion = errors.New("unknown version")
+	ErrSameImage      = errors.New("good and bad versions point to the same image")
+	ErrGoodIsBad      = errors.New("the known-good version behaves as bad")
+	ErrBadIsGood      = errors.New("the known-bad version behaves as good")
+)
+
+// Check runs the query against the version and tells whether the version behaves as good.
+type Check func(ctx context.Context, version string) (good bool, err error)
+
+type Step struct {
+	Version string
+	Good    bool
+}
+
+type Result struct {
+	// LastGood and FirstBad are adjacent versions in the searched list where behavior diverged.
+	LastGood string
+	FirstBad string
+
+	// Steps contains checked versions in the order of execution.
+	Steps []Step
+}
+
+// Candidates returns versions between the good and the bad ones (inclusively)
+// ordered from the good version to the bad one.
+//
+// Images are expected to be ordered as dockertag.Cache.GetAll returns them.
+// The bad version may be either newer or older than the good one.
+// Adjacent tags of the same image (e.g. 22.8 and 22.8.20.11) are checked only once.
+func Candidates(images []dockertag.Image, good, bad string) ([]string, error) {
+	goodIdx, badIdx := -1, -1
+	for i, img := range images {
+		if strings.EqualFold(img.Tag, good) {
+			goodIdx = i
+		}
+		if strings.EqualFold(img.Tag, bad) {
+			badIdx = i
+		}
+	}
+
+	if goodIdx == -1 {
+		return nil, errors.Wrapf(ErrUnknownVersion, "good version %s", good)
+	}
+	if badIdx == -1 {
+		return nil, errors.Wrapf(ErrUnknownVersion, "bad version %s", bad)
+	}
+
+	goodImg, badImg := images[goodIdx], images[badIdx]
+	if goodIdx == badIdx || (goodImg.Digest != "" && goodImg.Digest == badImg.Digest) {
+		return nil, ErrSameImage
+	}
+
+	step := 1
+	if badIdx < goodIdx {
+		step = -1
+	}
+
+	versions := []string{goodImg.Tag}
+	lastDigest := goodImg.Digest
+	for i := goodIdx + step; i != badIdx; i += step {
+		img := images[i]
+		if img.Digest != "" && (img.Digest == lastDigest || img.Digest == badImg.Digest) {
+			continue
+		}
+
+		versions = append(versions, img.Tag)
+		lastDigest = img.Digest
+	}
+
+	return append(versions, badImg.Tag), nil
+}
+
+// Search binary-searches the first bad version.
+//
+// The first version must be good and the last one must be bad, both are checked at first.
+// The behavior is expected to be changed once, so every version before the found one is considered good.
+// Steps are returned even if the search fails.
+func Search(ctx context.Context, versions []string, check Check) (Result, error) {
+	var result Result
+	if len(versions) < 2 {
+		return result, errors.New("at least 2 versions are required")
+	}
+
+	checkStep := func(version string) (bool, error) {
+		good, err := check(ctx, version)
+		if err != nil {
+			return false, errors.Wrapf(err, "version %s cannot be checked", version)
+		}
+
+		result.Steps = append(result.Steps, Step{Version: version, Good: good})
+
+		return good, nil
+	}
+
+	lo, hi := 0, len(versions)-1
+
+	good, err := checkStep(versions[lo])
+	if err != nil {
+		return result, err
+	}
+	if !good {
+		return result, ErrGoodIsBad
+	}
+
+	good, err = checkStep(versions[hi])
+	if err != nil {
+		return result, err
+	}
+	if good {
+		return result, ErrBadIsGood
+	}
+
+	// Invariant: versions[lo] is good, versions[hi] is bad.
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+
+		good, err = checkStep(versions[mid])
+		if err != nil {
+			return result, err
+		}
+
+		if good {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	result.LastGood = versions[lo]
+	result.FirstBad = versions[hi]
+
+	return result, nil
+}
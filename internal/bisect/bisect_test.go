@@ -0,0 +1,121 @@
+package bisect
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func images(tags ...string) []dockertag.Image {
+	var result []dockertag.Image
+	for _, tag := range tags {
+		result = append(result, dockertag.Image{Tag: tag, Digest: "sha256:" + tag})
+	}
+
+	return result
+}
+
+func TestCandidates(t *testing.T) {
+	all := images("head", "latest", "23.3", "23.2", "23.1", "22.12", "22.8")
+
+	versions, err := Candidates(all, "22.12", "23.2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"22.12", "23.1", "23.2"}, versions)
+
+	versions, err = Candidates(all, "23.3", "22.8")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"23.3", "23.2", "23.1", "22.12", "22.8"}, versions)
+
+	versions, err = Candidates(all, "23.1", "23.2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"23.1", "23.2"}, versions)
+}
+
+func TestCandidates_SameDigest(t *testing.T) {
+	all := images("23.3", "23.2", "23.2.1", "23.1", "22.12")
+	all[0].Digest = "sha256:bad"
+	all[1].Digest = "sha256:bad"
+	all[2].Digest = "sha256:bad"
+	all[4].Digest = "sha256:good"
+
+	versions, err := Candidates(all, "22.12", "23.3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"22.12", "23.1", "23.3"}, versions)
+
+	_, err = Candidates(all, "23.2", "23.3")
+	assert.ErrorIs(t, err, ErrSameImage)
+}
+
+func TestCandidates_UnknownVersion(t *testing.T) {
+	all := images("23.3", "23.2")
+
+	_, err := Candidates(all, "23.3", "1.1")
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	_, err = Candidates(all, "1.1", "23.3")
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
+
+// checkBefore returns a check that treats versions with index less than firstBad as good.
+func checkBefore(versions []string, firstBad int, calls *[]string) Check {
+	return func(_ context.Context, version string) (bool, error) {
+		*calls = append(*calls, version)
+
+		for i, v := range versions {
+			if v == version {
+				return i < firstBad, nil
+			}
+		}
+
+		return false, errors.New("unexpected version")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	versions := []string{"v0", "v1", "v2", "v3", "v4", "v5", "v6", "v7", "v8", "v9"}
+
+	for firstBad := 1; firstBad < len(versions); firstBad++ {
+		var calls []string
+		result, err := Search(context.Background(), versions, checkBefore(versions, firstBad, &calls))
+		require.NoError(t, err)
+
+		assert.Equal(t, versions[firstBad-1], result.LastGood)
+		assert.Equal(t, versions[firstBad], result.FirstBad)
+		assert.Equal(t, len(calls), len(result.Steps))
+		assert.LessOrEqual(t, len(calls), 2+4) // Endpoints and log2(10) rounded up.
+
+		assert.Equal(t, Step{Version: "v0", Good: true}, result.Steps[0])
+		assert.Equal(t, Step{Version: "v9", Good: false}, result.Steps[1])
+	}
+}
+
+func TestSearch_InvalidEndpoints(t *testing.T) {
+	versions := []string{"v0", "v1", "v2"}
+
+	var calls []string
+	_, err := Search(context.Background(), versions, checkBefore(versions, 0, &calls))
+	assert.ErrorIs(t, err, ErrGoodIsBad)
+
+	_, err = Search(context.Background(), versions, checkBefore(versions, 3, &calls))
+	assert.ErrorIs(t, err, ErrBadIsGood)
+}
+
+func TestSearch_CheckFailed(t *testing.T) {
+	versions := []string{"v0", "v1", "v2"}
+
+	result, err := Search(context.Background(), versions, func(_ context.Context, version string) (bool, error) {
+		if version == "v1" {
+			return false, errors.New("runner failed")
+		}
+
+		return version == "v0", nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "version v1 cannot be checked")
+	assert.Len(t, result.Steps, 2)
+}
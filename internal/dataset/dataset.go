@@ -0,0 +1,113 @@
+package dataset
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FormatSQL is the format of datasets that are SQL scripts executed as is.
+const FormatSQL = "SQL"
+
+var (
+	nameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	tableRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+)
+
+// Dataset is a named set of data that can be loaded before the user query.
+//
+// The dataset file is located on the host where containers are started.
+// It's either a SQL script or a file in a ClickHouse input format (e.g. CSV or Native),
+// in the latter case the table is created with Schema and the file is inserted into it.
+type Dataset struct {
+	Name string
+
+	// Version is recorded in runs, it must be changed when the dataset is changed.
+	Version string
+
+	// Path is an absolute path to the file on the container host.
+	Path string
+
+	// Format is SQL or a ClickHouse input format.
+	Format string
+
+	// Schema and Table are required for data files. Schema is a statement
+	// that creates the table the data is inserted into.
+	Schema string
+	Table  string
+}
+
+func (d *Dataset) Validate() error {
+	if !nameRegexp.MatchString(d.Name) {
+		return errors.Errorf("invalid dataset name %q", d.Name)
+	}
+	if d.Version == "" {
+		return errors.Errorf("dataset %s: version is required", d.Name)
+	}
+	if !strings.HasPrefix(d.Path, "/") {
+		return errors.Errorf("dataset %s: path must be absolute", d.Name)
+	}
+	if d.Format == "" {
+		return errors.Errorf("dataset %s: format is required", d.Name)
+	}
+
+	if d.Format == FormatSQL {
+		return nil
+	}
+
+	if !nameRegexp.MatchString(d.Format) {
+		return errors.Errorf("dataset %s: invalid format %q", d.Name, d.Format)
+	}
+	if d.Schema == "" {
+		return errors.Errorf("dataset %s: schema is required for the %s format", d.Name, d.Format)
+	}
+	if !tableRegexp.MatchString(d.Table) {
+		return errors.Errorf("dataset %s: invalid table %q", d.Name, d.Table)
+	}
+
+	return nil
+}
+
+// Registry contains datasets configured by admins.
+type Registry struct {
+	datasets map[string]Dataset
+	names    []string
+}
+
+func NewRegistry(datasets []Dataset) (*Registry, error) {
+	r := &Registry{
+		datasets: make(map[string]Dataset, len(datasets)),
+	}
+
+	for _, d := range datasets {
+		err := d.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, exists := r.datasets[d.Name]; exists {
+			return nil, errors.Errorf("dataset names must be unique, but %s is not unique", d.Name)
+		}
+
+		r.datasets[d.Name] = d
+		r.names = append(r.names, d.Name)
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Get(name string) (Dataset, bool) {
+	d, found := r.datasets[name]
+	return d, found
+}
+
+// GetAll returns datasets in the configured order.
+func (r *Registry) GetAll() []Dataset {
+	datasets := make([]Dataset, 0, len(r.names))
+	for _, name := range r.names {
+		datasets = append(datasets, r.datasets[name])
+	}
+
+	return datasets
+}
@@ -0,0 +1,60 @@
+package dataset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry(t *testing.T) {
+	hits := Dataset{
+		Name:    "hits",
+		Version: "2024-01",
+		Path:    "/datasets/hits.csv",
+		Format:  "CSVWithNames",
+		Schema:  "CREATE TABLE hits (id UInt64, url String) ENGINE = MergeTree ORDER BY id",
+		Table:   "hits",
+	}
+	tpch := Dataset{
+		Name:    "tpch",
+		Version: "1",
+		Path:    "/datasets/tpch.sql",
+		Format:  FormatSQL,
+	}
+
+	r, err := NewRegistry([]Dataset{tpch, hits})
+	require.NoError(t, err)
+
+	found, ok := r.Get("hits")
+	assert.True(t, ok)
+	assert.Equal(t, hits, found)
+
+	_, ok = r.Get("unknown")
+	assert.False(t, ok)
+
+	assert.Equal(t, []Dataset{tpch, hits}, r.GetAll())
+}
+
+func TestNewRegistry_Invalid(t *testing.T) {
+	valid := Dataset{Name: "tpch", Version: "1", Path: "/datasets/tpch.sql", Format: FormatSQL}
+
+	cases := []struct {
+		name     string
+		datasets []Dataset
+	}{
+		{name: "duplicate", datasets: []Dataset{valid, valid}},
+		{name: "invalid name", datasets: []Dataset{{Name: "a b", Version: "1", Path: "/a.sql", Format: FormatSQL}}},
+		{name: "missed version", datasets: []Dataset{{Name: "a", Path: "/a.sql", Format: FormatSQL}}},
+		{name: "relative path", datasets: []Dataset{{Name: "a", Version: "1", Path: "a.sql", Format: FormatSQL}}},
+		{name: "missed schema", datasets: []Dataset{{Name: "a", Version: "1", Path: "/a.csv", Format: "CSV", Table: "a"}}},
+		{name: "invalid table", datasets: []Dataset{{Name: "a", Version: "1", Path: "/a.csv", Format: "CSV", Schema: "CREATE TABLE a", Table: "a; DROP"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRegistry(tc.datasets)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package dbdriver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	Register(ClickHouse{})
+}
+
+// ClickHouse executes queries with clickhouse client in a clickhouse-server container.
+type ClickHouse struct{}
+
+func (ClickHouse) Type() dbsettings.Type {
+	return dbsettings.TypeClickHouse
+}
+
+func (ClickHouse) Repositories() []string {
+	return []string{"clickhouse/clickhouse-server", "yandex/clickhouse-server"}
+}
+
+func (ClickHouse) ConvertSettings(input SettingsInput) (runsettings.RunSettings, error) {
+	return &runsettings.ClickHouseSettings{
+		OutputFormat:    input.OutputFormat,
+		Structured:      input.Structured,
+		SplitStatements: input.SplitStatements,
+		Settings:        input.Settings,
+	}, nil
+}
+
+func (ClickHouse) RunOptions(settings runsettings.RunSettings) RunOptions {
+	chSettings, ok := settings.(*runsettings.ClickHouseSettings)
+	if !ok {
+		return RunOptions{}
+	}
+
+	return RunOptions{
+		Structured:      chSettings.Structured,
+		SplitStatements: chSettings.SplitStatements,
+	}
+}
+
+func (ClickHouse) QueryCommand(version string, query string, settings runsettings.RunSettings, defaultOutputFormat string) ([]string, error) {
+	chSettings, ok := settings.(*runsettings.ClickHouseSettings)
+	if !ok {
+		return nil, errors.Errorf("invalid settings for type %s", settings.Type())
+	}
+
+	args := []string{
+		"clickhouse", "client",
+		"-n",
+		"-m",
+		"--query", query,
+	}
+	args = append(args, chSettings.FormatArgs(version, defaultOutputFormat)...)
+	args = append(args, chSettings.SettingArgs()...)
+
+	return args, nil
+}
+
+// LocalQueryCommand returns the clickhouse local command, it executes multi-statement queries by default.
+func (ClickHouse) LocalQueryCommand(version string, query string, settings runsettings.RunSettings, defaultOutputFormat string) ([]string, error) {
+	chSettings, ok := settings.(*runsettings.ClickHouseSettings)
+	if !ok {
+		return nil, errors.Errorf("invalid settings for type %s", settings.Type())
+	}
+
+	args := []string{"clickhouse", "local", "--query", query}
+	args = append(args, chSettings.FormatArgs(version, defaultOutputFormat)...)
+	args = append(args, chSettings.SettingArgs()...)
+
+	return args, nil
+}
+
+func (ClickHouse) NeedsServer(query string) bool {
+	return chspec.NeedsServer(query)
+}
+
+func (ClickHouse) ReadinessCommand() []string {
+	return []string{"clickhouse", "client", "--query", "SELECT 1"}
+}
+
+func (ClickHouse) IsStarting(stderr string) bool {
+	return !chspec.CheckIfClickHouseIsReady(stderr)
+}
+
+// HTTPParams returns parameters of the ClickHouse HTTP interface.
+func (ClickHouse) HTTPParams(settings runsettings.RunSettings, defaultOutputFormat string) (url.Values, error) {
+	chSettings, ok := settings.(*runsettings.ClickHouseSettings)
+	if !ok {
+		return nil, errors.Errorf("invalid settings for type %s", settings.Type())
+	}
+
+	return chSettings.HTTPParams(defaultOutputFormat), nil
+}
+
+// QueryError parses the exception printed by the client.
+// Non-empty stderr is not an error itself, the client may print warnings.
+func (ClickHouse) QueryError(stderr string, exitCode int) *queryrun.QueryError {
+	exc, found := chspec.ParseException(stderr)
+	if !found && exitCode == 0 {
+		return nil
+	}
+
+	if !found {
+		exc.Message = strings.TrimSpace(stderr)
+	}
+
+	return &queryrun.QueryError{
+		Code:     exc.Code,
+		Name:     exc.Name,
+		Message:  exc.Message,
+		ExitCode: exitCode,
+	}
+}
+
+func (ClickHouse) SplitStatements(query string) []string {
+	return chspec.SplitStatements(query)
+}
+
+func (ClickHouse) IsSessionStatement(statement string) bool {
+	return chspec.IsSessionStatement(statement)
+}
+
+// ResultSetQuery adds the JSONCompact format to statements that return rows and have no explicit format.
+// Nondeterministic statements are skipped, their second execution may return different rows.
+func (ClickHouse) ResultSetQuery(statement string) (string, bool) {
+	if !chspec.ReturnsRows(statement) || chspec.HasFormatClause(statement) || chspec.IsNondeterministic(statement) {
+		return "", false
+	}
+
+	// The format is added on a new line in case the statement ends with a comment.
+	return statement + "\nFORMAT " + chspec.FormatJSONCompact, true
+}
+
+func (ClickHouse) ParseResultSet(output string) (*queryrun.ResultSet, error) {
+	parsed, err := chspec.ParseJSONCompact(output)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]queryrun.Column, 0, len(parsed.Meta))
+	for _, meta := range parsed.Meta {
+		columns = append(columns, queryrun.Column{Name: meta.Name, Type: meta.Type})
+	}
+
+	rows := make([]byte, 0, 2)
+	rows = append(rows, '[')
+	for i, row := range parsed.Data {
+		if i > 0 {
+			rows = append(rows, ',')
+		}
+		rows = append(rows, row...)
+	}
+	rows = append(rows, ']')
+
+	return &queryrun.ResultSet{
+		Columns: columns,
+		Rows:    rows,
+		Statistics: queryrun.Statistics{
+			Rows:      parsed.Rows,
+			RowsRead:  parsed.Statistics.RowsRead,
+			BytesRead: parsed.Statistics.BytesRead,
+			Elapsed:   parsed.Statistics.Elapsed,
+		},
+	}, nil
+}
+
+// DatasetLoadCommand executes SQL scripts with clickhouse client, data files are inserted into the table
+// created with the dataset schema. Names and formats are validated, the schema is passed
+// as a positional argument to avoid quoting.
+func (ClickHouse) DatasetLoadCommand(d dataset.Dataset, file string) []string {
+	if d.Format == dataset.FormatSQL {
+		return []string{"sh", "-c", fmt.Sprintf("clickhouse client -n -m < '%s'", file)}
+	}
+
+	script := fmt.Sprintf(`clickhouse client -n -m --query "$1" && clickhouse client --query "INSERT INTO %s FORMAT %s" < '%s'`, d.Table, d.Format, file)
+
+	return []string{"sh", "-c", script, "sh", d.Schema}
+}
+
+func (ClickHouse) ServerConfigDir() string {
+	return "/etc/clickhouse-server/config.d"
+}
+
+func (ClickHouse) UsersConfigDir() string {
+	return "/etc/clickhouse-server/users.d"
+}
+
+// FilesDir is the directory the file() table function reads files from.
+func (ClickHouse) FilesDir() string {
+	return "/var/lib/clickhouse/user_files"
+}
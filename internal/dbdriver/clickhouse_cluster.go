@@ -0,0 +1,71 @@
+package dbdriver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+)
+
+const (
+	// ClusterName is the name of the cluster in remote_servers, it's also available as the {cluster} macro.
+	ClusterName = "default"
+
+	keeperPort = 9181
+	raftPort   = 9234
+	nativePort = 9000
+)
+
+// keeperProbeQuery fails until servers can reach Keeper.
+const keeperProbeQuery = "SELECT count() FROM system.zookeeper WHERE path = '/'"
+
+// CoordinatorConfig returns the config of a server with the embedded single-node Keeper.
+func (ClickHouse) CoordinatorConfig(host string) string {
+	return fmt.Sprintf(`<clickhouse>
+    <keeper_server>
+        <tcp_port>%d</tcp_port>
+        <server_id>1</server_id>
+        <log_storage_path>/var/lib/clickhouse/coordination/log</log_storage_path>
+        <snapshot_storage_path>/var/lib/clickhouse/coordination/snapshots</snapshot_storage_path>
+        <raft_configuration>
+            <server><id>1</id><hostname>%s</hostname><port>%d</port></server>
+        </raft_configuration>
+    </keeper_server>
+</clickhouse>
+`, keeperPort, host, raftPort)
+}
+
+// NodeConfig returns the server config with the cluster definition, Keeper address and macros of the node,
+// so ON CLUSTER queries and Replicated tables with {shard} and {replica} macros work.
+func (ClickHouse) NodeConfig(t queryrun.Topology, node ClusterNode, coordinatorHost string) string {
+	var b strings.Builder
+
+	b.WriteString("<clickhouse>\n")
+	b.WriteString("    <remote_servers>\n")
+	fmt.Fprintf(&b, "        <%s>\n", ClusterName)
+	for shard := 1; shard <= t.Shards; shard++ {
+		b.WriteString("            <shard>\n")
+		b.WriteString("                <internal_replication>true</internal_replication>\n")
+		for _, n := range ClusterNodes(t) {
+			if n.Shard != shard {
+				continue
+			}
+			fmt.Fprintf(&b, "                <replica><host>%s</host><port>%d</port></replica>\n", n.Host, nativePort)
+		}
+		b.WriteString("            </shard>\n")
+	}
+	fmt.Fprintf(&b, "        </%s>\n", ClusterName)
+	b.WriteString("    </remote_servers>\n")
+	fmt.Fprintf(&b, "    <zookeeper>\n        <node><host>%s</host><port>%d</port></node>\n    </zookeeper>\n", coordinatorHost, keeperPort)
+	b.WriteString("    <distributed_ddl>\n        <path>/clickhouse/task_queue/ddl</path>\n    </distributed_ddl>\n")
+	fmt.Fprintf(&b, "    <macros>\n        <cluster>%s</cluster>\n        <shard>%d</shard>\n        <replica>%s</replica>\n    </macros>\n",
+		ClusterName, node.Shard, node.Host)
+	b.WriteString("</clickhouse>\n")
+
+	return b.String()
+}
+
+// ClusterReadinessCommand queries Keeper from the node.
+func (ClickHouse) ClusterReadinessCommand() []string {
+	return []string{"clickhouse", "client", "--query", keeperProbeQuery}
+}
@@ -0,0 +1,50 @@
+package dbdriver
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickHouse_NodeConfig(t *testing.T) {
+	topology := queryrun.Topology{Shards: 2, Replicas: 2}
+
+	nodes := ClusterNodes(topology)
+	require.Len(t, nodes, 4)
+	assert.Equal(t, ClusterNode{Host: "s2r1", Shard: 2, Replica: 1}, nodes[2])
+
+	var config struct {
+		Shards []struct {
+			Replicas []struct {
+				Host string `xml:"host"`
+				Port int    `xml:"port"`
+			} `xml:"replica"`
+		} `xml:"remote_servers>default>shard"`
+		Keeper string `xml:"zookeeper>node>host"`
+		Macros struct {
+			Cluster string `xml:"cluster"`
+			Shard   int    `xml:"shard"`
+			Replica string `xml:"replica"`
+		} `xml:"macros"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(ClickHouse{}.NodeConfig(topology, nodes[2], "keeper")), &config))
+
+	require.Len(t, config.Shards, 2)
+	require.Len(t, config.Shards[1].Replicas, 2)
+	assert.Equal(t, "s2r2", config.Shards[1].Replicas[1].Host)
+	assert.Equal(t, nativePort, config.Shards[1].Replicas[1].Port)
+	assert.Equal(t, "keeper", config.Keeper)
+	assert.Equal(t, ClusterName, config.Macros.Cluster)
+	assert.Equal(t, 2, config.Macros.Shard)
+	assert.Equal(t, "s2r1", config.Macros.Replica)
+
+	var keeper struct {
+		Port int `xml:"keeper_server>tcp_port"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(ClickHouse{}.CoordinatorConfig("keeper")), &keeper))
+	assert.Equal(t, keeperPort, keeper.Port)
+}
@@ -0,0 +1,94 @@
+package dbdriver
+
+import (
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGet(t *testing.T) {
+	driver, found := Get(dbsettings.TypeClickHouse)
+	require.True(t, found)
+	assert.Equal(t, ClickHouse{}, driver)
+
+	_, found = Get("postgres")
+	assert.False(t, found)
+}
+
+func TestClickHouse_QueryCommand(t *testing.T) {
+	settings, err := ClickHouse{}.ConvertSettings(SettingsInput{
+		OutputFormat: "JSON",
+		Settings:     map[string]string{"max_threads": "1"},
+	})
+	require.NoError(t, err)
+
+	args, err := ClickHouse{}.QueryCommand("23.3", "SELECT 1", settings, "TabSeparated")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"clickhouse", "client", "-n", "-m", "--query", "SELECT 1",
+		"--output_format_pretty_color", "0", "--format", "JSON",
+		"--max_threads=1",
+	}, args)
+
+	// Old versions don't support the --format flag.
+	args, err = ClickHouse{}.QueryCommand("20.3", "SELECT 1", &runsettings.ClickHouseSettings{}, "TabSeparated")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"clickhouse", "client", "-n", "-m", "--query", "SELECT 1"}, args)
+}
+
+func TestClickHouse_LocalQueryCommand(t *testing.T) {
+	settings, err := ClickHouse{}.ConvertSettings(SettingsInput{
+		OutputFormat: "JSON",
+		Settings:     map[string]string{"max_threads": "1"},
+	})
+	require.NoError(t, err)
+
+	args, err := ClickHouse{}.LocalQueryCommand("23.3", "SELECT 1", settings, "TabSeparated")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"clickhouse", "local", "--query", "SELECT 1",
+		"--output_format_pretty_color", "0", "--format", "JSON",
+		"--max_threads=1",
+	}, args)
+}
+
+func TestClickHouse_IsStarting(t *testing.T) {
+	assert.True(t, ClickHouse{}.IsStarting("Code: 210. DB::NetException: Connection refused (localhost:9000)"))
+	assert.False(t, ClickHouse{}.IsStarting("Code: 516. DB::Exception: default: Authentication failed"))
+}
+
+func TestClickHouse_DatasetLoadCommand(t *testing.T) {
+	script := dataset.Dataset{Name: "tpch", Version: "1", Path: "/data/tpch-v1.sql", Format: dataset.FormatSQL}
+	assert.Equal(t, []string{"sh", "-c", "clickhouse client -n -m < '/datasets/tpch.sql'"}, ClickHouse{}.DatasetLoadCommand(script, "/datasets/tpch.sql"))
+
+	schema := "CREATE TABLE hits (id UInt64, url String) ENGINE = MergeTree ORDER BY id"
+	data := dataset.Dataset{Name: "hits", Version: "1", Path: "/data/hits.csv", Format: "CSVWithNames", Schema: schema, Table: "hits"}
+	assert.Equal(t, []string{
+		"sh", "-c",
+		`clickhouse client -n -m --query "$1" && clickhouse client --query "INSERT INTO hits FORMAT CSVWithNames" < '/datasets/hits.csv'`,
+		"sh", schema,
+	}, ClickHouse{}.DatasetLoadCommand(data, "/datasets/hits.csv"))
+}
+
+func TestClickHouse_ResultSetQuery(t *testing.T) {
+	query, ok := ClickHouse{}.ResultSetQuery("SELECT 1 -- one")
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT 1 -- one\nFORMAT JSONCompact", query)
+
+	_, ok = ClickHouse{}.ResultSetQuery("SELECT 1 FORMAT CSV")
+	assert.False(t, ok)
+
+	_, ok = ClickHouse{}.ResultSetQuery("CREATE TABLE t (x UInt8) ENGINE = Memory")
+	assert.False(t, ok)
+
+	_, ok = ClickHouse{}.ResultSetQuery("INSERT INTO t SELECT number FROM numbers(10)")
+	assert.False(t, ok)
+
+	_, ok = ClickHouse{}.ResultSetQuery("SELECT now(), rand()")
+	assert.False(t, ok)
+}
@@ -0,0 +1,169 @@
+package dbdriver
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+)
+
+// Driver describes how queries of a database are executed in containers.
+// Runners don't depend on a particular database, they get the driver by the run database.
+type Driver interface {
+	Type() dbsettings.Type
+
+	// Repositories returns default image repositories of the database.
+	// If a tag is presented in several repositories, the first one is used.
+	Repositories() []string
+
+	// ConvertSettings converts settings of a run request.
+	ConvertSettings(input SettingsInput) (runsettings.RunSettings, error)
+
+	// RunOptions returns options stored in the run settings that change how the runner executes the query.
+	RunOptions(settings runsettings.RunSettings) RunOptions
+
+	// QueryCommand returns the command that executes the query in a container.
+	QueryCommand(version string, query string, settings runsettings.RunSettings, defaultOutputFormat string) ([]string, error)
+
+	// QueryError returns the database error reported by the client or nil if the query has succeeded.
+	QueryError(stderr string, exitCode int) *queryrun.QueryError
+
+	// ReadinessCommand returns the command that exits with zero code once the database accepts queries.
+	ReadinessCommand() []string
+
+	// IsStarting reports whether the readiness command has failed because the database is still starting,
+	// such failures are expected and not logged.
+	IsStarting(stderr string) bool
+
+	// SplitStatements splits the query into statements without trailing delimiters.
+	SplitStatements(query string) []string
+
+	// IsSessionStatement reports whether the statement changes the client session (e.g. SET or USE),
+	// such statements are repeated when statements are executed in separate sessions.
+	IsSessionStatement(statement string) bool
+
+	// ResultSetQuery returns the statement that outputs the result of the given one in a format
+	// ParseResultSet understands. The statement is executed once again to get the result, so false is returned
+	// if the statement doesn't return rows in a structured way or its results may differ between executions.
+	ResultSetQuery(statement string) (string, bool)
+
+	// ParseResultSet parses the output of a query returned by ResultSetQuery.
+	ParseResultSet(output string) (*queryrun.ResultSet, error)
+
+	// DatasetLoadCommand returns the command that loads the dataset file located at the path in the container.
+	DatasetLoadCommand(d dataset.Dataset, file string) []string
+
+	// ServerConfigDir and UsersConfigDir are directories in the container the server reads
+	// additional server and user configs from.
+	ServerConfigDir() string
+	UsersConfigDir() string
+
+	// FilesDir is the directory in the container queries can read input files from.
+	FilesDir() string
+}
+
+// RunOptions are options of a run that change how the runner executes the query.
+type RunOptions struct {
+	// Structured requests the result of the last statement to be returned as a structured result set.
+	Structured bool
+
+	// SplitStatements requests statements to be executed separately to get their results separately.
+	SplitStatements bool
+}
+
+// LocalDriver is implemented by drivers that can execute queries without starting a server.
+type LocalDriver interface {
+	Driver
+
+	// LocalQueryCommand returns the command that executes the query in a container without a server.
+	LocalQueryCommand(version string, query string, settings runsettings.RunSettings, defaultOutputFormat string) ([]string, error)
+
+	// NeedsServer reports whether the query uses features that are available only on a server.
+	NeedsServer(query string) bool
+}
+
+// HTTPDriver is implemented by drivers whose servers accept queries via HTTP.
+type HTTPDriver interface {
+	Driver
+
+	// HTTPParams returns query parameters of the HTTP request that executes a query with the settings.
+	HTTPParams(settings runsettings.RunSettings, defaultOutputFormat string) (url.Values, error)
+}
+
+// ClusterDriver is implemented by drivers that can execute queries against a cluster of servers
+// coordinated by a separate coordination server (e.g. ClickHouse Keeper).
+type ClusterDriver interface {
+	Driver
+
+	// CoordinatorConfig returns the config of the coordination server available by the host name.
+	CoordinatorConfig(host string) string
+
+	// NodeConfig returns the config of the cluster node. Nodes are available by their host names,
+	// the coordination server is available by coordinatorHost.
+	NodeConfig(topology queryrun.Topology, node ClusterNode, coordinatorHost string) string
+
+	// ClusterReadinessCommand returns the command that exits with zero code once the node
+	// can reach the coordination server.
+	ClusterReadinessCommand() []string
+}
+
+// ClusterNode is a server of a cluster. Shards and replicas are numbered from 1.
+type ClusterNode struct {
+	Host    string
+	Shard   int
+	Replica int
+}
+
+// ClusterNodes returns servers of the topology ordered by shards and replicas.
+func ClusterNodes(t queryrun.Topology) []ClusterNode {
+	nodes := make([]ClusterNode, 0, t.Nodes())
+	for shard := 1; shard <= t.Shards; shard++ {
+		for replica := 1; replica <= t.Replicas; replica++ {
+			nodes = append(nodes, ClusterNode{
+				Host:    fmt.Sprintf("s%dr%d", shard, replica),
+				Shard:   shard,
+				Replica: replica,
+			})
+		}
+	}
+
+	return nodes
+}
+
+// SettingsInput contains settings of a run request that are common for databases.
+type SettingsInput struct {
+	OutputFormat    string
+	Structured      bool
+	SplitStatements bool
+	Settings        map[string]string
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[dbsettings.Type]Driver)
+)
+
+// Register makes the driver available by its type. It panics if the type is already registered.
+func Register(d Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if _, exists := drivers[d.Type()]; exists {
+		panic(fmt.Sprintf("driver %s is already registered", d.Type()))
+	}
+
+	drivers[d.Type()] = d
+}
+
+func Get(t dbsettings.Type) (Driver, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	d, found := drivers[t]
+
+	return d, found
+}
@@ -1,13 +1,31 @@
 package runsettings
 
 import (
+	"net/url"
+	"sort"
+
 	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
 	"github.com/lodthe/clickhouse-playground/pkg/chspec"
 )
 
+func init() {
+	Register(dbsettings.TypeClickHouse, func() RunSettings {
+		return &ClickHouseSettings{}
+	})
+}
+
 // ClickHouseSettings contains settings for clickhouse client
 type ClickHouseSettings struct {
 	OutputFormat string `dynamodbav:"OutputFormat"`
+
+	// Structured requests the result of the last statement to be returned as a structured result set.
+	Structured bool `dynamodbav:"Structured" json:",omitempty"`
+
+	// SplitStatements requests statements to be executed separately to get their results separately.
+	SplitStatements bool `dynamodbav:"SplitStatements" json:",omitempty"`
+
+	// Settings are ClickHouse settings applied to the query, e.g. max_threads.
+	Settings map[string]string `dynamodbav:"Settings" json:",omitempty"`
 }
 
 func (cs *ClickHouseSettings) Type() dbsettings.Type {
@@ -34,3 +52,37 @@ func (cs *ClickHouseSettings) FormatArgs(version string, defaultOutputFormat str
 
 	return result
 }
+
+// SettingArgs gets client args for settings, they are sorted by name to get reproducible commands.
+func (cs *ClickHouseSettings) SettingArgs() []string {
+	names := make([]string, 0, len(cs.Settings))
+	for name := range cs.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, "--"+name+"="+cs.Settings[name])
+	}
+
+	return args
+}
+
+// HTTPParams gets query parameters for custom output formatting via the HTTP interface.
+func (cs *ClickHouseSettings) HTTPParams(defaultOutputFormat string) url.Values {
+	outputFormat := defaultOutputFormat
+	if cs.OutputFormat != "" {
+		outputFormat = cs.OutputFormat
+	}
+
+	params := url.Values{
+		"default_format":             []string{outputFormat},
+		"output_format_pretty_color": []string{"0"},
+	}
+	for name, value := range cs.Settings {
+		params.Set(name, value)
+	}
+
+	return params
+}
@@ -1,8 +1,44 @@
 package runsettings
 
-import "github.com/lodthe/clickhouse-playground/internal/dbsettings"
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+)
 
 // RunSettings interface define custom settings for different databases
 type RunSettings interface {
 	Type() dbsettings.Type
 }
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[dbsettings.Type]func() RunSettings)
+)
+
+// Register makes settings of the database decodable. It panics if the type is already registered.
+func Register(t dbsettings.Type, factory func() RunSettings) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, exists := factories[t]; exists {
+		panic(fmt.Sprintf("settings of %s are already registered", t))
+	}
+
+	factories[t] = factory
+}
+
+// New returns empty settings of the database, they are used to decode saved runs.
+// It returns nil if the database is unknown.
+func New(t dbsettings.Type) RunSettings {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	factory, found := factories[t]
+	if !found {
+		return nil
+	}
+
+	return factory()
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type ResultCacheExporter struct {
+	lookupsTotal *prometheus.CounterVec
+}
+
+var resultCacheInit sync.Once
+var resultCacheExporter *ResultCacheExporter
+
+func NewResultCacheExporter() *ResultCacheExporter {
+	resultCacheInit.Do(func() {
+		resultCacheExporter = &ResultCacheExporter{
+			lookupsTotal: promauto.NewCounterVec(
+				prometheus.CounterOpts{
+					Namespace: "result_cache",
+					Name:      "lookups_total",
+					Help:      "How many times the result cache was looked up.",
+				},
+				[]string{"status"},
+			),
+		}
+	})
+
+	return resultCacheExporter
+}
+
+func (r *ResultCacheExporter) Hit() {
+	r.observeLookup("hit")
+}
+
+func (r *ResultCacheExporter) Miss() {
+	r.observeLookup("miss")
+}
+
+func (r *ResultCacheExporter) observeLookup(status string) {
+	r.lookupsTotal.
+		With(prometheus.Labels{
+			"status": status,
+		}).
+		Inc()
+}
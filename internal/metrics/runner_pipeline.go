@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,11 +25,31 @@ func NewPipelineExporter(runnerType, runnerName string) *PipelineExporter {
 			},
 			[]string{"step", "version", "status"},
 		),
+		execExits: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   "runner",
+				Name:        "exec_exits_total",
+				Help:        "How many query execs have finished, partitioned by database version and client exit code.",
+				ConstLabels: runnerLabels,
+			},
+			[]string{"version", "exit_code"},
+		),
+		oomKills: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   "runner",
+				Name:        "oom_kills_total",
+				Help:        "How many runs have failed because a container process has been killed by the OOM killer, partitioned by database version.",
+				ConstLabels: runnerLabels,
+			},
+			[]string{"version"},
+		),
 	}
 }
 
 type PipelineExporter struct {
-	duration *prometheus.HistogramVec
+	duration  *prometheus.HistogramVec
+	execExits *prometheus.CounterVec
+	oomKills  *prometheus.CounterVec
 }
 
 func (r *PipelineExporter) observe(step string, succeed bool, version string, startedAt time.Time) {
@@ -58,6 +79,30 @@ func (r *PipelineExporter) CreateContainer(succeed bool, version string, started
 	r.observe("create_container", succeed, version, startedAt)
 }
 
+func (r *PipelineExporter) WaitReady(succeed bool, version string, startedAt time.Time) {
+	r.observe("wait_ready", succeed, version, startedAt)
+}
+
+func (r *PipelineExporter) CreateLocalContainer(succeed bool, version string, startedAt time.Time) {
+	r.observe("create_local_container", succeed, version, startedAt)
+}
+
+func (r *PipelineExporter) RunLocalQuery(succeed bool, version string, startedAt time.Time) {
+	r.observe("run_local_query", succeed, version, startedAt)
+}
+
+func (r *PipelineExporter) CreateCluster(succeed bool, version string, startedAt time.Time) {
+	r.observe("create_cluster", succeed, version, startedAt)
+}
+
+func (r *PipelineExporter) CopyFiles(succeed bool, version string, startedAt time.Time) {
+	r.observe("copy_files", succeed, version, startedAt)
+}
+
+func (r *PipelineExporter) LoadDatasets(succeed bool, version string, startedAt time.Time) {
+	r.observe("load_datasets", succeed, version, startedAt)
+}
+
 func (r *PipelineExporter) ExecCommand(succeed bool, version string, startedAt time.Time) {
 	r.observe("exec_command", succeed, version, startedAt)
 }
@@ -66,6 +111,17 @@ func (r *PipelineExporter) RunQuery(succeed bool, version string, startedAt time
 	r.observe("run_query", succeed, version, startedAt)
 }
 
+func (r *PipelineExporter) ExecExited(version string, exitCode int) {
+	r.execExits.With(prometheus.Labels{
+		"version":   version,
+		"exit_code": strconv.Itoa(exitCode),
+	}).Inc()
+}
+
+func (r *PipelineExporter) OOMKilled(version string) {
+	r.oomKills.With(prometheus.Labels{"version": version}).Inc()
+}
+
 func (r *PipelineExporter) RemoveContainer(succeed bool, version string, startedAt time.Time) {
 	r.observe("remove_container", succeed, version, startedAt)
 }
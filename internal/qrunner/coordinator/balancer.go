@@ -1,10 +1,14 @@
 package coordinator
 
 import (
-	"math/rand"
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
@@ -14,34 +18,44 @@ type balancer struct {
 	lock    sync.Mutex
 	runners map[string]*Runner
 
-	random *rand.Rand
-}
+	// ordered contains the same runners in the order they have been included.
+	ordered []*Runner
 
-func newBalancer(logger zerolog.Logger) *balancer {
-	// It's okay to initialize by setting time, because it's just for load balancing among runners.
-	random := rand.New(rand.NewSource(time.Now().UnixNano())) // nolint:gosec
+	strategy strategy
+
+	// released is closed and replaced when a runner may become available for a new job.
+	released chan struct{}
+}
 
+func newBalancer(logger zerolog.Logger, strategyType StrategyType) *balancer {
 	return &balancer{
-		logger:  logger,
-		runners: make(map[string]*Runner),
-		random:  random,
+		logger:   logger,
+		runners:  make(map[string]*Runner),
+		strategy: newStrategy(strategyType),
+		released: make(chan struct{}),
 	}
 }
 
 // add includes a new runner in load balancing if it hasn't been added yet.
-// It returns whether the runner hasn't already been added.
+// It returns whether the runner hasn't already been added. Only active runners are added.
 func (b *balancer) add(r *Runner) bool {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	if r.State() != RunnerStateActive {
+		return false
+	}
+
 	_, found := b.runners[r.underlying.Name()]
 	if found {
 		return false
 	}
 
 	b.runners[r.underlying.Name()] = r
+	b.ordered = append(b.ordered, r)
 
 	b.logger.Info().Str("name", r.underlying.Name()).Msg("runner has been included in load balancing")
+	b.releaseUnderLock()
 
 	return true
 }
@@ -54,6 +68,38 @@ func (b *balancer) remove(r *Runner) {
 	b.removeUnderLock(r)
 }
 
+// drain moves an active runner to the draining state and excludes it from load balancing,
+// so it's not added again. It returns false if the runner is not active.
+func (b *balancer) drain(r *Runner) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !atomic.CompareAndSwapUint32(&r.state, uint32(RunnerStateActive), uint32(RunnerStateDraining)) {
+		return false
+	}
+
+	b.removeUnderLock(r)
+
+	return true
+}
+
+// setWeight changes the weight of a runner. Jobs are dispatched with the new weight
+// as soon as the method returns.
+func (b *balancer) setWeight(r *Runner, weight uint) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	r.weight = weight
+}
+
+// weight returns the current weight of a runner.
+func (b *balancer) weight(r *Runner) uint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return r.weight
+}
+
 func (b *balancer) removeUnderLock(r *Runner) {
 	_, found := b.runners[r.underlying.Name()]
 	if !found {
@@ -61,29 +107,39 @@ func (b *balancer) removeUnderLock(r *Runner) {
 	}
 
 	delete(b.runners, r.underlying.Name())
+	for i := range b.ordered {
+		if b.ordered[i] == r {
+			b.ordered = append(b.ordered[:i], b.ordered[i+1:]...)
+			break
+		}
+	}
 
 	b.logger.Info().Str("name", r.underlying.Name()).Msg("runner has been excluded from load balancing")
 }
 
-type runnerJob = func(r *Runner)
+// runnerJob executes a run with the runner and returns the run error.
+type runnerJob = func(r *Runner) error
 
 // processJob select an available runner and executes the given job.
+// The job occupies cost slots of the runner concurrency limit, e.g. every container of a cluster,
+// and can only be executed by runners supporting the required features.
 // It returns true if a runner has been found.
-// There are no available runners when all of them are dead or have concurrency limit exhausted.
-func (b *balancer) processJob(job runnerJob) bool {
+// There are no available runners when all of them are dead, have concurrency limit exhausted
+// or don't support the features.
+func (b *balancer) processJob(cost uint32, features qrunner.Features, job runnerJob) bool {
 	var runner *Runner
 	var excluded bool
 	func() {
 		b.lock.Lock()
 		defer b.lock.Unlock()
 
-		runner = b.selectRunner()
+		runner = b.selectRunner(cost, features)
 		if runner == nil {
 			return
 		}
 
 		// Check if concurrency limit has not been exhausted.
-		concurrency := runner.addConcurrency(1)
+		concurrency := runner.addConcurrency(int32(cost))
 		if runner.maxConcurrency != nil && concurrency >= *runner.maxConcurrency {
 			b.removeUnderLock(runner)
 			excluded = true
@@ -94,38 +150,80 @@ func (b *balancer) processJob(job runnerJob) bool {
 		return false
 	}
 
-	defer runner.addConcurrency(-1)
+	defer b.release()
+	defer runner.addConcurrency(-int32(cost))
 	if excluded {
 		defer b.add(runner)
 	}
 
-	job(runner)
+	startedAt := time.Now()
+	err := job(runner)
+	b.observe(runner, time.Since(startedAt), err)
 
 	return true
 }
 
-// selectRunner implements a weighted random choice algorithm and returns a runner.
-// If the weight of r1 is 10 times the weight of r2, r1 is selected ~10 times more often.
-//
-// selectRunner must be called under the taken lock.
-func (b *balancer) selectRunner() *Runner {
-	var totalWeight uint64
-	for _, r := range b.runners {
-		totalWeight += uint64(r.weight)
+// releasedChan returns a channel that is closed when a runner may become available,
+// e.g. a job has been finished or a runner has been included in load balancing.
+func (b *balancer) releasedChan() <-chan struct{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.released
+}
+
+func (b *balancer) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.releaseUnderLock()
+}
+
+func (b *balancer) releaseUnderLock() {
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// observe passes the job duration and outcome to the strategy.
+// Jobs failed because of the run itself, e.g. cancelled by the user or killed because of the memory limit,
+// say nothing about the runner, so they are not observed.
+func (b *balancer) observe(r *Runner, elapsed time.Duration, err error) {
+	if errors.Is(err, qrunner.ErrRunCancelled) || errors.Is(err, qrunner.ErrMemoryLimitExceeded) ||
+		errors.Is(err, context.Canceled) {
+		return
 	}
 
-	if totalWeight == 0 {
-		return nil
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.strategy.observe(r, elapsed, err != nil)
+}
+
+// forget drops the state the strategy keeps for the runner. It's called when the runner is removed
+// from the coordinator, runners excluded from load balancing temporarily are not forgotten.
+func (b *balancer) forget(r *Runner) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.strategy.forget(r)
+}
+
+// selectRunner returns a runner chosen by the strategy among runners that have room for the job cost
+// and support the required features.
+//
+// selectRunner must be called under the taken lock.
+func (b *balancer) selectRunner(cost uint32, features qrunner.Features) *Runner {
+	// Included runners always have room for one more job.
+	if cost <= 1 && features == 0 {
+		return b.strategy.selectRunner(b.ordered)
 	}
 
-	rnd := b.random.Uint64() % totalWeight
-	for _, r := range b.runners {
-		if rnd < uint64(r.weight) {
-			return r
+	candidates := make([]*Runner, 0, len(b.ordered))
+	for _, r := range b.ordered {
+		if r.fits(cost) && r.supports(features) {
+			candidates = append(candidates, r)
 		}
-
-		rnd -= uint64(r.weight)
 	}
 
-	return nil
+	return b.strategy.selectRunner(candidates)
 }
@@ -7,137 +7,390 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
+var strategies = []StrategyType{StrategyWeightedRandom, StrategyP2C, StrategyLeastLatency, StrategyRoundRobin}
+
 func TestBalancer_processJob_ConcurrencyLimitExhausted(t *testing.T) {
-	ctx := context.Background()
-	maxConcurrency := uint32(5)
+	for _, st := range strategies {
+		t.Run(string(st), func(t *testing.T) {
+			ctx := context.Background()
+			maxConcurrency := uint32(5)
 
-	r1 := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 100, &maxConcurrency)
-	r2 := NewRunner(stubrunner.New(ctx, "runner_2", stubrunner.StubRun), 300, &maxConcurrency)
+			r1 := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 100, &maxConcurrency)
+			r2 := NewRunner(stubrunner.New(ctx, "runner_2", stubrunner.StubRun), 300, &maxConcurrency)
 
-	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel))
-	assert.True(t, b.add(r1))
-	assert.True(t, b.add(r2))
+			b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), st)
+			assert.True(t, b.add(r1))
+			assert.True(t, b.add(r2))
 
-	const iterations = 1000
+			const iterations = 1000
 
-	for i := 0; i < iterations; i++ {
-		jobsCreated := sync.WaitGroup{}
-		jobsCompleted := sync.WaitGroup{}
-		initFinished, finishInit := context.WithCancel(context.Background())
+			for i := 0; i < iterations; i++ {
+				jobsCreated := sync.WaitGroup{}
+				jobsCompleted := sync.WaitGroup{}
+				initFinished, finishInit := context.WithCancel(context.Background())
 
-		r1Selected := new(uint32)
-		r2Selected := new(uint32)
+				r1Selected := new(uint32)
+				r2Selected := new(uint32)
 
-		for j := uint32(0); j < *r1.maxConcurrency+*r2.maxConcurrency; j++ {
-			jobsCreated.Add(1)
-			jobsCompleted.Add(1)
+				for j := uint32(0); j < *r1.maxConcurrency+*r2.maxConcurrency; j++ {
+					jobsCreated.Add(1)
+					jobsCompleted.Add(1)
 
-			go func() {
-				defer jobsCompleted.Done()
+					go func() {
+						defer jobsCompleted.Done()
 
-				processed := b.processJob(func(r *Runner) {
-					jobsCreated.Done()
-					<-initFinished.Done()
+						processed := b.processJob(1, 0, func(r *Runner) error {
+							jobsCreated.Done()
+							<-initFinished.Done()
 
-					switch r {
-					case r1:
-						atomic.AddUint32(r1Selected, 1)
-					case r2:
-						atomic.AddUint32(r2Selected, 1)
-					default:
-						panic("unknown runner")
-					}
-				})
+							switch r {
+							case r1:
+								atomic.AddUint32(r1Selected, 1)
+							case r2:
+								atomic.AddUint32(r2Selected, 1)
+							default:
+								panic("unknown runner")
+							}
 
-				assert.True(t, processed)
-			}()
-		}
+							return nil
+						})
 
-		jobsCreated.Wait()
+						assert.True(t, processed)
+					}()
+				}
 
-		for j := 0; j < 10; j++ {
-			processed := b.processJob(func(r *Runner) {})
-			assert.False(t, processed)
-		}
+				jobsCreated.Wait()
 
-		finishInit()
-		jobsCompleted.Wait()
+				for j := 0; j < 10; j++ {
+					processed := b.processJob(1, 0, func(r *Runner) error { return nil })
+					assert.False(t, processed)
+				}
 
-		assert.Equal(t, *r1.maxConcurrency, *r1Selected)
-		assert.Equal(t, *r2.maxConcurrency, *r2Selected)
+				finishInit()
+				jobsCompleted.Wait()
+
+				assert.Equal(t, *r1.maxConcurrency, *r1Selected)
+				assert.Equal(t, *r2.maxConcurrency, *r2Selected)
+			}
+		})
 	}
 }
 
 func TestBalancer_selectRunner_EqualWeights(t *testing.T) {
-	const runnerCount = 5
-	const samples = 10000
-	const maxDeviation = 0.1
-	const expected = samples / runnerCount
-	// Each runner should be selected samples / runnerCount times roughly.
+	for _, st := range strategies {
+		t.Run(string(st), func(t *testing.T) {
+			const runnerCount = 5
+			const samples = 10000
+			const maxDeviation = 0.1
+			const expected = samples / runnerCount
+			// Each runner should be selected samples / runnerCount times roughly.
+
+			ctx := context.Background()
+			b := newBalancer(zlog.Logger, st)
+
+			var runners []*Runner
+			for i := 0; i < runnerCount; i++ {
+				r := NewRunner(stubrunner.New(ctx, fmt.Sprintf("r%d", i), stubrunner.StubRun), 100, nil)
+
+				assert.True(t, b.add(r))
+				runners = append(runners, r)
+			}
+
+			timesSelected := make(map[*Runner]uint, len(runners))
+			for i := 0; i < samples; i++ {
+				r := b.selectRunner(1, 0)
+				timesSelected[r]++
+			}
+
+			for _, r := range runners {
+				count := timesSelected[r]
+
+				deviation := math.Abs(float64(count)/expected - 1)
+				assert.LessOrEqual(t, deviation, maxDeviation)
+			}
+		})
+	}
+}
+
+func TestBalancer_selectRunner_DifferentWeights(t *testing.T) {
+	for _, st := range strategies {
+		t.Run(string(st), func(t *testing.T) {
+			const runnerCount = 5
+			const samples = 20000
+			const maxDeviation = 0.2
+
+			var runners []*Runner
+			var totalWeight float64
+
+			ctx := context.Background()
+			b := newBalancer(zlog.Logger, st)
+
+			// The weight of the i-th runner is (i + 1) * 100.
+			for i := 0; i < runnerCount; i++ {
+				r := NewRunner(stubrunner.New(ctx, fmt.Sprintf("r%d", i), stubrunner.StubRun), 100*uint(i+1), nil)
+				runners = append(runners, r)
+				assert.True(t, b.add(r))
+				totalWeight += float64(r.weight)
+			}
+
+			timesSelected := make(map[*Runner]uint, len(runners))
+			for i := 0; i < samples; i++ {
+				r := b.selectRunner(1, 0)
+				timesSelected[r]++
+			}
+
+			for i, r := range runners {
+				count := timesSelected[r]
+				expected := samples * (float64(i+1) * 100 / totalWeight)
+
+				deviation := math.Abs(float64(count)/expected - 1)
+				assert.LessOrEqual(t, deviation, maxDeviation)
+			}
+		})
+	}
+}
 
+func TestBalancer_selectRunner_P2CPrefersLessLoaded(t *testing.T) {
 	ctx := context.Background()
-	b := newBalancer(zlog.Logger)
+	idle := NewRunner(stubrunner.New(ctx, "idle", stubrunner.StubRun), 100, nil)
+	busy := NewRunner(stubrunner.New(ctx, "busy", stubrunner.StubRun), 100, nil)
+	busy.addConcurrency(10)
 
-	var runners []*Runner
-	for i := 0; i < runnerCount; i++ {
-		r := NewRunner(stubrunner.New(ctx, fmt.Sprintf("r%d", i), stubrunner.StubRun), 100, nil)
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), StrategyP2C)
+	assert.True(t, b.add(idle))
+	assert.True(t, b.add(busy))
 
-		assert.True(t, b.add(r))
-		runners = append(runners, r)
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, idle, b.selectRunner(1, 0))
 	}
+}
 
-	timesSelected := make(map[*Runner]uint, len(runners))
-	for i := 0; i < samples; i++ {
-		r := b.selectRunner()
-		timesSelected[r]++
-	}
+func TestBalancer_selectRunner_LeastLatency(t *testing.T) {
+	ctx := context.Background()
+	fast := NewRunner(stubrunner.New(ctx, "fast", stubrunner.StubRun), 100, nil)
+	slow := NewRunner(stubrunner.New(ctx, "slow", stubrunner.StubRun), 100, nil)
+
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), StrategyLeastLatency)
+	assert.True(t, b.add(fast))
+	assert.True(t, b.add(slow))
 
-	for _, r := range runners {
-		count := timesSelected[r]
+	b.observe(fast, 100*time.Millisecond, nil)
+	b.observe(slow, time.Second, nil)
+	assert.Equal(t, fast, b.selectRunner(1, 0))
+
+	// The fast runner is loaded, so its expected latency is bigger than the slow runner one.
+	fast.addConcurrency(10)
+	assert.Equal(t, slow, b.selectRunner(1, 0))
+}
+
+func TestBalancer_observe_LeastLatencyFailures(t *testing.T) {
+	ctx := context.Background()
+	broken := NewRunner(stubrunner.New(ctx, "broken", stubrunner.StubRun), 100, nil)
+	slow := NewRunner(stubrunner.New(ctx, "slow", stubrunner.StubRun), 100, nil)
+
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), StrategyLeastLatency)
+	assert.True(t, b.add(broken))
+	assert.True(t, b.add(slow))
+
+	b.observe(slow, 5*time.Second, nil)
+
+	// Runs failed because of users don't affect the latency.
+	b.observe(broken, time.Millisecond, qrunner.ErrRunCancelled)
+	b.observe(broken, time.Millisecond, qrunner.ErrMemoryLimitExceeded)
+	b.observe(broken, time.Millisecond, context.Canceled)
+
+	strategy := b.strategy.(*leastLatencyStrategy)
+	_, found := strategy.latencies[broken]
+	assert.False(t, found)
+
+	// A runner failing runs immediately doesn't look fast.
+	b.observe(broken, time.Millisecond, errors.New("docker daemon is not available"))
+	assert.Equal(t, failedJobLatency.Seconds(), strategy.latencies[broken])
+	assert.Equal(t, slow, b.selectRunner(1, 0))
+}
+
+func TestBalancer_selectRunner_LeastLatencySeed(t *testing.T) {
+	ctx := context.Background()
+	fast := NewRunner(stubrunner.New(ctx, "fast", stubrunner.StubRun), 100, nil)
+	slow := NewRunner(stubrunner.New(ctx, "slow", stubrunner.StubRun), 100, nil)
+	fresh := NewRunner(stubrunner.New(ctx, "fresh", stubrunner.StubRun), 100, nil)
+
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), StrategyLeastLatency)
+	assert.True(t, b.add(fast))
+	assert.True(t, b.add(slow))
+	assert.True(t, b.add(fresh))
+
+	b.observe(fast, time.Second, nil)
+	b.observe(slow, 5*time.Second, nil)
+
+	// The new runner gets the mean latency (3s), so it's preferred to the slow runner only.
+	assert.Equal(t, fast, b.selectRunner(1, 0))
+
+	// The expected latency of the loaded fast runner is 4s.
+	fast.addConcurrency(3)
+	assert.Equal(t, fresh, b.selectRunner(1, 0))
+}
 
-		deviation := math.Abs(float64(count)/expected - 1)
-		assert.LessOrEqual(t, deviation, maxDeviation)
+func TestBalancer_forget(t *testing.T) {
+	ctx := context.Background()
+
+	for _, st := range []StrategyType{StrategyLeastLatency, StrategyRoundRobin} {
+		t.Run(string(st), func(t *testing.T) {
+			r1 := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 100, nil)
+			r2 := NewRunner(stubrunner.New(ctx, "runner_2", stubrunner.StubRun), 100, nil)
+
+			b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), st)
+			assert.True(t, b.add(r1))
+			assert.True(t, b.add(r2))
+
+			b.selectRunner(1, 0)
+			b.observe(r1, time.Second, nil)
+			b.observe(r2, time.Second, nil)
+
+			// Runners excluded temporarily keep their state.
+			b.remove(r1)
+			b.remove(r2)
+			assert.Len(t, strategyState(b.strategy), 2)
+
+			b.forget(r1)
+			b.forget(r2)
+			assert.Empty(t, strategyState(b.strategy))
+		})
 	}
 }
 
-func TestBalancer_selectRunner_DifferentWeights(t *testing.T) {
-	const runnerCount = 5
-	const samples = 20000
-	const maxDeviation = 0.2
+// strategyState returns the per-runner state of the strategy.
+func strategyState(s strategy) map[*Runner]struct{} {
+	state := make(map[*Runner]struct{})
+	switch s := s.(type) {
+	case *leastLatencyStrategy:
+		for r := range s.latencies {
+			state[r] = struct{}{}
+		}
+	case *roundRobinStrategy:
+		for r := range s.current {
+			state[r] = struct{}{}
+		}
+	}
 
-	var runners []*Runner
-	var totalWeight float64
+	return state
+}
 
+func TestBalancer_selectRunner_RoundRobinSequence(t *testing.T) {
 	ctx := context.Background()
-	b := newBalancer(zlog.Logger)
-
-	// The weight of the i-th runner is (i + 1) * 100.
-	for i := 0; i < runnerCount; i++ {
-		r := NewRunner(stubrunner.New(ctx, fmt.Sprintf("r%d", i), stubrunner.StubRun), 100*uint(i+1), nil)
-		runners = append(runners, r)
-		assert.True(t, b.add(r))
-		totalWeight += float64(r.weight)
+	r1 := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 1, nil)
+	r2 := NewRunner(stubrunner.New(ctx, "runner_2", stubrunner.StubRun), 2, nil)
+
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), StrategyRoundRobin)
+	assert.True(t, b.add(r1))
+	assert.True(t, b.add(r2))
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, []*Runner{r2, r1, r2}, []*Runner{b.selectRunner(1, 0), b.selectRunner(1, 0), b.selectRunner(1, 0)})
 	}
+}
+
+func TestBalancer_processJob_Cost(t *testing.T) {
+	ctx := context.Background()
+	maxConcurrency := uint32(5)
+	r := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 100, &maxConcurrency)
+
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), DefaultStrategy)
+	assert.True(t, b.add(r))
+
+	assert.True(t, b.processJob(2, 0, func(_ *Runner) error {
+		assert.Equal(t, uint32(2), r.currentConcurrency())
+
+		// A job of 4 slots doesn't fit into the 3 remaining ones, a job of 3 slots does.
+		assert.False(t, b.processJob(4, 0, func(_ *Runner) error { return nil }))
+		assert.True(t, b.processJob(3, 0, func(_ *Runner) error {
+			assert.Equal(t, uint32(5), r.currentConcurrency())
 
-	timesSelected := make(map[*Runner]uint, len(runners))
-	for i := 0; i < samples; i++ {
-		r := b.selectRunner()
-		timesSelected[r]++
+			return nil
+		}))
+
+		return nil
+	}))
+	assert.Equal(t, uint32(0), r.currentConcurrency())
+
+	// An idle runner accepts a job bigger than its limit.
+	assert.True(t, b.processJob(10, 0, func(_ *Runner) error {
+		assert.False(t, b.processJob(1, 0, func(_ *Runner) error { return nil }))
+
+		return nil
+	}))
+}
+
+func TestBalancer_processJob_Features(t *testing.T) {
+	for _, st := range strategies {
+		t.Run(string(st), func(t *testing.T) {
+			ctx := context.Background()
+
+			full := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 1, nil)
+			limited := NewRunner(&limitedRunner{
+				Runner:   stubrunner.New(ctx, "runner_2", stubrunner.StubRun),
+				features: qrunner.FeatureFiles,
+			}, 1000, nil)
+
+			b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), st)
+			assert.True(t, b.add(full))
+			assert.True(t, b.add(limited))
+
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, full, b.selectRunner(1, qrunner.FeatureDatasets))
+				assert.Equal(t, full, b.selectRunner(1, qrunner.FeatureFiles|qrunner.FeatureClusters))
+				assert.Contains(t, []*Runner{full, limited}, b.selectRunner(1, qrunner.FeatureFiles))
+			}
+
+			b.remove(full)
+			assert.False(t, b.processJob(1, qrunner.FeatureDatasets, func(_ *Runner) error { return nil }))
+			assert.True(t, b.processJob(1, qrunner.FeatureFiles, func(r *Runner) error {
+				assert.Equal(t, limited, r)
+
+				return nil
+			}))
+		})
 	}
+}
+
+func TestBalancer_releasedChan(t *testing.T) {
+	ctx := context.Background()
+	maxConcurrency := uint32(1)
+	r := NewRunner(stubrunner.New(ctx, "runner_1", stubrunner.StubRun), 100, &maxConcurrency)
 
-	for i, r := range runners {
-		count := timesSelected[r]
-		expected := samples * (float64(i+1) * 100 / totalWeight)
+	b := newBalancer(zlog.Logger.Level(zerolog.ErrorLevel), DefaultStrategy)
+	released := b.releasedChan()
+	assert.True(t, b.add(r))
+	assert.True(t, isClosed(released), "adding a runner must release waiters")
+
+	released = b.releasedChan()
+	assert.True(t, b.processJob(1, 0, func(_ *Runner) error {
+		assert.False(t, isClosed(released))
+
+		// The concurrency limit is exhausted.
+		assert.False(t, b.processJob(1, 0, func(_ *Runner) error { return nil }))
+
+		return nil
+	}))
+	assert.True(t, isClosed(released), "finished job must release waiters")
+}
 
-		deviation := math.Abs(float64(count)/expected - 1)
-		assert.LessOrEqual(t, deviation, maxDeviation)
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
 	}
 }
@@ -7,6 +7,15 @@ type Config struct {
 
 	// Delay between two health checks to a runner.
 	HealthCheckRetryDelay time.Duration
+
+	// DrainTimeout limits how long runs being executed by a draining runner are waited for,
+	// if the drain deadline is not provided explicitly.
+	DrainTimeout time.Duration
+
+	// Strategy selects runners for incoming runs. Default: WEIGHTED_RANDOM.
+	Strategy StrategyType
 }
 
 const DefaultHealthCheckRetryDelay = 10 * time.Second
+
+const DefaultDrainTimeout = 30 * time.Second
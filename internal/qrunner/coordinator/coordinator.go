@@ -15,11 +15,21 @@ import (
 
 const DefaultLivenessCheckTimeout = 5 * time.Second
 
+// drainPollInterval is how often in-flight runs of a draining runner are checked.
+const drainPollInterval = 100 * time.Millisecond
+
+// runnerStopTimeout limits stopping of a drained runner, it's not included in the drain deadline.
+const runnerStopTimeout = 10 * time.Second
+
+var ErrRunnerNotFound = errors.New("runner not found")
+var ErrRunnerAlreadyExists = errors.New("runner already exists")
+var ErrInvalidWeight = errors.New("invalid weight")
+
 // Coordinator is a runner that does load balancing among other runners.
 // It keeps list of existing runners and dispatches incoming queries to one of them.
 //
-// At the moment, the coordinator just picks a random runner. There are plans to improve load balancing
-// mechanisms in the future: use P2C, send health checks and monitor liveness.
+// The runner is selected by the configured strategy: weighted random (default), P2C on the number of runs
+// being executed, least latency or round-robin. Runners that don't pass liveness probes are excluded.
 type Coordinator struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -29,21 +39,47 @@ type Coordinator struct {
 
 	logger  zerolog.Logger
 	started int32
+	stopped int32
+
+	// Runners can be added and removed at runtime, so the list is protected by the lock.
+	runnersLock sync.RWMutex
+	runners     []*Runner
+	balancer    *balancer
+
+	// Runs that are being executed, run id -> *Runner.
+	inflight sync.Map
+
+	// Runners that are being drained in the background.
+	draining sync.WaitGroup
 
-	runners  []*Runner
-	balancer *balancer
+	// drainCtx is the parent of contexts of drains started via DrainRunner.
+	// It's cancelled when the shutdown deadline of Stop is exceeded, so such drains don't outlive the shutdown.
+	drainCtx     context.Context
+	cancelDrains context.CancelFunc
 }
 
 func New(ctx context.Context, logger zerolog.Logger, runners []*Runner, cfg Config) *Coordinator {
 	ctx, cancel := context.WithCancel(ctx)
 
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+	if cfg.Strategy == "" {
+		cfg.Strategy = DefaultStrategy
+	}
+
+	// Drains are not stopped with ctx, because Stop waits for them before ctx is cancelled.
+	drainCtx, cancelDrains := context.WithCancel(context.WithoutCancel(ctx))
+
 	return &Coordinator{
-		ctx:      ctx,
-		cancel:   cancel,
-		config:   cfg,
-		logger:   logger.With().Str("runner", "coordinator").Logger(),
-		runners:  runners,
-		balancer: newBalancer(logger),
+		ctx:          ctx,
+		cancel:       cancel,
+		config:       cfg,
+		logger:       logger.With().Str("runner", "coordinator").Logger(),
+		runners:      runners,
+		balancer:     newBalancer(logger, cfg.Strategy),
+		drainCtx:     drainCtx,
+		cancelDrains: cancelDrains,
 	}
 }
 
@@ -57,6 +93,10 @@ func (c *Coordinator) Name() string {
 
 // Start starts underlying runners and starts liveness probe processes.
 func (c *Coordinator) Start() error {
+	// The lock is held until all runners are started, so runners added concurrently are not started twice.
+	c.runnersLock.Lock()
+	defer c.runnersLock.Unlock()
+
 	if !atomic.CompareAndSwapInt32(&c.started, 0, 1) {
 		return errors.New("coordinator has already been started")
 	}
@@ -71,17 +111,12 @@ func (c *Coordinator) Start() error {
 			continue
 		}
 
-		err := r.underlying.Start()
+		err := c.startRunner(r)
 		if err != nil {
-			return errors.Wrapf(err, "%s cannot be started", r.underlying.Name())
+			return err
 		}
 
 		count++
-
-		if c.config.HealthChecksEnabled {
-			c.livenessCheckLoops.Add(1)
-			go c.loopCheckLiveness(r)
-		}
 	}
 
 	if totalWeight == 0 {
@@ -93,23 +128,46 @@ func (c *Coordinator) Start() error {
 	return nil
 }
 
+// startRunner starts the underlying runner and its liveness probe process.
+func (c *Coordinator) startRunner(r *Runner) error {
+	err := r.underlying.Start()
+	if err != nil {
+		return errors.Wrapf(err, "%s cannot be started", r.underlying.Name())
+	}
+
+	r.started = true
+
+	if c.config.HealthChecksEnabled {
+		ctx, cancel := context.WithCancel(c.ctx)
+		r.stopLivenessCheck = cancel
+		r.livenessCheckDone = make(chan struct{})
+
+		c.livenessCheckLoops.Add(1)
+		go c.loopCheckLiveness(ctx, r)
+	}
+
+	return nil
+}
+
 // loopCheckLiveness periodically sends liveness probes to the provided runner.
 // If the runner does not respond, it's marked as dead and excluded from load balancing.
 // When the runner passes a liveness probe, it's included in load balancing.
-func (c *Coordinator) loopCheckLiveness(r *Runner) {
+// The loop is stopped when the context is cancelled.
+func (c *Coordinator) loopCheckLiveness(ctx context.Context, r *Runner) {
 	defer c.livenessCheckLoops.Done()
+	defer close(r.livenessCheckDone)
 
 	rlogger := c.logger.With().Str("underlying_runner", r.underlying.Name()).Logger()
 	rlogger.Debug().Dur("retry_delay_ms", c.config.HealthCheckRetryDelay).Msg("liveness loop has been started")
 
 	checkLiveness := func() {
-		withTimeout, cancel := context.WithTimeout(c.ctx, DefaultLivenessCheckTimeout)
+		withTimeout, cancel := context.WithTimeout(ctx, DefaultLivenessCheckTimeout)
 		defer cancel()
 
 		status := r.underlying.Status(withTimeout)
 
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 		}
@@ -134,7 +192,7 @@ func (c *Coordinator) loopCheckLiveness(r *Runner) {
 
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			rlogger.Debug().Msg("liveness loop has been stopped")
 			return
 
@@ -145,25 +203,27 @@ func (c *Coordinator) loopCheckLiveness(r *Runner) {
 	}
 }
 
-// Stop stops underlying runners and waits for the health checks to be finished.
+// Stop drains all runners and waits for the health checks to be finished.
+// Runs being executed are waited for until the shutdown context is done, then the runners are stopped.
+// Drains started before via DrainRunner are cut short at the same deadline.
 func (c *Coordinator) Stop(shutdownCtx context.Context) error {
-	c.cancel()
+	atomic.StoreInt32(&c.stopped, 1)
 
 	c.logger.Info().Msg("stopping coordinator")
 
-	for _, r := range c.runners {
-		if r.weight == 0 {
-			continue
-		}
-
-		err := r.underlying.Stop(shutdownCtx)
-		if err != nil {
-			c.logger.Err(err).Str("underlying", r.underlying.Name()).Msg("runner cannot be stopped")
-		}
+	for _, r := range c.snapshot() {
+		c.startDraining(shutdownCtx, r)
 	}
 
+	// Runners drained before the shutdown are waited for too.
+	stopDrains := context.AfterFunc(shutdownCtx, c.cancelDrains)
+	c.draining.Wait()
+	stopDrains()
+	c.cancelDrains()
+
 	c.logger.Info().Msg("runners have been stopped")
 
+	c.cancel()
 	c.livenessCheckLoops.Wait()
 
 	c.logger.Info().Msg("coordinator has been stopped")
@@ -171,14 +231,329 @@ func (c *Coordinator) Stop(shutdownCtx context.Context) error {
 	return nil
 }
 
+// runCost returns the number of containers the run occupies: a cluster has a server for every node and Keeper.
+func runCost(run *queryrun.Run) uint32 {
+	if run.Topology == nil {
+		return 1
+	}
+
+	return uint32(run.Topology.Nodes()) + 1
+}
+
+// unavailabilityError returns the error for a run no runner has been found for.
+// If none of runners supports the run features, waiting for a runner is pointless.
+func (c *Coordinator) unavailabilityError(run *queryrun.Run) error {
+	features := qrunner.RunFeatures(run)
+	if features == 0 {
+		return qrunner.ErrNoAvailableRunners
+	}
+
+	for _, r := range c.snapshot() {
+		if r.supports(features) {
+			return qrunner.ErrNoAvailableRunners
+		}
+	}
+
+	return qrunner.ErrRunNotSupported
+}
+
 // RunQuery proxies queries to one of the underlying runners.
 func (c *Coordinator) RunQuery(ctx context.Context, run *queryrun.Run) (output string, err error) {
-	processed := c.balancer.processJob(func(r *Runner) {
+	processed := c.balancer.processJob(runCost(run), qrunner.RunFeatures(run), func(r *Runner) error {
+		c.inflight.Store(run.ID, r)
+		defer c.inflight.Delete(run.ID)
+
 		output, err = r.underlying.RunQuery(ctx, run)
+
+		return err
 	})
 	if !processed {
-		return "", qrunner.ErrNoAvailableRunners
+		return "", c.unavailabilityError(run)
 	}
 
 	return output, err
 }
+
+// RunQueryStream proxies streaming queries to one of the underlying runners.
+func (c *Coordinator) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (output string, err error) {
+	processed := c.balancer.processJob(runCost(run), qrunner.RunFeatures(run), func(r *Runner) error {
+		c.inflight.Store(run.ID, r)
+		defer c.inflight.Delete(run.ID)
+
+		output, err = r.underlying.RunQueryStream(ctx, run, onOutput)
+
+		return err
+	})
+	if !processed {
+		return "", c.unavailabilityError(run)
+	}
+
+	return output, err
+}
+
+// Released returns a channel that is closed when a runner may become available for a new run.
+// The channel must be obtained before RunQuery, so a release between a rejected run and the wait is not missed.
+func (c *Coordinator) Released() <-chan struct{} {
+	return c.balancer.releasedChan()
+}
+
+// CancelRun cancels the run on the runner that executes it.
+//
+// If the run has not been dispatched by this coordinator (e.g. after a restart),
+// all alive runners are asked to cancel it.
+func (c *Coordinator) CancelRun(ctx context.Context, runID string) (string, error) {
+	value, found := c.inflight.Load(runID)
+	if found {
+		return value.(*Runner).underlying.CancelRun(ctx, runID)
+	}
+
+	for _, r := range c.snapshot() {
+		if !r.IsAlive() || r.State() == RunnerStateStopped {
+			continue
+		}
+
+		output, err := r.underlying.CancelRun(ctx, runID)
+		if errors.Is(err, qrunner.ErrRunNotFound) {
+			continue
+		}
+		if err != nil {
+			c.logger.Err(err).Str("underlying", r.underlying.Name()).Str("run_id", runID).Msg("failed to cancel run")
+			continue
+		}
+
+		return output, nil
+	}
+
+	return "", qrunner.ErrRunNotFound
+}
+
+// snapshot returns the current list of runners.
+func (c *Coordinator) snapshot() []*Runner {
+	c.runnersLock.RLock()
+	defer c.runnersLock.RUnlock()
+
+	return append([]*Runner(nil), c.runners...)
+}
+
+// find returns the runner with the given name or nil if it doesn't exist.
+// find must be called under the taken runners lock.
+func (c *Coordinator) find(name string) (int, *Runner) {
+	for i, r := range c.runners {
+		if r.underlying.Name() == name {
+			return i, r
+		}
+	}
+
+	return -1, nil
+}
+
+// Runners returns the state of all coordinated runners.
+func (c *Coordinator) Runners() []RunnerInfo {
+	runners := c.snapshot()
+
+	info := make([]RunnerInfo, 0, len(runners))
+	for _, r := range runners {
+		info = append(info, RunnerInfo{
+			Name:           r.underlying.Name(),
+			Type:           r.underlying.Type(),
+			Weight:         c.balancer.weight(r),
+			MaxConcurrency: r.maxConcurrency,
+			Concurrency:    r.currentConcurrency(),
+			Alive:          r.IsAlive(),
+			State:          r.State(),
+		})
+	}
+
+	return info
+}
+
+// AddRunner registers a new runner. If the coordinator has been started, the runner is started immediately
+// and included in load balancing after it passes a liveness probe.
+func (c *Coordinator) AddRunner(r *Runner) error {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return errors.New("coordinator has been stopped")
+	}
+
+	c.runnersLock.Lock()
+	defer c.runnersLock.Unlock()
+
+	_, existing := c.find(r.underlying.Name())
+	if existing != nil {
+		return ErrRunnerAlreadyExists
+	}
+
+	if atomic.LoadInt32(&c.started) == 1 && r.weight > 0 {
+		err := c.startRunner(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.runners = append(c.runners, r)
+
+	c.logger.Info().Str("underlying", r.underlying.Name()).Uint("weight", r.weight).Msg("runner has been added")
+
+	return nil
+}
+
+// DrainRunner gracefully stops the runner in the background. The runner is excluded from load balancing
+// and its prewarmed resources are released. Runs being executed are waited for until the timeout expires,
+// then the runner is stopped. If the timeout is 0, the configured drain timeout is used.
+func (c *Coordinator) DrainRunner(name string, timeout time.Duration) error {
+	c.runnersLock.RLock()
+	_, r := c.find(name)
+	c.runnersLock.RUnlock()
+
+	if r == nil {
+		return ErrRunnerNotFound
+	}
+
+	if timeout == 0 {
+		timeout = c.config.DrainTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(c.drainCtx, timeout)
+	if !c.startDraining(ctx, r) {
+		cancel()
+		return nil
+	}
+
+	go func() {
+		<-r.drainDone
+		cancel()
+	}()
+
+	return nil
+}
+
+// startDraining starts draining an active runner, it returns false if the runner is not active.
+// Runs being executed are waited for until the context is done.
+func (c *Coordinator) startDraining(ctx context.Context, r *Runner) bool {
+	if !c.balancer.drain(r) {
+		return false
+	}
+
+	c.logger.Info().Str("underlying", r.underlying.Name()).Uint32("inflight", r.currentConcurrency()).Msg("draining runner")
+
+	c.draining.Add(1)
+	go func() {
+		defer c.draining.Done()
+		c.drain(ctx, r)
+	}()
+
+	return true
+}
+
+// drain releases idle resources of the runner, waits for its runs and stops it.
+func (c *Coordinator) drain(ctx context.Context, r *Runner) {
+	defer close(r.drainDone)
+
+	name := r.underlying.Name()
+
+	if releaser, ok := r.underlying.(qrunner.IdleResourceReleaser); ok && r.started {
+		releaser.ReleaseIdleResources(ctx)
+	}
+
+	if !waitForRuns(ctx, r) {
+		c.logger.Warn().Str("underlying", name).Uint32("inflight", r.currentConcurrency()).
+			Msg("drain deadline has been exceeded, the runner is stopped with runs being executed")
+	}
+
+	if r.stopLivenessCheck != nil {
+		r.stopLivenessCheck()
+		<-r.livenessCheckDone
+	}
+
+	if r.started {
+		stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runnerStopTimeout)
+		defer cancel()
+
+		err := r.underlying.Stop(stopCtx)
+		if err != nil {
+			c.logger.Err(err).Str("underlying", name).Msg("runner cannot be stopped")
+		}
+	}
+
+	r.setState(RunnerStateStopped)
+
+	c.logger.Info().Str("underlying", name).Msg("runner has been drained and stopped")
+}
+
+// waitForRuns waits until the runner finishes its runs. It returns false if the context is done earlier.
+func waitForRuns(ctx context.Context, r *Runner) bool {
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+
+	for r.currentConcurrency() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+		}
+	}
+
+	return true
+}
+
+// RemoveRunner drains the runner if it's active, waits for it to be stopped and removes it.
+// If the context is done before the runner is stopped, the runner is left draining and an error is returned.
+func (c *Coordinator) RemoveRunner(ctx context.Context, name string) error {
+	c.runnersLock.RLock()
+	_, r := c.find(name)
+	c.runnersLock.RUnlock()
+
+	if r == nil {
+		return ErrRunnerNotFound
+	}
+
+	err := c.DrainRunner(name, 0)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-r.drainDone:
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "%s is still draining with %d in-flight runs", name, r.currentConcurrency())
+	}
+
+	c.runnersLock.Lock()
+	defer c.runnersLock.Unlock()
+
+	i, removed := c.find(name)
+	if removed != r {
+		return ErrRunnerNotFound
+	}
+	c.runners = append(c.runners[:i], c.runners[i+1:]...)
+	c.balancer.forget(r)
+
+	c.logger.Info().Str("underlying", name).Msg("runner has been removed")
+
+	return nil
+}
+
+// SetRunnerWeight changes the weight of the runner used for load balancing.
+func (c *Coordinator) SetRunnerWeight(name string, weight uint) error {
+	if weight == 0 {
+		return errors.Wrap(ErrInvalidWeight, "weight must be > 0, drain the runner to exclude it from load balancing")
+	}
+
+	c.runnersLock.RLock()
+	_, r := c.find(name)
+	c.runnersLock.RUnlock()
+
+	if r == nil {
+		return ErrRunnerNotFound
+	}
+
+	// Runners with zero weight are not started.
+	if c.balancer.weight(r) == 0 {
+		return errors.Wrap(ErrInvalidWeight, "disabled runner cannot be reweighted")
+	}
+
+	c.balancer.setWeight(r, weight)
+
+	c.logger.Info().Str("underlying", name).Uint("weight", weight).Msg("runner weight has been changed")
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package coordinator
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCoordinator(t *testing.T, runners ...*Runner) *Coordinator {
+	c := New(context.Background(), zerolog.Nop(), runners, Config{
+		HealthChecksEnabled:   true,
+		HealthCheckRetryDelay: 10 * time.Millisecond,
+	})
+	require.NoError(t, c.Start())
+	t.Cleanup(func() {
+		_ = c.Stop(context.Background())
+	})
+
+	return c
+}
+
+func successfulRun(_ context.Context, _ *queryrun.Run) (string, error) {
+	return "", nil
+}
+
+// waitIncluded waits until the runner passes a liveness probe and is included in load balancing.
+func waitIncluded(t *testing.T, c *Coordinator, name string) {
+	require.Eventually(t, func() bool { return c.balancerHas(name) }, time.Second, 10*time.Millisecond)
+}
+
+func (c *Coordinator) balancerHas(name string) bool {
+	c.balancer.lock.Lock()
+	defer c.balancer.lock.Unlock()
+
+	_, found := c.balancer.runners[name]
+
+	return found
+}
+
+func TestCoordinator_AddRunner(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCoordinator(t, NewRunner(stubrunner.New(ctx, "runner_1", successfulRun), 100, nil))
+
+	err := c.AddRunner(NewRunner(stubrunner.New(ctx, "runner_1", successfulRun), 100, nil))
+	assert.ErrorIs(t, err, ErrRunnerAlreadyExists)
+
+	require.NoError(t, c.AddRunner(NewRunner(stubrunner.New(ctx, "runner_2", successfulRun), 200, nil)))
+	waitIncluded(t, c, "runner_2")
+
+	runners := c.Runners()
+	require.Len(t, runners, 2)
+	assert.Equal(t, "runner_2", runners[1].Name)
+	assert.Equal(t, qrunner.TypeStub, runners[1].Type)
+	assert.Equal(t, uint(200), runners[1].Weight)
+	assert.True(t, runners[1].Alive)
+	assert.Equal(t, RunnerStateActive, runners[1].State)
+}
+
+// limitedRunner supports only the given run features.
+type limitedRunner struct {
+	*stubrunner.Runner
+	features qrunner.Features
+}
+
+func (r *limitedRunner) Features() qrunner.Features {
+	return r.features
+}
+
+func TestCoordinator_RunQueryUnsupported(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCoordinator(t, NewRunner(&limitedRunner{Runner: stubrunner.New(ctx, "runner_1", successfulRun)}, 100, nil))
+	waitIncluded(t, c, "runner_1")
+
+	_, err := c.RunQuery(ctx, &queryrun.Run{ID: "plain"})
+	assert.NoError(t, err)
+
+	_, err = c.RunQuery(ctx, &queryrun.Run{ID: "cluster", Topology: &queryrun.Topology{Shards: 1, Replicas: 1}})
+	assert.ErrorIs(t, err, qrunner.ErrRunNotSupported)
+}
+
+// releasingRunner counts releases of idle resources.
+type releasingRunner struct {
+	*stubrunner.Runner
+	released int32
+}
+
+func (r *releasingRunner) ReleaseIdleResources(_ context.Context) {
+	atomic.AddInt32(&r.released, 1)
+}
+
+func TestCoordinator_DrainRunner(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := &releasingRunner{Runner: stubrunner.New(ctx, "runner_1", func(_ context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-release
+
+		return "done", nil
+	})}
+
+	c := newTestCoordinator(t,
+		NewRunner(slow, 100, nil),
+		NewRunner(stubrunner.New(ctx, "runner_2", successfulRun), 100, nil),
+	)
+	waitIncluded(t, c, "runner_1")
+	waitIncluded(t, c, "runner_2")
+
+	// Make runner_1 execute a run.
+	require.NoError(t, c.SetRunnerWeight("runner_2", 1))
+	require.NoError(t, c.SetRunnerWeight("runner_1", 1<<30))
+
+	outputs := make(chan string, 1)
+	go func() {
+		output, _ := c.RunQuery(ctx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+		outputs <- output
+	}()
+	<-started
+
+	assert.ErrorIs(t, c.DrainRunner("unknown", 0), ErrRunnerNotFound)
+	require.NoError(t, c.DrainRunner("runner_1", 0))
+
+	// The draining runner doesn't receive new runs, but finishes the run being executed.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&slow.released) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, RunnerStateDraining, c.Runners()[0].State)
+
+	// Liveness probes must not include the draining runner in load balancing again.
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, c.balancerHas("runner_1"))
+
+	_, err := c.RunQuery(ctx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	require.NoError(t, err)
+
+	close(release)
+	assert.Equal(t, "done", <-outputs)
+	require.Eventually(t, func() bool { return c.Runners()[0].State == RunnerStateStopped }, time.Second, 10*time.Millisecond)
+
+	// Draining is idempotent.
+	require.NoError(t, c.DrainRunner("runner_1", 0))
+	assert.Equal(t, RunnerStateStopped, c.Runners()[0].State)
+}
+
+func TestCoordinator_DrainRunnerDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	c := newTestCoordinator(t, NewRunner(stubrunner.New(ctx, "hanging", func(ctx context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	}), 100, nil))
+	waitIncluded(t, c, "hanging")
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	go func() {
+		_, _ = c.RunQuery(runCtx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	}()
+	<-started
+
+	// The runner is stopped after the deadline even though the run is being executed.
+	require.NoError(t, c.DrainRunner("hanging", 50*time.Millisecond))
+	require.Eventually(t, func() bool { return c.Runners()[0].State == RunnerStateStopped }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(1), c.Runners()[0].Concurrency)
+
+	_, err := c.RunQuery(ctx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	assert.ErrorIs(t, err, qrunner.ErrNoAvailableRunners)
+}
+
+func TestCoordinator_StopDrainsRunners(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := &releasingRunner{Runner: stubrunner.New(ctx, "slow", func(_ context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-release
+
+		return "done", nil
+	})}
+
+	c := New(ctx, zerolog.Nop(), []*Runner{NewRunner(slow, 100, nil)}, Config{
+		HealthChecksEnabled:   true,
+		HealthCheckRetryDelay: 10 * time.Millisecond,
+	})
+	require.NoError(t, c.Start())
+	waitIncluded(t, c, "slow")
+
+	outputs := make(chan string, 1)
+	go func() {
+		output, _ := c.RunQuery(ctx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+		outputs <- output
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		assert.NoError(t, c.Stop(ctx))
+		close(stopped)
+	}()
+
+	require.Eventually(t, func() bool { return c.Runners()[0].State == RunnerStateDraining }, time.Second, 10*time.Millisecond)
+	assert.Error(t, c.AddRunner(NewRunner(stubrunner.New(ctx, "new", successfulRun), 100, nil)))
+
+	select {
+	case <-stopped:
+		t.Fatal("coordinator has been stopped before the run is finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, "done", <-outputs)
+	<-stopped
+
+	assert.Equal(t, RunnerStateStopped, c.Runners()[0].State)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slow.released))
+}
+
+func TestCoordinator_StopCutsDrainsShort(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	c := New(ctx, zerolog.Nop(), []*Runner{NewRunner(stubrunner.New(ctx, "hanging", func(ctx context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	}), 100, nil)}, Config{
+		HealthChecksEnabled:   true,
+		HealthCheckRetryDelay: 10 * time.Millisecond,
+	})
+	require.NoError(t, c.Start())
+	waitIncluded(t, c, "hanging")
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	go func() {
+		_, _ = c.RunQuery(runCtx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	}()
+	<-started
+
+	// The drain would wait for the run for an hour, but the shutdown deadline is shorter.
+	require.NoError(t, c.DrainRunner("hanging", time.Hour))
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		assert.NoError(t, c.Stop(shutdownCtx))
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the drain has not been cut short by the shutdown deadline")
+	}
+	assert.Equal(t, RunnerStateStopped, c.Runners()[0].State)
+}
+
+func TestCoordinator_RemoveRunner(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := NewRunner(stubrunner.New(ctx, "slow", func(_ context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-release
+
+		return "", nil
+	}), 100, nil)
+
+	c := newTestCoordinator(t, slow)
+	waitIncluded(t, c, "slow")
+
+	go func() {
+		_, _ = c.RunQuery(ctx, queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	}()
+	<-started
+
+	assert.ErrorIs(t, c.RemoveRunner(ctx, "unknown"), ErrRunnerNotFound)
+
+	// The runner cannot be removed while it executes a run.
+	withTimeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.RemoveRunner(withTimeout, "slow"), context.DeadlineExceeded)
+	assert.Len(t, c.Runners(), 1)
+
+	assert.Equal(t, RunnerStateDraining, c.Runners()[0].State)
+
+	close(release)
+	require.NoError(t, c.RemoveRunner(ctx, "slow"))
+	assert.Empty(t, c.Runners())
+}
+
+func TestCoordinator_SetRunnerWeight(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCoordinator(t,
+		NewRunner(stubrunner.New(ctx, "runner_1", successfulRun), 100, nil),
+		NewRunner(stubrunner.New(ctx, "disabled", successfulRun), 0, nil),
+	)
+
+	assert.ErrorIs(t, c.SetRunnerWeight("unknown", 10), ErrRunnerNotFound)
+	assert.ErrorIs(t, c.SetRunnerWeight("runner_1", 0), ErrInvalidWeight)
+	assert.ErrorIs(t, c.SetRunnerWeight("disabled", 10), ErrInvalidWeight)
+
+	require.NoError(t, c.SetRunnerWeight("runner_1", 300))
+	assert.Equal(t, uint(300), c.Runners()[0].Weight)
+}
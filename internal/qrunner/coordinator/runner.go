@@ -1,6 +1,7 @@
 package coordinator
 
 import (
+	"context"
 	"sync/atomic"
 
 	"github.com/lodthe/clickhouse-playground/internal/qrunner"
@@ -8,17 +9,68 @@ import (
 
 const DefaultWeight = 100
 
+// RunnerState is a lifecycle state of a coordinated runner.
+type RunnerState uint32
+
+const (
+	// RunnerStateActive runners receive runs when they are alive.
+	RunnerStateActive RunnerState = iota
+
+	// RunnerStateDraining runners don't receive new runs, but finish the runs being executed.
+	RunnerStateDraining
+
+	// RunnerStateStopped runners have been drained and stopped.
+	RunnerStateStopped
+)
+
+func (s RunnerState) String() string {
+	switch s {
+	case RunnerStateActive:
+		return "ACTIVE"
+	case RunnerStateDraining:
+		return "DRAINING"
+	case RunnerStateStopped:
+		return "STOPPED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 type Runner struct {
 	underlying qrunner.Runner
 
 	// Liveness is controlled by ping probes.
 	alive uint32
 
-	// Weight is for load balancing.
+	// Weight is for load balancing, it's changed under the balancer lock.
 	weight uint
 
+	// Only active runners are included in load balancing, the state is changed under the balancer lock.
+	state uint32
+
+	// drainDone is closed when the runner has been drained and stopped.
+	drainDone chan struct{}
+
 	maxConcurrency *uint32
 	concurrency    int32
+
+	// started is true if the underlying runner has been started.
+	started bool
+
+	// stopLivenessCheck stops the liveness loop of the runner, livenessCheckDone is closed when it's stopped.
+	stopLivenessCheck context.CancelFunc
+	livenessCheckDone chan struct{}
+}
+
+// RunnerInfo describes the current state of a coordinated runner.
+type RunnerInfo struct {
+	Name           string
+	Type           qrunner.Type
+	Weight         uint
+	MaxConcurrency *uint32
+	Concurrency    uint32
+	Alive          bool
+	State          RunnerState
 }
 
 func NewRunner(underlying qrunner.Runner, weight uint, maxConcurrency *uint32) *Runner {
@@ -26,7 +78,9 @@ func NewRunner(underlying qrunner.Runner, weight uint, maxConcurrency *uint32) *
 		underlying:     underlying,
 		weight:         weight,
 		alive:          0,
+		state:          uint32(RunnerStateActive),
 		maxConcurrency: maxConcurrency,
+		drainDone:      make(chan struct{}),
 	}
 }
 
@@ -43,7 +97,47 @@ func (r *Runner) setAlive(alive bool) {
 	atomic.StoreUint32(&r.alive, converted)
 }
 
+func (r *Runner) Name() string {
+	return r.underlying.Name()
+}
+
+// Underlying returns the coordinated runner.
+func (r *Runner) Underlying() qrunner.Runner {
+	return r.underlying
+}
+
+func (r *Runner) State() RunnerState {
+	return RunnerState(atomic.LoadUint32(&r.state))
+}
+
+func (r *Runner) setState(state RunnerState) {
+	atomic.StoreUint32(&r.state, uint32(state))
+}
+
+// currentConcurrency returns the number of containers of runs being executed by the runner.
+func (r *Runner) currentConcurrency() uint32 {
+	return uint32(atomic.LoadInt32(&r.concurrency))
+}
+
 // addConcurrency atomically adds delta to the current concurrency and returns the new value.
 func (r *Runner) addConcurrency(delta int32) uint32 {
 	return uint32(atomic.AddInt32(&r.concurrency, delta))
 }
+
+// fits reports whether a job occupying cost slots can be dispatched to the runner.
+// An idle runner accepts any job, otherwise jobs bigger than the limit would never be executed.
+func (r *Runner) fits(cost uint32) bool {
+	if r.maxConcurrency == nil {
+		return true
+	}
+
+	concurrency := r.currentConcurrency()
+
+	return concurrency == 0 || concurrency+cost <= *r.maxConcurrency
+}
+
+// supports reports whether the underlying runner supports all the given run features.
+// Features are requested every time, because remote runners report them with their status.
+func (r *Runner) supports(features qrunner.Features) bool {
+	return qrunner.SupportedFeatures(r.underlying).Has(features)
+}
@@ -0,0 +1,235 @@
+package coordinator
+
+import (
+	"math/rand"
+	"time"
+)
+
+// StrategyType defines how the balancer selects a runner for the next job.
+type StrategyType string
+
+const (
+	// StrategyWeightedRandom selects a random runner, the probability is proportional to the runner weight.
+	StrategyWeightedRandom StrategyType = "WEIGHTED_RANDOM"
+
+	// StrategyP2C selects two random runners (power of two choices) and picks the one
+	// with the fewest runs being executed.
+	StrategyP2C StrategyType = "P2C"
+
+	// StrategyLeastLatency selects the runner with the lowest EWMA of recent run durations
+	// multiplied by the number of runs being executed.
+	StrategyLeastLatency StrategyType = "LEAST_LATENCY"
+
+	// StrategyRoundRobin selects runners in turn, runners with bigger weights are selected more often.
+	StrategyRoundRobin StrategyType = "ROUND_ROBIN"
+)
+
+const DefaultStrategy = StrategyWeightedRandom
+
+// latencyDecay is the weight of the latest duration in the latency EWMA.
+const latencyDecay = 0.2
+
+// failedJobLatency is the minimal duration failed jobs are observed with by the least latency strategy.
+// Otherwise, a broken runner failing jobs immediately would look like the fastest one.
+const failedJobLatency = time.Minute
+
+// strategy selects a runner for the next job. Methods are called under the balancer lock.
+type strategy interface {
+	// selectRunner returns one of the runners included in load balancing or nil if there are no runners.
+	// Runners are ordered by the time they have been included.
+	selectRunner(runners []*Runner) *Runner
+
+	// observe is called when a job dispatched to the runner is finished. failed is true if the runner
+	// has failed to execute the job.
+	observe(r *Runner, elapsed time.Duration, failed bool)
+
+	// forget is called when the runner is removed, the strategy must drop the state of the runner.
+	forget(r *Runner)
+}
+
+// newStrategy creates a strategy of the given type. The weighted random strategy is used by default.
+func newStrategy(t StrategyType) strategy {
+	// It's okay to initialize by setting time, because it's just for load balancing among runners.
+	random := rand.New(rand.NewSource(time.Now().UnixNano())) // nolint:gosec
+
+	switch t {
+	case StrategyP2C:
+		return &p2cStrategy{random: random}
+	case StrategyLeastLatency:
+		return &leastLatencyStrategy{random: random, latencies: make(map[*Runner]float64)}
+	case StrategyRoundRobin:
+		return &roundRobinStrategy{current: make(map[*Runner]int64)}
+	default:
+		return &weightedRandomStrategy{random: random}
+	}
+}
+
+// weightedRandom implements a weighted random choice algorithm and returns a runner.
+// If the weight of r1 is 10 times the weight of r2, r1 is selected ~10 times more often.
+func weightedRandom(random *rand.Rand, runners []*Runner) *Runner {
+	var totalWeight uint64
+	for _, r := range runners {
+		totalWeight += uint64(r.weight)
+	}
+
+	if totalWeight == 0 {
+		return nil
+	}
+
+	rnd := random.Uint64() % totalWeight
+	for _, r := range runners {
+		if rnd < uint64(r.weight) {
+			return r
+		}
+
+		rnd -= uint64(r.weight)
+	}
+
+	return nil
+}
+
+type weightedRandomStrategy struct {
+	random *rand.Rand
+}
+
+func (s *weightedRandomStrategy) selectRunner(runners []*Runner) *Runner {
+	return weightedRandom(s.random, runners)
+}
+
+func (s *weightedRandomStrategy) observe(_ *Runner, _ time.Duration, _ bool) {}
+
+func (s *weightedRandomStrategy) forget(_ *Runner) {}
+
+// p2cStrategy picks two distinct runners with the weighted random choice and selects the less loaded one.
+// If both runners have the same number of runs being executed, the first one is selected.
+type p2cStrategy struct {
+	random *rand.Rand
+}
+
+func (s *p2cStrategy) selectRunner(runners []*Runner) *Runner {
+	first := weightedRandom(s.random, runners)
+	if first == nil || len(runners) == 1 {
+		return first
+	}
+
+	rest := make([]*Runner, 0, len(runners)-1)
+	for _, r := range runners {
+		if r != first {
+			rest = append(rest, r)
+		}
+	}
+
+	second := weightedRandom(s.random, rest)
+	if second != nil && second.currentConcurrency() < first.currentConcurrency() {
+		return second
+	}
+
+	return first
+}
+
+func (s *p2cStrategy) observe(_ *Runner, _ time.Duration, _ bool) {}
+
+func (s *p2cStrategy) forget(_ *Runner) {}
+
+// leastLatencyStrategy selects the runner with the lowest latency EWMA multiplied by the number of runs
+// being executed plus one, so a fast runner doesn't get all runs. Runners without finished runs get the mean
+// latency of other runners, so a new runner is neither flooded nor starved. Failed jobs are observed
+// with at least failedJobLatency. Ties are broken with the weighted random choice.
+type leastLatencyStrategy struct {
+	random *rand.Rand
+
+	// EWMA of run durations in seconds.
+	latencies map[*Runner]float64
+}
+
+func (s *leastLatencyStrategy) selectRunner(runners []*Runner) *Runner {
+	seed := s.meanLatency()
+
+	var best []*Runner
+	var bestScore float64
+	for _, r := range runners {
+		latency, found := s.latencies[r]
+		if !found {
+			latency = seed
+		}
+
+		score := latency * float64(r.currentConcurrency()+1)
+
+		switch {
+		case len(best) == 0 || score < bestScore:
+			best = append(best[:0], r)
+			bestScore = score
+
+		case score == bestScore:
+			best = append(best, r)
+		}
+	}
+
+	return weightedRandom(s.random, best)
+}
+
+// meanLatency returns the mean latency of observed runners or 0 if there are no such runners.
+func (s *leastLatencyStrategy) meanLatency() float64 {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, latency := range s.latencies {
+		sum += latency
+	}
+
+	return sum / float64(len(s.latencies))
+}
+
+func (s *leastLatencyStrategy) observe(r *Runner, elapsed time.Duration, failed bool) {
+	if failed && elapsed < failedJobLatency {
+		elapsed = failedJobLatency
+	}
+
+	latency, found := s.latencies[r]
+	if !found {
+		s.latencies[r] = elapsed.Seconds()
+		return
+	}
+
+	s.latencies[r] = latencyDecay*elapsed.Seconds() + (1-latencyDecay)*latency
+}
+
+func (s *leastLatencyStrategy) forget(r *Runner) {
+	delete(s.latencies, r)
+}
+
+// roundRobinStrategy implements the smooth weighted round-robin algorithm: runners are selected in turn,
+// and a runner with weight w is selected w times per (total weight) selections.
+type roundRobinStrategy struct {
+	current map[*Runner]int64
+}
+
+func (s *roundRobinStrategy) selectRunner(runners []*Runner) *Runner {
+	var selected *Runner
+	var totalWeight int64
+	for _, r := range runners {
+		weight := int64(r.weight)
+		totalWeight += weight
+		s.current[r] += weight
+
+		if selected == nil || s.current[r] > s.current[selected] {
+			selected = r
+		}
+	}
+
+	if selected == nil || totalWeight == 0 {
+		return nil
+	}
+
+	s.current[selected] -= totalWeight
+
+	return selected
+}
+
+func (s *roundRobinStrategy) observe(_ *Runner, _ time.Duration, _ bool) {}
+
+func (s *roundRobinStrategy) forget(r *Runner) {
+	delete(s.current, r)
+}
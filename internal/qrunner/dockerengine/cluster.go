@@ -0,0 +1,183 @@
+package dockerengine
+
+import (
+	"context"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/pkg/errors"
+)
+
+const (
+	clusterConfigFile = "playground-cluster.xml"
+
+	// keeperHost is the host name of the coordination server of a cluster.
+	keeperHost = "keeper"
+)
+
+// clusterState contains containers of a cluster created for a run.
+type clusterState struct {
+	driver  dbdriver.ClusterDriver
+	network string
+
+	// nodes are ids of server containers, the first one executes queries.
+	nodes []string
+}
+
+func clusterNetworkName(runID string) string {
+	return "chp-" + runID
+}
+
+// createCluster starts Keeper and servers of the requested topology in a network created for the run.
+// Queries are executed on the first server. If the cluster cannot be created, its containers are removed.
+func (r *Runner) createCluster(ctx context.Context, state *requestState) (err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.CreateCluster(err == nil, state.version, invokedAt)
+	}()
+
+	if state.topology.Exceeds(r.cfg.Cluster.MaxNodes) {
+		return errors.Errorf("cluster cannot have more than %d nodes", r.cfg.Cluster.MaxNodes)
+	}
+	if r.http != nil && r.cfg.HTTP.Network == "" {
+		return errors.New("http network is required to run queries against a cluster")
+	}
+
+	driver, ok := state.driver.(dbdriver.ClusterDriver)
+	if !ok {
+		return errors.Errorf("database %s doesn't support clusters", state.database)
+	}
+
+	err = r.pull(ctx, state)
+	if err != nil {
+		return errors.Wrap(err, "pull failed")
+	}
+
+	name := clusterNetworkName(state.runID)
+	_, err = r.engine.createNetwork(ctx, name, CreateContainerLabels(r.name, state.runID, state.version))
+	if err != nil {
+		return errors.Wrap(err, "failed to create network")
+	}
+
+	state.cluster = &clusterState{driver: driver, network: name}
+	defer func() {
+		if err != nil {
+			r.removeCluster(r.ctx, state)
+		}
+	}()
+
+	_, err = r.startClusterContainer(ctx, state, keeperHost, state.cluster.driver.CoordinatorConfig(keeperHost))
+	if err != nil {
+		return errors.Wrap(err, "failed to start keeper")
+	}
+
+	for _, node := range dbdriver.ClusterNodes(*state.topology) {
+		config := state.cluster.driver.NodeConfig(*state.topology, node, keeperHost)
+		id, err := r.startClusterContainer(ctx, state, node.Host, config)
+		if err != nil {
+			return errors.Wrapf(err, "failed to start node %s", node.Host)
+		}
+
+		state.cluster.nodes = append(state.cluster.nodes, id)
+	}
+
+	state.containerID = state.cluster.nodes[0]
+
+	// The cluster network is internal, the query node is connected to the network the runner can reach.
+	if r.http != nil {
+		err = r.engine.connectNetwork(ctx, r.cfg.HTTP.Network, state.containerID)
+		if err != nil {
+			return errors.Wrap(err, "failed to connect the query node to the http network")
+		}
+	}
+
+	r.logger.Debug().
+		Str("run_id", state.runID).
+		Int("shards", state.topology.Shards).
+		Int("replicas", state.topology.Replicas).
+		Dur("elapsed_ms", time.Since(invokedAt)).
+		Msg("cluster has been created")
+
+	return nil
+}
+
+// startClusterContainer starts a server in the cluster network, it's reachable by the host name.
+func (r *Runner) startClusterContainer(ctx context.Context, state *requestState, host string, config string) (string, error) {
+	contConfig, hostConfig := r.containerConfig(state)
+	contConfig.Hostname = host
+	hostConfig.NetworkMode = container.NetworkMode(state.cluster.network)
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			state.cluster.network: {Aliases: []string{host}},
+		},
+	}
+
+	configs := []queryrun.InputFile{queryrun.NewInputFile(clusterConfigFile, []byte(config))}
+
+	return r.startContainer(ctx, state, contConfig, hostConfig, networkingConfig, configs)
+}
+
+// removeCluster removes all containers of the run and the cluster network.
+// Containers are found by labels, so ones that failed to start are removed as well.
+func (r *Runner) removeCluster(ctx context.Context, state *requestState) {
+	containers, err := r.engine.getRunContainers(ctx, state.runID)
+	if err != nil {
+		r.logger.Error().Err(err).Str("run_id", state.runID).Msg("failed to find cluster containers")
+		return
+	}
+
+	for _, c := range containers {
+		startedAt := time.Now()
+
+		err = r.engine.removeContainer(ctx, c.ID)
+		r.pipelineMetr.RemoveContainer(err == nil, state.version, startedAt)
+		if err != nil {
+			r.logger.Error().Err(err).Str("run_id", state.runID).Str("container_id", c.ID).Msg("failed to remove cluster container")
+		}
+	}
+
+	// The network is pruned by gc if it cannot be removed now.
+	err = r.engine.removeNetwork(ctx, state.cluster.network)
+	if err != nil {
+		r.logger.Error().Err(err).Str("run_id", state.runID).Msg("failed to remove cluster network")
+		return
+	}
+
+	r.logger.Debug().Str("run_id", state.runID).Int("containers", len(containers)).Msg("cluster has been removed")
+}
+
+// waitClusterReady waits until the rest of servers accept queries and the query node can reach Keeper.
+// The query node must be ready.
+func (r *Runner) waitClusterReady(ctx context.Context, state *requestState) error {
+	for _, id := range state.cluster.nodes[1:] {
+		node := *state
+		node.containerID = id
+
+		_, err := runnerutil.Poll(ctx, r.cfg.Readiness, func(ctx context.Context) (bool, error) {
+			return r.probeExec(ctx, &node)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "node %s", id)
+		}
+	}
+
+	_, err := runnerutil.Poll(ctx, r.cfg.Readiness, func(ctx context.Context) (bool, error) {
+		res, err := r.execCommand(ctx, state, state.cluster.driver.ClusterReadinessCommand(), nil)
+		if err != nil {
+			return false, err
+		}
+
+		return res.exitCode == 0, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "keeper")
+	}
+
+	return nil
+}
@@ -1,15 +1,39 @@
 package dockerengine
 
-import "time"
+import (
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+)
+
+// ExecutorType defines how queries are sent to the database in a container.
+type ExecutorType string
+
+const (
+	// ExecutorExec runs clickhouse client in the container via docker exec.
+	ExecutorExec ExecutorType = "EXEC"
+
+	// ExecutorHTTP sends queries to the ClickHouse HTTP interface of the container directly.
+	// The runner must be able to reach containers by their IP addresses.
+	ExecutorHTTP ExecutorType = "HTTP"
+)
 
 type Config struct {
 	DaemonURL *string
 
-	ExecRetryDelay time.Duration
-	MaxExecRetries int
+	Executor ExecutorType
+	HTTP     HTTPConfig
+
+	// Readiness configures how the database is probed before the query is executed.
+	Readiness runnerutil.ReadinessConfig
 
 	DefaultOutputFormat string
 
+	// Statements of a query are executed one by one to get per-statement results.
+	// If a query has more statements, it's executed at once.
+	MaxSplitStatements int
+
 	// Path to the xml or yaml config which will be mounted to the ../config.d/ directory.
 	CustomConfigPath *string
 
@@ -17,6 +41,12 @@ type Config struct {
 	// https://clickhouse.com/docs/en/operations/quotas/
 	QuotasPath *string
 
+	// Datasets are mounted into containers and loaded before the query if a run requests them.
+	Datasets []dataset.Dataset
+
+	// Cluster configures runs against multi-node clusters.
+	Cluster ClusterConfig
+
 	GC *GCConfig
 
 	MaxWarmContainers         uint
@@ -25,6 +55,17 @@ type Config struct {
 	Container ContainerSettings
 }
 
+type HTTPConfig struct {
+	// Network is a Docker network containers are connected to, the HTTP interface is reached
+	// via the container address in this network. It's better to create an internal network
+	// (docker network create --internal) to keep containers isolated.
+	// If empty, the container network mode is used.
+	Network string
+
+	// Port of the HTTP interface inside the container.
+	Port int
+}
+
 type ContainerSettings struct {
 	NetworkMode *string // Network mode to use for the container.
 
@@ -33,6 +74,12 @@ type ContainerSettings struct {
 	MemoryLimit uint64 // In bytes. If 0, then unlimited.
 }
 
+type ClusterConfig struct {
+	// MaxNodes limits the number of servers in a cluster, Keeper is not counted.
+	// If 0, clusters are disabled.
+	MaxNodes int
+}
+
 type GCConfig struct {
 	// How often GC will be triggered.
 	TriggerFrequency time.Duration
@@ -54,10 +101,19 @@ var defaultImageGCCountThreshold = uint(60)
 var defaultImageBufferSize = uint(30)
 
 var DefaultConfig = Config{
-	ExecRetryDelay: 200 * time.Millisecond,
-	MaxExecRetries: 20,
+	Executor: ExecutorExec,
+	HTTP: HTTPConfig{
+		Port: 8123,
+	},
+
+	Readiness: runnerutil.ReadinessConfig{
+		InitialDelay: 50 * time.Millisecond,
+		MaxDelay:     time.Second,
+		Timeout:      30 * time.Second,
+	},
 
 	DefaultOutputFormat: "TabSeparated",
+	MaxSplitStatements:  50,
 
 	CustomConfigPath: nil,
 	QuotasPath:       nil,
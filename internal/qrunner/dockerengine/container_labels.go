@@ -4,14 +4,20 @@ package dockerengine
 // Use it to find hanged up containers for garbage collection.
 const LabelOwnership = "clickhouse.playground.ownership"
 
+// LabelRun contains the id of the run the container has been created for.
+const LabelRun = "clickhouse.playground.run"
+
+// LabelRunner contains the name of the runner that has created the container.
+const LabelRunner = "clickhouse.playground.runner"
+
 // CreateContainerLabels returns default labels for created containers.
 // Use labels to find containers created for ch query running purposes
 // and to get some basic information what the image was used to run the container.
 func CreateContainerLabels(runnerName string, runID string, version string) map[string]string {
 	return map[string]string{
 		LabelOwnership:                  "1",
-		"clickhouse.playground.run":     runID,
+		LabelRun:                        runID,
 		"clickhouse.playground.version": version,
-		"clickhouse.playground.runner":  runnerName,
+		LabelRunner:                     runnerName,
 	}
 }
@@ -0,0 +1,56 @@
+package dockerengine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+)
+
+// datasetsDir is a directory in the container where dataset files are mounted.
+const datasetsDir = "/datasets"
+
+// datasetPath returns the path to the dataset file in the container.
+func datasetPath(d dataset.Dataset) string {
+	return path.Join(datasetsDir, d.Name+path.Ext(d.Path))
+}
+
+// loadDatasets loads datasets of the run in order before the query is executed.
+// If a dataset cannot be loaded, the database error is returned as the query error.
+func (r *Runner) loadDatasets(ctx context.Context, state *requestState) (queryErr *queryrun.QueryError, err error) {
+	if len(state.datasets) == 0 {
+		return nil, nil
+	}
+
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.LoadDatasets(err == nil && queryErr == nil, state.version, invokedAt)
+	}()
+
+	for _, ref := range state.datasets {
+		d, found := r.datasets[ref.Name]
+		if !found || d.Version != ref.Version {
+			return nil, errors.Errorf("dataset %s of version %s is not configured", ref.Name, ref.Version)
+		}
+
+		res, err := r.execCommand(ctx, state, state.driver.DatasetLoadCommand(d, datasetPath(d)), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load dataset %s", d.Name)
+		}
+
+		queryErr = res.queryError(state.driver)
+		if queryErr != nil {
+			queryErr.Message = fmt.Sprintf("failed to load dataset %s: %s", d.Name, queryErr.Message)
+			return queryErr, nil
+		}
+
+		r.logger.Debug().Str("run_id", state.runID).Str("dataset", d.Name).Msg("dataset has been loaded")
+	}
+
+	return nil, nil
+}
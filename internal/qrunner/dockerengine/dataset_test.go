@@ -0,0 +1,32 @@
+package dockerengine
+
+import (
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatasetPath(t *testing.T) {
+	script := dataset.Dataset{Name: "tpch", Version: "1", Path: "/data/tpch-v1.sql", Format: dataset.FormatSQL}
+	assert.Equal(t, "/datasets/tpch.sql", datasetPath(script))
+}
+
+func TestContainerConfig_mountsRequestedDatasets(t *testing.T) {
+	tpch := dataset.Dataset{Name: "tpch", Version: "1", Path: "/data/tpch-v1.sql", Format: dataset.FormatSQL}
+	hits := dataset.Dataset{Name: "hits", Version: "1", Path: "/data/hits.csv", Format: "CSV", Schema: "CREATE TABLE hits", Table: "hits"}
+	r := &Runner{
+		datasets: map[string]dataset.Dataset{tpch.Name: tpch, hits.Name: hits},
+	}
+
+	_, hostConfig := r.containerConfig(&requestState{})
+	assert.Empty(t, hostConfig.Mounts)
+
+	_, hostConfig = r.containerConfig(&requestState{datasets: []queryrun.DatasetRef{{Name: "hits", Version: "1"}}})
+	if assert.Len(t, hostConfig.Mounts, 1) {
+		assert.Equal(t, "/data/hits.csv", hostConfig.Mounts[0].Source)
+		assert.Equal(t, "/datasets/hits.csv", hostConfig.Mounts[0].Target)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
 	dockercli "github.com/docker/docker/client"
 	"github.com/pkg/errors"
 )
@@ -135,8 +136,9 @@ func (p *engineProvider) removeImage(ctx context.Context, tag string, pruneChild
 	})
 }
 
-func (p *engineProvider) createContainer(ctx context.Context, config *container.Config, hostConfig *container.HostConfig) (container.CreateResponse, error) {
-	return p.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+func (p *engineProvider) createContainer(ctx context.Context, config *container.Config, hostConfig *container.HostConfig,
+	networkingConfig *network.NetworkingConfig) (container.CreateResponse, error) {
+	return p.cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, "")
 }
 
 func (p *engineProvider) startContainer(ctx context.Context, id string) error {
@@ -152,23 +154,37 @@ func (p *engineProvider) unpauseContainer(ctx context.Context, id string) error
 }
 
 // exec executes the given command in the container and attaches to it.
+// It returns the exec id that can be used to get the exit code.
 // Keep in mind that you have to close the returned response.
-func (p *engineProvider) exec(ctx context.Context, containerID string, cmd []string) (types.HijackedResponse, error) {
+func (p *engineProvider) exec(ctx context.Context, containerID string, cmd []string) (string, types.HijackedResponse, error) {
 	exec, err := p.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
 		AttachStderr: true,
 		AttachStdout: true,
 		Cmd:          cmd,
 	})
 	if err != nil {
-		return types.HijackedResponse{}, errors.Wrap(err, "exec create failed")
+		return "", types.HijackedResponse{}, errors.Wrap(err, "exec create failed")
 	}
 
 	resp, err := p.cli.ContainerExecAttach(ctx, exec.ID, container.ExecStartOptions{})
 	if err != nil {
-		return types.HijackedResponse{}, errors.Wrap(err, "exec attach failed")
+		return "", types.HijackedResponse{}, errors.Wrap(err, "exec attach failed")
 	}
 
-	return resp, nil
+	return exec.ID, resp, nil
+}
+
+// copyToContainer extracts the tar archive into the directory in the container.
+func (p *engineProvider) copyToContainer(ctx context.Context, id, dir string, archive io.Reader) error {
+	return p.cli.CopyToContainer(ctx, id, dir, archive, container.CopyToContainerOptions{})
+}
+
+func (p *engineProvider) inspectContainer(ctx context.Context, id string) (container.InspectResponse, error) {
+	return p.cli.ContainerInspect(ctx, id)
+}
+
+func (p *engineProvider) inspectExec(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return p.cli.ContainerExecInspect(ctx, execID)
 }
 
 func (p *engineProvider) getContainers(ctx context.Context) ([]container.Summary, error) {
@@ -180,6 +196,17 @@ func (p *engineProvider) getContainers(ctx context.Context) ([]container.Summary
 	})
 }
 
+// getRunContainers returns containers created for the given run.
+func (p *engineProvider) getRunContainers(ctx context.Context, runID string) ([]container.Summary, error) {
+	return p.cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg(p.ownershipLabelFilter()),
+			filters.Arg("label", LabelRun+"="+runID),
+		),
+	})
+}
+
 func (p *engineProvider) removeContainer(ctx context.Context, id string) error {
 	return p.cli.ContainerRemove(ctx, id, container.RemoveOptions{
 		RemoveVolumes: true,
@@ -187,6 +214,33 @@ func (p *engineProvider) removeContainer(ctx context.Context, id string) error {
 	})
 }
 
+// createNetwork creates an internal bridge network, containers connected to it cannot reach external hosts.
+func (p *engineProvider) createNetwork(ctx context.Context, name string, labels map[string]string) (string, error) {
+	resp, err := p.cli.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver:   "bridge",
+		Internal: true,
+		Labels:   labels,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+func (p *engineProvider) connectNetwork(ctx context.Context, networkID, containerID string) error {
+	return p.cli.NetworkConnect(ctx, networkID, containerID, nil)
+}
+
+func (p *engineProvider) removeNetwork(ctx context.Context, id string) error {
+	return p.cli.NetworkRemove(ctx, id)
+}
+
+// pruneNetworks removes unused networks created by the playground.
+func (p *engineProvider) pruneNetworks(ctx context.Context) (network.PruneReport, error) {
+	return p.cli.NetworksPrune(ctx, filters.NewArgs(filters.Arg(p.ownershipLabelFilter())))
+}
+
 func (p *engineProvider) pruneContainers(ctx context.Context) (container.PruneReport, error) {
 	return p.cli.ContainersPrune(ctx, filters.NewArgs(filters.Arg(p.ownershipLabelFilter())))
 }
@@ -156,6 +156,14 @@ func (g *garbageCollector) collectContainers() (count uint, spaceReclaimed uint6
 
 	g.metr.ReportPausedContainers(pausedContainers)
 
+	// Cluster networks are left if their runs have been interrupted, they're unused once containers are removed.
+	networks, err := g.engine.pruneNetworks(g.ctx)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("containers gc failed to prune networks")
+	} else if len(networks.NetworksDeleted) > 0 {
+		g.logger.Debug().Strs("networks", networks.NetworksDeleted).Msg("networks have been pruned")
+	}
+
 	return count, spaceReclaimed, nil
 }
 
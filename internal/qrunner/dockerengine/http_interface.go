@@ -0,0 +1,115 @@
+package dockerengine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	headerExceptionCode = "X-ClickHouse-Exception-Code"
+	headerSummary       = "X-ClickHouse-Summary"
+)
+
+// httpSummary is the final query progress sent in the X-ClickHouse-Summary header.
+type httpSummary struct {
+	ReadRows  uint64 `json:"read_rows,string"`
+	ReadBytes uint64 `json:"read_bytes,string"`
+}
+
+// httpInterface sends queries to the ClickHouse HTTP interface.
+// https://clickhouse.com/docs/en/interfaces/http
+type httpInterface struct {
+	client *http.Client
+}
+
+func newHTTPInterface() *httpInterface {
+	return &httpInterface{
+		client: &http.Client{},
+	}
+}
+
+// ping checks whether the server accepts HTTP requests.
+func (h *httpInterface) ping(ctx context.Context, address string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/ping", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// query executes a single statement. The stdout is passed to onStdout as soon as it's received.
+//
+// The server is asked to wait for the end of the query, so an exception is always reported
+// with an error status code instead of being appended to the output. The exception text
+// is returned as stderr and the exception code as the exit code, like clickhouse client does.
+func (h *httpInterface) query(ctx context.Context, address string, params url.Values, query string, onStdout qrunner.OutputHandler) (res execResult, err error) {
+	params.Set("wait_end_of_query", "1")
+	params.Set("send_progress_in_http_headers", "1")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+"/?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return res, errors.Wrap(err, "failed to create request")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return res, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return res, errors.Wrap(err, "failed to read exception")
+		}
+
+		res.stderr = string(body)
+
+		res.exitCode, err = strconv.Atoi(resp.Header.Get(headerExceptionCode))
+		if err != nil || res.exitCode == 0 {
+			res.exitCode = 1
+		}
+
+		return res, nil
+	}
+
+	out := runnerutil.NewOutputWriter(qrunner.StreamStdout, onStdout)
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to read output")
+	}
+
+	res.stdout = out.String()
+
+	// Old versions don't send the summary.
+	var summary httpSummary
+	if header := resp.Header.Get(headerSummary); header != "" && json.Unmarshal([]byte(header), &summary) == nil {
+		res.rowsRead = summary.ReadRows
+		res.bytesRead = summary.ReadBytes
+	}
+
+	return res, nil
+}
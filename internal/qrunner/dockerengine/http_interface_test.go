@@ -0,0 +1,75 @@
+package dockerengine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClickHouseHTTPMock(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			_, _ = io.WriteString(w, "Ok.\n")
+			return
+		}
+
+		assert.Equal(t, "1", r.URL.Query().Get("wait_end_of_query"))
+		assert.Equal(t, "run-1", r.URL.Query().Get("session_id"))
+
+		body, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(string(body), "SELEC ") {
+			w.Header().Set(headerExceptionCode, "62")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, "Code: 62. DB::Exception: Syntax error. (SYNTAX_ERROR)\n")
+
+			return
+		}
+
+		w.Header().Set(headerSummary, `{"read_rows":"2","read_bytes":"16","written_rows":"0"}`)
+		_, _ = io.WriteString(w, "0\n1\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestHTTPInterface_Query(t *testing.T) {
+	address := newClickHouseHTTPMock(t)
+	h := newHTTPInterface()
+
+	require.NoError(t, h.ping(context.Background(), address))
+
+	var chunks []string
+	onStdout := func(chunk qrunner.OutputChunk) {
+		chunks = append(chunks, chunk.Data)
+	}
+
+	res, err := h.query(context.Background(), address, url.Values{"session_id": []string{"run-1"}}, "SELECT number FROM numbers(2)", onStdout)
+	require.NoError(t, err)
+	assert.Equal(t, execResult{stdout: "0\n1\n", rowsRead: 2, bytesRead: 16}, res)
+	assert.Equal(t, "0\n1\n", strings.Join(chunks, ""))
+}
+
+func TestHTTPInterface_QueryException(t *testing.T) {
+	address := newClickHouseHTTPMock(t)
+	h := newHTTPInterface()
+
+	res, err := h.query(context.Background(), address, url.Values{"session_id": []string{"run-1"}}, "SELEC 1", nil)
+	require.NoError(t, err)
+	assert.Empty(t, res.stdout)
+	assert.Equal(t, 62, res.exitCode)
+
+	queryErr := res.queryError(dbdriver.ClickHouse{})
+	require.NotNil(t, queryErr)
+	assert.Equal(t, "SYNTAX_ERROR", queryErr.Name)
+}
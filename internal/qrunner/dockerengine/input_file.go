@@ -0,0 +1,70 @@
+package dockerengine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+)
+
+// filesArchive packs input files into a tar archive accepted by CopyToContainer.
+// Files must be readable by the clickhouse user, the owner is root.
+func filesArchive(files []queryrun.InputFile, modTime time.Time) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.Name,
+			Mode:     0o644,
+			Size:     int64(len(f.Content)),
+			ModTime:  modTime,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to write header of %s", f.Name)
+		}
+
+		_, err = tw.Write(f.Content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s", f.Name)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to close the archive")
+	}
+
+	return buf, nil
+}
+
+// copyFiles copies input files of the run into the user_files directory of the container.
+func (r *Runner) copyFiles(ctx context.Context, state *requestState) (err error) {
+	if len(state.files) == 0 {
+		return nil
+	}
+
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.CopyFiles(err == nil, state.version, invokedAt)
+	}()
+
+	archive, err := filesArchive(state.files, invokedAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to pack input files")
+	}
+
+	err = r.engine.copyToContainer(ctx, state.containerID, state.driver.FilesDir(), archive)
+	if err != nil {
+		return errors.Wrap(err, "failed to copy input files")
+	}
+
+	r.logger.Debug().Str("run_id", state.runID).Int("files", len(state.files)).Msg("input files have been copied")
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package dockerengine
+
+import (
+	"archive/tar"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesArchive(t *testing.T) {
+	files := []queryrun.InputFile{
+		queryrun.NewInputFile("data.csv", []byte("1,a\n2,b\n")),
+		queryrun.NewInputFile("empty.tsv", nil),
+	}
+
+	archive, err := filesArchive(files, time.Now())
+	require.NoError(t, err)
+
+	tr := tar.NewReader(archive)
+	for _, f := range files {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, f.Name, hdr.Name)
+		assert.EqualValues(t, 0o644, hdr.Mode)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, string(f.Content), string(content))
+	}
+
+	_, err = tr.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,56 @@
+package dockerengine
+
+import (
+	"context"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+
+	"github.com/pkg/errors"
+)
+
+// localContainerCmd keeps a container without a server alive, queries are executed in it with exec.
+var localContainerCmd = []string{"sleep", "infinity"}
+
+// createLocalContainer starts a container without a server. It starts immediately,
+// so there is no need to wait for readiness or to prewarm such containers.
+func (r *Runner) createLocalContainer(ctx context.Context, state *requestState) (err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.CreateLocalContainer(err == nil, state.version, invokedAt)
+	}()
+
+	err = r.pull(ctx, state)
+	if err != nil {
+		return errors.Wrap(err, "pull failed")
+	}
+
+	contConfig, hostConfig := r.containerConfig(state)
+	contConfig.Cmd = localContainerCmd
+
+	// The query doesn't need network even if the HTTP executor is used.
+	hostConfig.NetworkMode = "none"
+
+	state.containerID, err = r.startContainer(ctx, state, contConfig, hostConfig, nil, nil)
+
+	return err
+}
+
+// runLocalQuery executes the whole query with one clickhouse local command.
+// Tables created by the query don't survive between commands, so statements are not executed separately.
+func (r *Runner) runLocalQuery(ctx context.Context, state *requestState, onOutput qrunner.OutputHandler) (res queryResult, err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.RunLocalQuery(err == nil, state.version, invokedAt)
+	}()
+
+	exec, err := r.execStatement(ctx, state, state.query, onOutput)
+	if err != nil {
+		return res, err
+	}
+
+	res.output = appendStderr(exec.stdout, exec.stderr, onOutput)
+	res.queryError = exec.queryError(state.driver)
+
+	return res, nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -31,9 +32,8 @@ type prewarmer struct {
 
 	lock sync.Mutex
 
-	containers          map[string]*containerState
-	latestRequestsQueue []requestState
-	signals             chan struct{}
+	containers map[string]*containerState
+	queue      *runnerutil.PrewarmQueue[requestState]
 
 	maxWarmContainers uint
 }
@@ -49,46 +49,24 @@ func newPrewarmer(ctx context.Context, logger zerolog.Logger, runner containerRu
 		runner:            runner,
 		engine:            engine,
 		containers:        make(map[string]*containerState),
-		signals:           make(chan struct{}, 1),
 		maxWarmContainers: maxWarmContainers,
-	}
-}
-
-func (p *prewarmer) notify() {
-	select {
-	case p.signals <- struct{}{}:
-	default:
+		queue: runnerutil.NewPrewarmQueue(maxWarmContainers, func(r requestState) string {
+			return r.imageFQN
+		}),
 	}
 }
 
 func (p *prewarmer) Start() error {
 	p.logger.Info().Msg("prewarmer has been started")
 
-	for {
-		select {
-		case <-p.ctx.Done():
-			return nil
-
-		case <-p.signals:
-		}
-
-		req, count := p.extractNextRequest()
-		if count == 0 {
-			continue
-		}
-
+	p.queue.Run(p.ctx, func(req requestState) {
 		err := p.runContainer(&req)
 		if err != nil {
 			p.logger.Err(err).Str("image", req.imageFQN).Msg("failed to start a prewarmed container")
 		}
+	})
 
-		// If there are some unprocessed images, they will be processed in
-		// the next loop iteration.
-		// Images are processed one by one to provide better actualization
-		if count > 1 {
-			p.notify()
-		}
-	}
+	return nil
 }
 
 func (p *prewarmer) Stop(shutdownCtx context.Context) {
@@ -114,27 +92,12 @@ func (p *prewarmer) Stop(shutdownCtx context.Context) {
 	p.logger.Info().Msg("prewarmer has been stopped")
 }
 
-// extractNextRequest extracts the next request and
-// the number of requests in the queue, waiting to be processed.
-func (p *prewarmer) extractNextRequest() (request requestState, count int) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-
-	count = len(p.latestRequestsQueue)
-	if count == 0 {
-		return requestState{}, 0
-	}
-
-	request = p.latestRequestsQueue[0]
-	p.latestRequestsQueue = p.latestRequestsQueue[1:]
-
-	return request, count
-}
-
 func (p *prewarmer) runContainer(request *requestState) error {
 	state := requestState{
 		runID:    "PREWARMING",
 		query:    " ",
+		database: request.database,
+		driver:   request.driver,
 		version:  request.version,
 		imageTag: request.imageTag,
 		imageFQN: request.imageFQN,
@@ -225,23 +188,7 @@ func (p *prewarmer) ejectContainer() {
 // PushNewRequest should be called when a new request comes.
 // It remembers the request and signals the background worker to process this new images.
 func (p *prewarmer) PushNewRequest(request requestState) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-
-	// If there is such an image in the waiting queue, skip it.
-	for _, r := range p.latestRequestsQueue {
-		if r.imageFQN == request.imageFQN {
-			return
-		}
-	}
-
-	// Add a new item to the queue and trim the prefix if necessary.
-	p.latestRequestsQueue = append(p.latestRequestsQueue, request)
-	if len(p.latestRequestsQueue) >= int(p.maxWarmContainers) && len(p.latestRequestsQueue) > 0 {
-		p.latestRequestsQueue = p.latestRequestsQueue[1:]
-	}
-
-	p.notify()
+	p.queue.Push(request)
 }
 
 // Fetch returns id of a warm container if it exists.
@@ -1,24 +1,28 @@
 package dockerengine
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
 	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
-	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
 	"github.com/lodthe/clickhouse-playground/internal/dockertag"
 	"github.com/lodthe/clickhouse-playground/internal/metrics"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
-	"github.com/lodthe/clickhouse-playground/pkg/chspec"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	dockercli "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/pkg/errors"
@@ -43,15 +47,20 @@ type Runner struct {
 	cfg  Config
 
 	engine       *engineProvider
+	http         *httpInterface
+	datasets     map[string]dataset.Dataset
 	tagStorage   ImageStorage
 	pipelineMetr *metrics.PipelineExporter
 
+	inflight *runnerutil.InflightRuns
+
 	workers   sync.WaitGroup
 	gc        *garbageCollector
 	status    *statusCollector
 	prewarmer *prewarmer
 }
 
+// New creates a runner. The tag storage can be nil if images are passed with runs, see qrunner.WithImage.
 func New(ctx context.Context, logger zerolog.Logger, name string, cfg Config, tagStorage ImageStorage) (*Runner, error) {
 	engine, err := newProvider(ctx, cfg.DaemonURL)
 	if err != nil {
@@ -70,9 +79,19 @@ func New(ctx context.Context, logger zerolog.Logger, name string, cfg Config, ta
 		cfg:          cfg,
 		engine:       engine,
 		tagStorage:   tagStorage,
+		inflight:     runnerutil.NewInflightRuns(),
+		datasets:     make(map[string]dataset.Dataset, len(cfg.Datasets)),
 		pipelineMetr: metrics.NewPipelineExporter(string(qrunner.TypeDockerEngine), name),
 	}
 
+	if cfg.Executor == ExecutorHTTP {
+		runner.http = newHTTPInterface()
+	}
+
+	for _, d := range cfg.Datasets {
+		runner.datasets[d.Name] = d
+	}
+
 	runner.gc = newGarbageCollector(ctx, logger, cfg.GC, engine, metrics.NewRunnerGCExporter(string(qrunner.TypeDockerEngine), name))
 	runner.status = newStatusCollector(ctx, logger, cfg.StatusCollectionFrequency, engine, metrics.NewRunnerStatusExporter(string(qrunner.TypeDockerEngine), name))
 	runner.prewarmer = newPrewarmer(ctx, logger, runner, runner.engine, cfg.MaxWarmContainers)
@@ -88,6 +107,15 @@ func (r *Runner) Name() string {
 	return r.name
 }
 
+// Features returns run features the runner supports. Datasets are supported if any of them is configured.
+func (r *Runner) Features() qrunner.Features {
+	if len(r.datasets) == 0 {
+		return qrunner.AllFeatures &^ qrunner.FeatureDatasets
+	}
+
+	return qrunner.AllFeatures
+}
+
 func (r *Runner) Status(ctx context.Context) qrunner.RunnerStatus {
 	err := r.engine.ping(ctx)
 
@@ -141,34 +169,142 @@ func (r *Runner) Stop(shutdownCtx context.Context) error {
 	return nil
 }
 
+// ReleaseIdleResources removes prewarmed containers. Prewarming is not resumed, so it's called
+// when the runner is drained and is going to be stopped.
+func (r *Runner) ReleaseIdleResources(ctx context.Context) {
+	r.prewarmer.Stop(ctx)
+}
+
 func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (output string, err error) {
+	return r.RunQueryStream(ctx, run, nil)
+}
+
+// RunQueryStream runs the query and passes its output to onOutput while the query is being executed.
+// If onOutput is nil, the output is only returned when the query is finished.
+//
+// If the run is cancelled via CancelRun, the partial output and qrunner.ErrRunCancelled are returned.
+func (r *Runner) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (output string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	inflight := runnerutil.NewInflightRun(cancel)
+	r.inflight.Add(run.ID, inflight)
+	defer r.inflight.Remove(run.ID)
+
+	output, err = r.runQuery(ctx, run, func(chunk qrunner.OutputChunk) {
+		inflight.AppendOutput(chunk)
+		if onOutput != nil {
+			onOutput(chunk)
+		}
+	})
+	if inflight.IsCancelled() {
+		return inflight.PartialOutput(), qrunner.ErrRunCancelled
+	}
+
+	return output, err
+}
+
+// CancelRun stops the run by removing its container.
+//
+// If the run is not being executed by this runner (e.g. the runner has been restarted),
+// containers labeled with the run id are removed anyway.
+func (r *Runner) CancelRun(ctx context.Context, runID string) (string, error) {
+	inflight, found := r.inflight.Get(runID)
+	if found {
+		inflight.MarkCancelled()
+		r.logger.Info().Str("run_id", runID).Msg("run has been cancelled")
+
+		return inflight.PartialOutput(), nil
+	}
+
+	containers, err := r.engine.getRunContainers(ctx, runID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find run containers")
+	}
+	if len(containers) == 0 {
+		return "", qrunner.ErrRunNotFound
+	}
+
+	for _, c := range containers {
+		err = r.engine.removeContainer(ctx, c.ID)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to remove run container")
+		}
+
+		r.logger.Info().Str("run_id", runID).Str("container_id", c.ID).Msg("orphaned run container has been removed")
+	}
+
+	return "", nil
+}
+
+func (r *Runner) runQuery(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (output string, err error) {
 	state := &requestState{
 		runID:    run.ID,
 		database: run.Database,
 		version:  run.Version,
 		query:    run.Input,
 		settings: run.Settings,
+		datasets: run.Datasets,
+		files:    run.Files,
+		topology: run.Topology,
+	}
+
+	driver, found := dbdriver.Get(dbsettings.Type(run.Database))
+	if !found {
+		return "", errors.Errorf("unknown database %s", run.Database)
 	}
+	state.driver = driver
 
-	state.imageTag, state.imageFQN, err = r.constructImageFQN(state.version)
+	options := driver.RunOptions(run.Settings)
+	state.structured = options.Structured
+	state.splitStatements = options.SplitStatements
+
+	state.imageTag, state.imageFQN, err = r.constructImageFQN(ctx, state.version)
 	if err != nil {
 		return "", fmt.Errorf("failed to construct FQN: %w", err)
 	}
 
-	containerID, found, err := r.prewarmer.Fetch(state.imageFQN)
-	if err != nil {
-		r.logger.Err(err).Str("run_id", state.runID).Msg("failed to fetch a prewarmed container")
+	if run.Mode == queryrun.ModeLocal {
+		state.local, found = driver.(dbdriver.LocalDriver)
+		if !found {
+			return "", errors.Errorf("database %s doesn't support the local mode", run.Database)
+		}
 	}
-	if found {
-		state.containerID = containerID
-	} else {
-		err := r.createContainer(ctx, state)
+
+	switch {
+	case state.local != nil:
+		err = r.createLocalContainer(ctx, state)
 		if err != nil {
-			return "", fmt.Errorf("failed to create container: %w", err)
+			return "", fmt.Errorf("failed to create local container: %w", err)
+		}
+
+	case state.topology != nil:
+		err = r.createCluster(ctx, state)
+		if err != nil {
+			return "", fmt.Errorf("failed to create cluster: %w", err)
 		}
-	}
 
-	r.prewarmer.PushNewRequest(*state)
+	default:
+		// Prewarmed containers have no datasets mounted, so runs with datasets get a new container.
+		var containerID string
+		var warm bool
+		if len(state.datasets) == 0 {
+			containerID, warm, err = r.prewarmer.Fetch(state.imageFQN)
+			if err != nil {
+				r.logger.Err(err).Str("run_id", state.runID).Msg("failed to fetch a prewarmed container")
+			}
+		}
+		if warm {
+			state.containerID = containerID
+		} else {
+			err := r.createContainer(ctx, state)
+			if err != nil {
+				return "", fmt.Errorf("failed to create container: %w", err)
+			}
+		}
+
+		r.prewarmer.PushNewRequest(*state)
+	}
 
 	done := make(chan struct{})
 	defer close(done)
@@ -179,6 +315,11 @@ func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (output string
 		case <-done:
 		}
 
+		if state.cluster != nil {
+			r.removeCluster(r.ctx, state)
+			return
+		}
+
 		startedAt := time.Now()
 		defer func() {
 			r.pipelineMetr.RemoveContainer(err == nil, "", startedAt)
@@ -193,22 +334,36 @@ func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (output string
 		r.logger.Debug().Str("container_id", state.containerID).Msg("container has been force removed")
 	}()
 
-	output, err = r.runQueryWithContainer(ctx, state)
+	var res queryResult
+	if state.local != nil {
+		res, err = r.runLocalQuery(ctx, state, onOutput)
+	} else {
+		res, err = r.runQueryWithContainer(ctx, state, onOutput)
+	}
+	run.Container = state.containerState
 	if err != nil {
 		return "", errors.Wrap(err, "failed to run query")
 	}
 
-	return output, nil
+	run.Statements = res.statements
+	run.QueryError = res.queryError
+	run.ResultSet = res.resultSet
+
+	return res.output, nil
 }
 
 // constructImageFQN builds image tag and FQN from version.
+// The image set with qrunner.WithImage is preferred to the image storage.
 // If there is no such a version, an error is returned.
 //
 // Otherwise, an image is fetched and the following names are built:
 // - image tag: image name in format <repository>:<version>
 // - image FQN: a unique fully qualified name that includes the exact version of the image
-func (r *Runner) constructImageFQN(version string) (imageTag string, imageFQN string, err error) {
-	img, found := r.tagStorage.Find(version)
+func (r *Runner) constructImageFQN(ctx context.Context, version string) (imageTag string, imageFQN string, err error) {
+	img, found := qrunner.ImageFromContext(ctx)
+	if !found && r.tagStorage != nil {
+		img, found = r.tagStorage.Find(version)
+	}
 	if !found {
 		return "", "", errors.New("version not found")
 	}
@@ -223,7 +378,7 @@ func (r *Runner) constructImageFQN(version string) (imageTag string, imageFQN st
 func (r *Runner) createContainer(ctx context.Context, state *requestState) error {
 	if state.imageFQN == "" || state.imageTag == "" {
 		var err error
-		state.imageTag, state.imageFQN, err = r.constructImageFQN(state.version)
+		state.imageTag, state.imageFQN, err = r.constructImageFQN(ctx, state.version)
 		if err != nil {
 			return fmt.Errorf("failed to construct FQN: %w", err)
 		}
@@ -314,6 +469,15 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 		r.pipelineMetr.CreateContainer(err == nil, state.version, invokedAt)
 	}()
 
+	contConfig, hostConfig := r.containerConfig(state)
+
+	state.containerID, err = r.startContainer(ctx, state, contConfig, hostConfig, nil, nil)
+
+	return err
+}
+
+// containerConfig returns configs of a database container for the run.
+func (r *Runner) containerConfig(state *requestState) (*container.Config, *container.HostConfig) {
 	contConfig := &container.Config{
 		Image:  state.imageFQN,
 		Labels: CreateContainerLabels(r.name, state.runID, state.version),
@@ -323,6 +487,9 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 	if r.cfg.Container.NetworkMode != nil {
 		networkMode = *r.cfg.Container.NetworkMode
 	}
+	if r.http != nil && r.cfg.HTTP.Network != "" {
+		networkMode = r.cfg.HTTP.Network
+	}
 
 	// Network is disabled to prevent malicious attacks and to optimize container start up.
 	hostConfig := &container.HostConfig{
@@ -339,7 +506,7 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
 			Type:     mount.TypeBind,
 			Source:   *r.cfg.CustomConfigPath,
-			Target:   fmt.Sprintf("/etc/clickhouse-server/config.d/custom-config%s", path.Ext(*r.cfg.CustomConfigPath)),
+			Target:   fmt.Sprintf("%s/custom-config%s", state.driver.ServerConfigDir(), path.Ext(*r.cfg.CustomConfigPath)),
 			ReadOnly: true,
 		})
 	}
@@ -349,14 +516,50 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
 			Type:     mount.TypeBind,
 			Source:   *r.cfg.QuotasPath,
-			Target:   fmt.Sprintf("/etc/clickhouse-server/users.d/default%s", path.Ext(*r.cfg.QuotasPath)),
+			Target:   fmt.Sprintf("%s/default%s", state.driver.UsersConfigDir(), path.Ext(*r.cfg.QuotasPath)),
+			ReadOnly: true,
+		})
+	}
+
+	// Only datasets requested by the run are mounted. Unknown datasets are reported when they are loaded.
+	for _, ref := range state.datasets {
+		d, found := r.datasets[ref.Name]
+		if !found {
+			continue
+		}
+
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   d.Path,
+			Target:   datasetPath(d),
 			ReadOnly: true,
 		})
 	}
 
-	cont, err := r.engine.createContainer(ctx, contConfig, hostConfig)
+	return contConfig, hostConfig
+}
+
+// startContainer creates and starts a container. Config files are copied to the config.d directory
+// of the server before the start.
+func (r *Runner) startContainer(ctx context.Context, state *requestState, contConfig *container.Config, hostConfig *container.HostConfig,
+	networkingConfig *network.NetworkingConfig, configs []queryrun.InputFile) (string, error) {
+	invokedAt := time.Now()
+
+	cont, err := r.engine.createContainer(ctx, contConfig, hostConfig, networkingConfig)
 	if err != nil {
-		return errors.Wrap(err, "container cannot be created")
+		return "", errors.Wrap(err, "container cannot be created")
+	}
+
+	if len(configs) > 0 {
+		archive, err := filesArchive(configs, invokedAt)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to pack configs")
+		}
+
+		err = r.engine.copyToContainer(ctx, cont.ID, state.driver.ServerConfigDir(), archive)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to copy configs")
+		}
 	}
 
 	createdAt := time.Now()
@@ -373,7 +576,7 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 
 	err = r.engine.startContainer(ctx, cont.ID)
 	if err != nil {
-		return errors.Wrap(err, "container cannot be started")
+		return "", errors.Wrap(err, "container cannot be started")
 	}
 
 	r.logger.Debug().
@@ -381,95 +584,444 @@ func (r *Runner) runContainer(ctx context.Context, state *requestState) (err err
 		Dur("elapsed_ms", time.Since(createdAt)).
 		Msg("container has been started")
 
-	state.containerID = cont.ID
+	return cont.ID, nil
+}
 
-	return nil
+// execResult is the result of a command executed in a container.
+type execResult struct {
+	stdout   string
+	stderr   string
+	exitCode int
+
+	// Statistics are reported only by the HTTP interface.
+	rowsRead  uint64
+	bytesRead uint64
+}
+
+// queryError returns the database error if the query has failed.
+func (res execResult) queryError(driver dbdriver.Driver) *queryrun.QueryError {
+	return driver.QueryError(res.stderr, res.exitCode)
 }
 
-func (r *Runner) execQuery(ctx context.Context, state *requestState) (stdout string, stderr string, err error) {
+// execQuery executes the query in the container. The stdout is passed to onStdout as soon as it's received.
+func (r *Runner) execQuery(ctx context.Context, state *requestState, query string, onStdout qrunner.OutputHandler) (res execResult, err error) {
 	invokedAt := time.Now()
 	defer func() {
 		r.pipelineMetr.ExecCommand(err == nil, state.version, invokedAt)
 	}()
 
 	var args []string
+	switch {
+	case state.local != nil:
+		args, err = state.local.LocalQueryCommand(state.version, query, state.settings, r.cfg.DefaultOutputFormat)
 
-	switch state.settings.Type() {
-	case dbsettings.TypeClickHouse:
-		args = []string{
-			"clickhouse", "client",
-			"-n",
-			"-m",
-			"--query", state.query,
-		}
-
-		settings, ok := state.settings.(*runsettings.ClickHouseSettings)
-		if !ok {
-			return "", "", errors.Errorf("invalid settings for type %s", state.settings.Type())
-		}
+	case r.http != nil:
+		return r.queryHTTP(ctx, state, query, onStdout)
 
-		formatArgs := settings.FormatArgs(state.version, r.cfg.DefaultOutputFormat)
-		args = append(args, formatArgs...)
 	default:
-		return "", "", errors.Errorf("unknown settings type %s", state.settings.Type())
+		args, err = state.driver.QueryCommand(state.version, query, state.settings, r.cfg.DefaultOutputFormat)
+	}
+	if err != nil {
+		return res, errors.Wrap(err, "failed to build the query command")
 	}
 
-	resp, err := r.engine.exec(ctx, state.containerID, args)
+	return r.execCommand(ctx, state, args, onStdout)
+}
+
+// execCommand executes the command in the container and waits until it exits.
+func (r *Runner) execCommand(ctx context.Context, state *requestState, args []string, onStdout qrunner.OutputHandler) (res execResult, err error) {
+	invokedAt := time.Now()
+
+	execID, resp, err := r.engine.exec(ctx, state.containerID, args)
 	if err != nil {
-		return "", "", errors.Wrap(err, "exec failed")
+		return res, errors.Wrap(err, "exec failed")
 	}
 	defer resp.Close()
 
 	// https://github.com/moby/moby/blob/8e610b2b55bfd1bfa9436ab110d311f5e8a74dcb/integration/internal/container/exec.go#L38
-	var outBuf, errBuf bytes.Buffer
-	outputDone := make(chan error)
+	outBuf := runnerutil.NewOutputWriter(qrunner.StreamStdout, onStdout)
+	errBuf := runnerutil.NewOutputWriter(qrunner.StreamStderr, nil)
+	outputDone := make(chan error, 1)
 
 	go func() {
-		_, err = stdcopy.StdCopy(&outBuf, &errBuf, resp.Reader)
+		_, err := stdcopy.StdCopy(outBuf, errBuf, resp.Reader)
 		outputDone <- err
 	}()
 
 	select {
 	case err := <-outputDone:
 		if err != nil {
-			return "", "", errors.Wrap(err, "failed to get output")
+			return res, errors.Wrap(err, "failed to get output")
 		}
 
 	case <-ctx.Done():
-		return "", "", ctx.Err()
+		return res, ctx.Err()
+	}
+
+	inspect, err := r.engine.inspectExec(ctx, execID)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to inspect exec")
 	}
 
 	r.logger.Debug().Str("run_id", state.runID).Dur("elapsed_ms", time.Since(invokedAt)).Msg("exec finished")
 
-	return outBuf.String(), errBuf.String(), nil
+	return execResult{
+		stdout:   outBuf.String(),
+		stderr:   errBuf.String(),
+		exitCode: inspect.ExitCode,
+	}, nil
 }
 
-func (r *Runner) runQueryWithContainer(ctx context.Context, state *requestState) (output string, err error) {
+// waitReady polls the database until it accepts queries. Probes are retried with exponential backoff,
+// so the user query is executed only once, when the database is ready.
+func (r *Runner) waitReady(ctx context.Context, state *requestState) (err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.WaitReady(err == nil, state.version, invokedAt)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Readiness.Timeout)
+	defer cancel()
+
+	if r.http != nil {
+		err = r.resolveHTTPAddress(ctx, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	attempts, err := runnerutil.Poll(ctx, r.cfg.Readiness, func(ctx context.Context) (bool, error) {
+		return r.probe(ctx, state)
+	})
+	if err != nil {
+		return err
+	}
+
+	if state.cluster != nil {
+		err = r.waitClusterReady(ctx, state)
+		if err != nil {
+			return errors.Wrap(err, "cluster is not ready")
+		}
+	}
+
+	r.logger.Debug().
+		Str("run_id", state.runID).
+		Int("attempts", attempts).
+		Dur("elapsed_ms", time.Since(invokedAt)).
+		Msg("database is ready")
+
+	return nil
+}
+
+// probe checks whether the database accepts queries.
+func (r *Runner) probe(ctx context.Context, state *requestState) (bool, error) {
+	if r.http != nil {
+		err := r.http.ping(ctx, state.httpAddress)
+		if err != nil {
+			r.logger.Debug().Err(err).Str("run_id", state.runID).Msg("readiness probe failed")
+		}
+
+		return err == nil, nil
+	}
+
+	return r.probeExec(ctx, state)
+}
+
+// probeExec checks whether the database accepts queries from the client in the container.
+func (r *Runner) probeExec(ctx context.Context, state *requestState) (bool, error) {
+	res, err := r.execCommand(ctx, state, state.driver.ReadinessCommand(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Connection errors are expected while the server is starting, other failures are logged.
+	if res.exitCode != 0 && !state.driver.IsStarting(res.stderr) {
+		r.logger.Debug().Str("run_id", state.runID).Str("stderr", res.stderr).Msg("readiness probe failed")
+	}
+
+	return res.exitCode == 0, nil
+}
+
+// resolveHTTPAddress finds the address of the container HTTP interface.
+func (r *Runner) resolveHTTPAddress(ctx context.Context, state *requestState) error {
+	inspect, err := r.engine.inspectContainer(ctx, state.containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to inspect container")
+	}
+
+	var ip string
+	if inspect.NetworkSettings != nil {
+		for name, endpoint := range inspect.NetworkSettings.Networks {
+			if endpoint == nil || endpoint.IPAddress == "" {
+				continue
+			}
+
+			if r.cfg.HTTP.Network == "" || name == r.cfg.HTTP.Network {
+				ip = endpoint.IPAddress
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return errors.Errorf("container %s has no IP address", state.containerID)
+	}
+
+	state.httpAddress = net.JoinHostPort(ip, strconv.Itoa(r.cfg.HTTP.Port))
+
+	return nil
+}
+
+// queryHTTP executes a single statement via the HTTP interface. Statements of a run share
+// the same session, so session settings and temporary tables survive between them.
+func (r *Runner) queryHTTP(ctx context.Context, state *requestState, query string, onStdout qrunner.OutputHandler) (execResult, error) {
+	driver, ok := state.driver.(dbdriver.HTTPDriver)
+	if !ok {
+		return execResult{}, errors.Errorf("database %s doesn't support the http interface", state.database)
+	}
+
+	params, err := driver.HTTPParams(state.settings, r.cfg.DefaultOutputFormat)
+	if err != nil {
+		return execResult{}, err
+	}
+	params.Set("session_id", state.runID)
+
+	return r.http.query(ctx, state.httpAddress, params, query, onStdout)
+}
+
+// execStatement executes the query once, the database must be ready.
+func (r *Runner) execStatement(ctx context.Context, state *requestState, query string, onStdout qrunner.OutputHandler) (res execResult, err error) {
+	res, err = r.execQuery(ctx, state, query, onStdout)
+
+	// The client may fail or the exec may be rejected because the server has been killed.
+	if (err != nil || res.exitCode != 0) && ctx.Err() == nil {
+		inspectErr := r.inspectContainer(ctx, state)
+		if inspectErr != nil {
+			return res, inspectErr
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	r.pipelineMetr.ExecExited(state.version, res.exitCode)
+
+	return res, nil
+}
+
+// inspectContainer saves the container state after a failed exec.
+// If a process has been killed by the OOM killer, ErrMemoryLimitExceeded is returned.
+func (r *Runner) inspectContainer(ctx context.Context, state *requestState) error {
+	inspect, err := r.engine.inspectContainer(ctx, state.containerID)
+	if err != nil {
+		r.logger.Warn().Err(err).Str("run_id", state.runID).Msg("failed to inspect container")
+		return nil
+	}
+	if inspect.ContainerJSONBase == nil || inspect.State == nil {
+		return nil
+	}
+
+	state.containerState = &queryrun.ContainerState{
+		Status:    inspect.State.Status,
+		ExitCode:  inspect.State.ExitCode,
+		OOMKilled: inspect.State.OOMKilled,
+	}
+
+	if !inspect.State.OOMKilled {
+		return nil
+	}
+
+	r.pipelineMetr.OOMKilled(state.version)
+	r.logger.Info().
+		Str("run_id", state.runID).
+		Str("container_id", state.containerID).
+		Str("container_status", inspect.State.Status).
+		Msg("container process has been killed by the OOM killer")
+
+	return qrunner.ErrMemoryLimitExceeded
+}
+
+// queryResult is the result of a query executed in a container.
+type queryResult struct {
+	output     string
+	statements []queryrun.StatementResult
+	queryError *queryrun.QueryError
+	resultSet  *queryrun.ResultSet
+}
+
+// runQueryWithContainer waits until the database is ready and executes the query.
+//
+// The query is executed at once in a single client session by default. If it's requested, statements
+// are executed one by one to get their results separately, unless there are too many statements.
+// The HTTP interface doesn't support multi-statement queries, so statements sent via it are always split.
+func (r *Runner) runQueryWithContainer(ctx context.Context, state *requestState, onOutput qrunner.OutputHandler) (res queryResult, err error) {
 	invokedAt := time.Now()
 	defer func() {
 		r.pipelineMetr.RunQuery(err == nil, state.version, invokedAt)
 	}()
 
-	var stdout string
-	var stderr string
+	err = r.waitReady(ctx, state)
+	if err != nil {
+		// The server may have been killed during the startup.
+		if ctx.Err() == nil {
+			inspectErr := r.inspectContainer(ctx, state)
+			if inspectErr != nil {
+				return res, inspectErr
+			}
+		}
+
+		return res, err
+	}
+
+	err = r.copyFiles(ctx, state)
+	if err != nil {
+		return res, err
+	}
+
+	queryErr, err := r.loadDatasets(ctx, state)
+	if err != nil {
+		return res, err
+	}
+	if queryErr != nil {
+		res.output = appendStderr("", queryErr.Message+"\n", onOutput)
+		res.queryError = queryErr
+
+		return res, nil
+	}
+
+	statements := state.driver.SplitStatements(state.query)
 
-	for retry := 0; retry < r.cfg.MaxExecRetries; retry++ {
-		stdout, stderr, err = r.execQuery(ctx, state)
+	if len(statements) > 0 && (r.http != nil || (state.splitStatements && len(statements) <= r.cfg.MaxSplitStatements)) {
+		return r.runStatements(ctx, state, statements, onOutput)
+	}
+
+	exec, err := r.execStatement(ctx, state, state.query, onOutput)
+	if err != nil {
+		return res, err
+	}
+
+	res.output = appendStderr(exec.stdout, exec.stderr, onOutput)
+	res.queryError = exec.queryError(state.driver)
+
+	if state.structured && res.queryError == nil && len(statements) > 0 {
+		// Only session statements are repeated, e.g. temporary tables don't exist in the new session.
+		var session []string
+		for _, stmt := range statements[:len(statements)-1] {
+			if state.driver.IsSessionStatement(stmt) {
+				session = append(session, stmt)
+			}
+		}
+
+		res.resultSet, err = r.queryResultSet(ctx, state, session, statements[len(statements)-1])
 		if err != nil {
-			return "", err
+			return res, err
 		}
+	}
+
+	return res, nil
+}
 
-		if chspec.CheckIfClickHouseIsReady(stderr) {
-			r.logger.Debug().Str("run_id", state.runID).Msg("query has been executed")
+// runStatements executes statements in order in the same container and collects their results.
+// Like the client, it stops at the first failed statement, its error is the error of the query.
+//
+// Every exec has a new client session, so session statements (SET, USE)
+// are repeated before the following statements. Statements sent via the HTTP interface
+// share the session, nothing is repeated.
+//
+// If a structured result is requested, the last statement is executed once again in a structured format
+// after it has succeeded, so the output keeps the requested format. Statements that don't return rows
+// or may return different rows are not executed again, see dbdriver.Driver.ResultSetQuery.
+func (r *Runner) runStatements(ctx context.Context, state *requestState, statements []string, onOutput qrunner.OutputHandler) (queryResult, error) {
+	var res queryResult
+	var output strings.Builder
+	var session []string
+
+	for _, stmt := range statements {
+		startedAt := time.Now()
+		exec, err := r.execStatement(ctx, state, withSession(session, stmt), onOutput)
+		if err != nil {
+			return res, err
+		}
+
+		elapsed := time.Since(startedAt)
+		queryErr := exec.queryError(state.driver)
+
+		res.statements = append(res.statements, queryrun.StatementResult{
+			Statement:     stmt,
+			Output:        exec.stdout,
+			Error:         exec.stderr,
+			ExecutionTime: elapsed,
+			RowsRead:      exec.rowsRead,
+			BytesRead:     exec.bytesRead,
+		})
+		output.WriteString(appendStderr(exec.stdout, exec.stderr, onOutput))
+
+		if queryErr != nil {
+			res.queryError = queryErr
 			break
 		}
 
-		time.Sleep(r.cfg.ExecRetryDelay)
+		// Statements sent via the HTTP interface share the session.
+		if state.driver.IsSessionStatement(stmt) && r.http == nil {
+			session = append(session, stmt)
+		}
+	}
+
+	res.output = output.String()
+
+	if state.structured && res.queryError == nil {
+		resultSet, err := r.queryResultSet(ctx, state, session, statements[len(statements)-1])
+		if err != nil {
+			return res, err
+		}
+
+		res.resultSet = resultSet
+	}
+
+	return res, nil
+}
+
+// queryResultSet executes the statement after session statements in a structured format and parses its result.
+// The result set is optional, so query and parsing errors are only logged. If the statement cannot be
+// structured (e.g. it doesn't return rows), nil is returned.
+func (r *Runner) queryResultSet(ctx context.Context, state *requestState, session []string, statement string) (*queryrun.ResultSet, error) {
+	query, ok := state.driver.ResultSetQuery(statement)
+	if !ok {
+		return nil, nil
+	}
+
+	exec, err := r.execStatement(ctx, state, withSession(session, query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if queryErr := exec.queryError(state.driver); queryErr != nil {
+		r.logger.Warn().Str("run_id", state.runID).Str("error", queryErr.Message).Msg("structured result cannot be queried")
+		return nil, nil
 	}
 
+	resultSet, err := state.driver.ParseResultSet(exec.stdout)
+	if err != nil {
+		r.logger.Warn().Err(err).Str("run_id", state.runID).Msg("failed to parse structured output")
+		return nil, nil
+	}
+
+	return resultSet, nil
+}
+
+// withSession prepends session statements to the statement.
+func withSession(session []string, statement string) string {
+	return strings.Join(append(session[:len(session):len(session)], statement), ";\n")
+}
+
+// appendStderr appends non-empty stderr to the stdout and passes it to onOutput.
+func appendStderr(stdout string, stderr string, onOutput qrunner.OutputHandler) string {
 	if stderr == "" {
-		return stdout, nil
+		return stdout
+	}
+
+	if onOutput != nil {
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStderr, Data: "\n" + stderr})
 	}
 
-	return stdout + "\n" + stderr, nil
+	return stdout + "\n" + stderr
 }
@@ -2,20 +2,35 @@ package dockerengine
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
 	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	dockercli "github.com/docker/docker/client"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type tagStorageMock struct {
@@ -65,6 +80,10 @@ func TestCustomSettings(t *testing.T) {
 		query          string
 		expectedOutput string
 		runSettings    runsettings.RunSettings
+
+		expectedColumns    []queryrun.Column
+		expectedStatements int
+		expectedErrorCode  int
 	}{
 		{
 			database:       "clickhouse",
@@ -87,17 +106,67 @@ func TestCustomSettings(t *testing.T) {
 			expectedOutput: "1\n",
 			runSettings:    &runsettings.ClickHouseSettings{OutputFormat: "PrettyCompactMonoBlock"},
 		},
+		{
+			database:           "clickhouse",
+			version:            "21",
+			query:              "SELECT 0;\nSET max_threads = 1;\nSELECT 1 AS x, getSetting('max_threads') AS t",
+			expectedOutput:     "0\n1\t1\n",
+			runSettings:        &runsettings.ClickHouseSettings{Structured: true, SplitStatements: true},
+			expectedColumns:    []queryrun.Column{{Name: "x", Type: "UInt8"}, {Name: "t", Type: "UInt64"}},
+			expectedStatements: 3,
+		},
+		{
+			database:       "clickhouse",
+			version:        "21",
+			query:          "CREATE TEMPORARY TABLE t (x UInt8); INSERT INTO t VALUES (5); SELECT x FROM t",
+			expectedOutput: "5\n",
+			runSettings:    &runsettings.ClickHouseSettings{},
+		},
+		{
+			database:        "clickhouse",
+			version:         "21",
+			query:           "SELECT 1 AS x",
+			expectedOutput:  "┌─x─┐\n│ 1 │\n└───┘\n",
+			runSettings:     &runsettings.ClickHouseSettings{OutputFormat: "PrettyCompactMonoBlock", Structured: true},
+			expectedColumns: []queryrun.Column{{Name: "x", Type: "UInt8"}},
+		},
+		{
+			database:           "clickhouse",
+			version:            "21",
+			query:              "SELECT 1; SELECT unknown_column; SELECT 2",
+			expectedOutput:     "1\n",
+			runSettings:        &runsettings.ClickHouseSettings{SplitStatements: true},
+			expectedStatements: 2,
+			expectedErrorCode:  47,
+		},
 	}
 
 	rcfg := DefaultConfig
 	runner, _ := New(ctx, logger, "Test", rcfg, tagStorage)
 
 	for _, tc := range cases {
-		output, err := runner.RunQuery(ctx, &queryrun.Run{Input: tc.query, Version: tc.version, Database: tc.database, Settings: tc.runSettings})
+		run := &queryrun.Run{Input: tc.query, Version: tc.version, Database: tc.database, Settings: tc.runSettings}
+		output, err := runner.RunQuery(ctx, run)
 		if err != nil {
 			t.Log(err.Error())
 		}
 		assert.Contains(t, output, tc.expectedOutput, "output doesn't contain expected results")
+
+		if tc.expectedColumns != nil && assert.NotNil(t, run.ResultSet) {
+			assert.Equal(t, tc.expectedColumns, run.ResultSet.Columns)
+			assert.EqualValues(t, 1, run.ResultSet.Statistics.Rows)
+		}
+
+		if tc.expectedStatements != 0 {
+			assert.Len(t, run.Statements, tc.expectedStatements)
+		}
+
+		if tc.expectedErrorCode == 0 {
+			assert.Nil(t, run.QueryError)
+		} else if assert.NotNil(t, run.QueryError) {
+			assert.Equal(t, tc.expectedErrorCode, run.QueryError.Code)
+			assert.NotZero(t, run.QueryError.ExitCode)
+		}
 	}
 
 	t.Cleanup(func() {
@@ -120,3 +189,83 @@ func TestCustomSettings(t *testing.T) {
 		}
 	})
 }
+
+// newReadinessTestRunner creates a runner using the HTTP interface of a stub server.
+// The stub Docker Engine API reports the container address as 127.0.0.1, and the stub ClickHouse
+// server becomes ready after failedPings pings.
+func newReadinessTestRunner(t *testing.T, failedPings int32, readiness runnerutil.ReadinessConfig) (*Runner, *int32) {
+	pings := new(int32)
+	chSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(pings, 1) <= failedPings {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = io.WriteString(w, "Ok.\n")
+	}))
+	t.Cleanup(chSrv.Close)
+
+	dockerSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			w.Header().Set("API-Version", "1.41")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(container.InspectResponse{
+			NetworkSettings: &container.NetworkSettings{
+				Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "127.0.0.1"}},
+			},
+		})
+	}))
+	t.Cleanup(dockerSrv.Close)
+
+	cli, err := dockercli.NewClientWithOpts(dockercli.WithHost("tcp://"+strings.TrimPrefix(dockerSrv.URL, "http://")), dockercli.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(chSrv.URL, "http://"))
+	require.NoError(t, err)
+
+	cfg := DefaultConfig
+	cfg.Readiness = readiness
+	cfg.HTTP.Port, err = strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return &Runner{
+		logger: zerolog.Nop(),
+		cfg:    cfg,
+		engine: &engineProvider{mainCtx: context.Background(), cli: cli},
+		http:   newHTTPInterface(),
+		// Metrics are registered globally, so every runner has a unique name.
+		pipelineMetr: metrics.NewPipelineExporter(string(qrunner.TypeDockerEngine), uuid.New().String()),
+	}, pings
+}
+
+func TestRunner_waitReady(t *testing.T) {
+	r, pings := newReadinessTestRunner(t, 2, runnerutil.ReadinessConfig{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     10 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	})
+
+	state := &requestState{runID: "run", version: "23.3", containerID: "container"}
+	require.NoError(t, r.waitReady(context.Background(), state))
+	assert.Equal(t, int32(3), atomic.LoadInt32(pings))
+	assert.Equal(t, "127.0.0.1:"+strconv.Itoa(r.cfg.HTTP.Port), state.httpAddress)
+}
+
+func TestRunner_waitReadyTimeout(t *testing.T) {
+	r, pings := newReadinessTestRunner(t, math.MaxInt32, runnerutil.ReadinessConfig{
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     10 * time.Millisecond,
+		Timeout:      100 * time.Millisecond,
+	})
+
+	startedAt := time.Now()
+	err := r.waitReady(context.Background(), &requestState{runID: "run", version: "23.3", containerID: "container"})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "database is not ready")
+	assert.Less(t, time.Since(startedAt), time.Second)
+	assert.Greater(t, atomic.LoadInt32(pings), int32(1))
+}
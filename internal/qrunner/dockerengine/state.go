@@ -1,7 +1,9 @@
 package dockerengine
 
 import (
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
 	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
 )
 
 // requestState holds information about a processing query execution request.
@@ -12,7 +14,21 @@ type requestState struct {
 	version  string
 	query    string
 
+	driver dbdriver.Driver
+
+	// local is set if the query is executed without a server.
+	local dbdriver.LocalDriver
+
 	settings runsettings.RunSettings
+	datasets []queryrun.DatasetRef
+	files    []queryrun.InputFile
+	topology *queryrun.Topology
+
+	// If structured is true, the result of the last statement is parsed into a result set.
+	structured bool
+
+	// If splitStatements is true, statements are executed separately to get their results.
+	splitStatements bool
 
 	// <repository>:<version>
 	imageTag string
@@ -21,4 +37,13 @@ type requestState struct {
 	imageFQN string
 
 	containerID string
+
+	// cluster is set if the query is run against a cluster, containerID is the query node then.
+	cluster *clusterState
+
+	// httpAddress is the host:port of the container HTTP interface.
+	httpAddress string
+
+	// containerState is set when the container is inspected after a failed exec.
+	containerState *queryrun.ContainerState
 }
@@ -3,3 +3,17 @@ package qrunner
 import "github.com/pkg/errors"
 
 var ErrNoAvailableRunners = errors.New("no available runners, try again later")
+
+// ErrRunNotFound is returned when a run cannot be cancelled because it's not being executed.
+var ErrRunNotFound = errors.New("run is not being executed")
+
+// ErrRunCancelled is returned by RunQuery when the run has been cancelled via CancelRun.
+// The output received before the cancellation is returned along with the error.
+var ErrRunCancelled = errors.New("run has been cancelled")
+
+// ErrMemoryLimitExceeded is returned by RunQuery when a database process has been killed
+// because of the container memory limit.
+var ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+
+// ErrRunNotSupported is returned when none of runners supports features required by the run.
+var ErrRunNotSupported = errors.New("run features are not supported by any runner")
@@ -0,0 +1,50 @@
+package qrunner
+
+import "github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+// Features is a set of optional run features.
+type Features uint8
+
+const (
+	FeatureDatasets Features = 1 << iota
+	FeatureFiles
+	FeatureClusters
+
+	AllFeatures = FeatureDatasets | FeatureFiles | FeatureClusters
+)
+
+// Has reports whether all features of other are in the set.
+func (f Features) Has(other Features) bool {
+	return f&other == other
+}
+
+// RunFeatures returns the optional features the run requires.
+func RunFeatures(run *queryrun.Run) Features {
+	var f Features
+	if len(run.Datasets) > 0 {
+		f |= FeatureDatasets
+	}
+	if len(run.Files) > 0 {
+		f |= FeatureFiles
+	}
+	if run.Topology != nil {
+		f |= FeatureClusters
+	}
+
+	return f
+}
+
+// FeatureReporter is implemented by runners that don't support all run features.
+// Runners that don't implement it are expected to support all of them.
+type FeatureReporter interface {
+	Features() Features
+}
+
+// SupportedFeatures returns the run features the runner supports.
+func SupportedFeatures(r Runner) Features {
+	if reporter, ok := r.(FeatureReporter); ok {
+		return reporter.Features()
+	}
+
+	return AllFeatures
+}
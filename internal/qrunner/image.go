@@ -0,0 +1,23 @@
+package qrunner
+
+import (
+	"context"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+)
+
+type imageKey struct{}
+
+// WithImage returns a context carrying the image the run must be executed with.
+// It's used when images are chosen on another host, e.g. the coordinator sends images to agents.
+// Unlike a shared version-to-image storage, concurrent runs of the same version cannot replace images of each other.
+func WithImage(ctx context.Context, img dockertag.Image) context.Context {
+	return context.WithValue(ctx, imageKey{}, img)
+}
+
+// ImageFromContext returns the image set with WithImage.
+func ImageFromContext(ctx context.Context) (dockertag.Image, bool) {
+	img, found := ctx.Value(imageKey{}).(dockertag.Image)
+
+	return img, found
+}
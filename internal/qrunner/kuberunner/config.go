@@ -0,0 +1,79 @@
+package kuberunner
+
+import (
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+)
+
+type Config struct {
+	// Kubeconfig is a path to the kubeconfig file. If nil, the in-cluster config is used.
+	Kubeconfig *string
+
+	// Namespace where pods are created.
+	Namespace string
+
+	// Readiness configures how the database is probed before the query is executed.
+	// Images are pulled by the kubelet, so the readiness timeout includes the image pull.
+	Readiness runnerutil.ReadinessConfig
+
+	DefaultOutputFormat string
+
+	GC *GCConfig
+
+	MaxWarmPods               uint
+	StatusCollectionFrequency time.Duration
+
+	Pod PodSettings
+
+	// IsolateNetwork enables a NetworkPolicy denying all ingress and egress traffic of pods.
+	// The policy is only enforced if the cluster network plugin supports network policies.
+	IsolateNetwork bool
+}
+
+type PodSettings struct {
+	CPULimit    uint64 // In millicores. If 0, then unlimited.
+	MemoryLimit uint64 // In bytes. If 0, then unlimited.
+
+	// NodeSelector restricts nodes pods can be scheduled on.
+	NodeSelector map[string]string
+
+	// ImagePullSecrets are names of secrets used to pull database images.
+	ImagePullSecrets []string
+}
+
+type GCConfig struct {
+	// How often GC will be triggered.
+	TriggerFrequency time.Duration
+
+	// During the garbage collection, all pods created before (time.Now() - PodTTL) are removed.
+	// Prewarmed pods are kept for PrewarmedPodsMaxTTL.
+	PodTTL time.Duration
+}
+
+var DefaultConfig = Config{
+	Namespace: "default",
+
+	Readiness: runnerutil.ReadinessConfig{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     2 * time.Second,
+		Timeout:      3 * time.Minute,
+	},
+
+	DefaultOutputFormat: "TabSeparated",
+
+	GC: &GCConfig{
+		TriggerFrequency: 5 * time.Minute,
+		PodTTL:           5 * time.Minute,
+	},
+
+	MaxWarmPods:               5,
+	StatusCollectionFrequency: 30 * time.Second,
+
+	Pod: PodSettings{
+		CPULimit:    2000,
+		MemoryLimit: 1 * 1e9,
+	},
+
+	IsolateNetwork: true,
+}
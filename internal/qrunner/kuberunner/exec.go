@@ -0,0 +1,69 @@
+package kuberunner
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// podExecutor executes commands in the database container of a pod.
+type podExecutor interface {
+	// exec runs the command and waits until it exits. A non-zero exit code is not an error.
+	exec(ctx context.Context, namespace string, pod string, args []string, stdout io.Writer, stderr io.Writer) (exitCode int, err error)
+}
+
+// spdyExecutor executes commands via the pods/exec subresource.
+type spdyExecutor struct {
+	client kubernetes.Interface
+	config *rest.Config
+}
+
+func newSPDYExecutor(client kubernetes.Interface, config *rest.Config) *spdyExecutor {
+	return &spdyExecutor{
+		client: client,
+		config: config,
+	}
+}
+
+func (e *spdyExecutor) exec(ctx context.Context, namespace string, pod string, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	req := e.client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   args,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.config, http.MethodPost, req.URL())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create executor")
+	}
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "exec stream failed")
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,118 @@
+package kuberunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrewarmedPodsMaxTTL is how long prewarmed pods are kept if they have not been fetched.
+const PrewarmedPodsMaxTTL = 24 * time.Hour
+
+type garbageCollector struct {
+	ctx context.Context
+
+	logger zerolog.Logger
+
+	cfg *GCConfig
+
+	runner *Runner
+	metr   *metrics.RunnerGCExporter
+}
+
+func newGarbageCollector(ctx context.Context, logger zerolog.Logger, cfg *GCConfig, runner *Runner, metr *metrics.RunnerGCExporter) *garbageCollector {
+	return &garbageCollector{
+		ctx:    ctx,
+		logger: logger,
+		cfg:    cfg,
+		runner: runner,
+		metr:   metr,
+	}
+}
+
+func (g *garbageCollector) start() {
+	if g.cfg == nil {
+		g.logger.Info().Msg("garbage collector is disabled due to a missed configuration")
+		return
+	}
+
+	g.logger.Info().Dur("trigger_frequency", g.cfg.TriggerFrequency).Msg("gc has been started")
+	defer g.logger.Info().Msg("gc has been finished")
+
+	trigger := func() {
+		_, err := g.collectPods()
+		if err != nil {
+			g.logger.Err(err).Msg("gc trigger failed")
+		}
+	}
+
+	trigger()
+
+	t := time.NewTicker(g.cfg.TriggerFrequency)
+
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+
+		case <-t.C:
+		}
+
+		trigger()
+	}
+}
+
+// collectPods removes finished pods and pods orphaned by interrupted runs.
+// A pod is orphaned if it has been alive at least for GCConfig.PodTTL, prewarmed pods are kept longer.
+func (g *garbageCollector) collectPods() (count uint, err error) {
+	startedAt := time.Now()
+	defer func() {
+		g.metr.ContainersCollected(count, 0, startedAt)
+	}()
+
+	pods, err := g.runner.client.CoreV1().Pods(g.runner.cfg.Namespace).List(g.ctx, metav1.ListOptions{
+		LabelSelector: ownedPodsSelector(g.runner.name),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list pods")
+	}
+
+	var prewarmedPods uint
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		ttl := g.cfg.PodTTL
+		if pod.Labels[dockerengine.LabelRun] == prewarmingRunID {
+			prewarmedPods++
+			ttl = PrewarmedPodsMaxTTL
+		}
+
+		finished := pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+		if !finished && time.Since(pod.CreationTimestamp.Time) < ttl {
+			continue
+		}
+
+		err = g.runner.deletePod(g.ctx, pod.Name)
+		if err != nil {
+			g.logger.Error().Err(err).Str("pod", pod.Name).Msg("pods gc failed to remove pod")
+			continue
+		}
+
+		g.logger.Debug().Str("pod", pod.Name).Str("phase", string(pod.Status.Phase)).Msg("pod has been removed")
+
+		count++
+	}
+
+	g.metr.ReportPausedContainers(prewarmedPods)
+
+	return count, nil
+}
@@ -0,0 +1,70 @@
+package kuberunner
+
+import (
+	"context"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// networkPolicyName returns the name of the policy isolating pods of the runner.
+func networkPolicyName(runnerName string) string {
+	return "chp-" + runnerName + "-isolation"
+}
+
+// networkPolicySpec returns a policy that denies all ingress and egress traffic of pods created by the runner.
+// Queries are executed via the pods/exec subresource, so pods don't need the network.
+func (r *Runner) networkPolicySpec() *networkingv1.NetworkPolicy {
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPolicyName(r.name),
+			Namespace: r.cfg.Namespace,
+			Labels: map[string]string{
+				dockerengine.LabelOwnership: "1",
+				dockerengine.LabelRunner:    r.name,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					dockerengine.LabelOwnership: "1",
+					dockerengine.LabelRunner:    r.name,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+}
+
+// ensureNetworkPolicy creates the policy isolating pods of the runner or updates the existing one.
+func (r *Runner) ensureNetworkPolicy(ctx context.Context) error {
+	policies := r.client.NetworkingV1().NetworkPolicies(r.cfg.Namespace)
+	policy := r.networkPolicySpec()
+
+	_, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, "create failed")
+	}
+
+	existing, err := policies.Get(ctx, policy.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "get failed")
+	}
+
+	existing.Labels = policy.Labels
+	existing.Spec = policy.Spec
+
+	_, err = policies.Update(ctx, existing, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "update failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package kuberunner
+
+import (
+	"fmt"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// containerName is the name of the database container in pods.
+const containerName = "database"
+
+// prewarmingRunID is the run label of prewarmed pods, it's replaced when a pod is fetched for a run.
+const prewarmingRunID = "PREWARMING"
+
+// localPodCommand keeps a pod without a server alive, queries are executed in it with exec.
+var localPodCommand = []string{"sleep", "infinity"}
+
+// newPodName returns a unique pod name. Run ids are not used, because prewarmed pods are created before runs.
+func newPodName() string {
+	return "chp-" + uuid.NewString()
+}
+
+// imageName returns the image reference pinned to the digest, so all pods of a version run the same image.
+func imageName(img dockertag.Image, version string) string {
+	name := dockerengine.FullImageName(img.Repository, version)
+	if img.Digest == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s@%s", name, img.Digest)
+}
+
+// ownedPodsSelector matches all pods created by the runner.
+func ownedPodsSelector(runnerName string) string {
+	return labels.Set{
+		dockerengine.LabelOwnership: "1",
+		dockerengine.LabelRunner:    runnerName,
+	}.String()
+}
+
+// runPodsSelector matches pods created by the runner for the run.
+func runPodsSelector(runnerName string, runID string) string {
+	return labels.Set{
+		dockerengine.LabelOwnership: "1",
+		dockerengine.LabelRunner:    runnerName,
+		dockerengine.LabelRun:       runID,
+	}.String()
+}
+
+// podSpec returns the pod of a database for the run. Pods are labeled like Docker containers.
+func (r *Runner) podSpec(state *requestState) *corev1.Pod {
+	container := corev1.Container{
+		Name:      containerName,
+		Image:     state.image,
+		Resources: r.podResources(),
+	}
+	if state.local != nil {
+		container.Command = localPodCommand
+	}
+
+	secrets := make([]corev1.LocalObjectReference, 0, len(r.cfg.Pod.ImagePullSecrets))
+	for _, name := range r.cfg.Pod.ImagePullSecrets {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	disabled := false
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      newPodName(),
+			Namespace: r.cfg.Namespace,
+			Labels:    dockerengine.CreateContainerLabels(r.name, state.runID, state.version),
+		},
+		Spec: corev1.PodSpec{
+			Containers:       []corev1.Container{container},
+			RestartPolicy:    corev1.RestartPolicyNever,
+			NodeSelector:     r.cfg.Pod.NodeSelector,
+			ImagePullSecrets: secrets,
+
+			// Queries must not reach the cluster API and services.
+			AutomountServiceAccountToken: &disabled,
+			EnableServiceLinks:           &disabled,
+		},
+	}
+}
+
+// podResources returns limits of the database container. Requests are equal to limits,
+// so pods are not evicted in favour of other workloads.
+func (r *Runner) podResources() corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+	if r.cfg.Pod.CPULimit > 0 {
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(r.cfg.Pod.CPULimit), resource.DecimalSI)
+	}
+	if r.cfg.Pod.MemoryLimit > 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(int64(r.cfg.Pod.MemoryLimit), resource.BinarySI)
+	}
+
+	if len(limits) == 0 {
+		return corev1.ResourceRequirements{}
+	}
+
+	return corev1.ResourceRequirements{
+		Limits:   limits,
+		Requests: limits.DeepCopy(),
+	}
+}
+
+// containerState returns the state of the database container in the pod.
+func containerState(pod *corev1.Pod) *queryrun.ContainerState {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != containerName {
+			continue
+		}
+
+		switch {
+		case status.State.Terminated != nil:
+			return &queryrun.ContainerState{
+				Status:    "exited",
+				ExitCode:  int(status.State.Terminated.ExitCode),
+				OOMKilled: status.State.Terminated.Reason == "OOMKilled",
+			}
+
+		case status.State.Running != nil:
+			return &queryrun.ContainerState{Status: "running"}
+
+		default:
+			return &queryrun.ContainerState{Status: "waiting"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package kuberunner
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podManager creates and removes pods of the prewarmed pool.
+type podManager interface {
+	createPod(ctx context.Context, state *requestState) error
+	deletePod(ctx context.Context, name string) error
+	assignPod(ctx context.Context, name string, runID string) error
+}
+
+type warmPod struct {
+	name      string
+	image     string
+	createdAt time.Time
+}
+
+// prewarmer keeps a pool of pods for recently requested images, so the database
+// is already started when a run of the same version comes.
+//
+// Unlike containers, pods cannot be paused, so warm pods keep consuming their resource requests.
+type prewarmer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger zerolog.Logger
+	metr   *metrics.PrewarmerExporter
+
+	pods podManager
+
+	lock sync.Mutex
+
+	pool  map[string]*warmPod
+	queue *runnerutil.PrewarmQueue[requestState]
+
+	maxWarmPods uint
+}
+
+func newPrewarmer(ctx context.Context, logger zerolog.Logger, pods podManager, maxWarmPods uint) *prewarmer {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &prewarmer{
+		ctx:         ctx,
+		cancel:      cancel,
+		logger:      logger,
+		metr:        metrics.NewPrewarmerExporter(),
+		pods:        pods,
+		pool:        make(map[string]*warmPod),
+		maxWarmPods: maxWarmPods,
+		queue: runnerutil.NewPrewarmQueue(maxWarmPods, func(r requestState) string {
+			return r.image
+		}),
+	}
+}
+
+func (p *prewarmer) start() {
+	p.logger.Info().Msg("prewarmer has been started")
+
+	p.queue.Run(p.ctx, func(req requestState) {
+		err := p.prewarm(&req)
+		if err != nil {
+			p.logger.Err(err).Str("image", req.image).Msg("failed to start a prewarmed pod")
+		}
+	})
+}
+
+// stop removes all prewarmed pods.
+func (p *prewarmer) stop(shutdownCtx context.Context) {
+	p.cancel()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.logger.Info().Int("count", len(p.pool)).Msg("start removing prewarmed pods")
+
+	for _, pod := range p.pool {
+		err := p.pods.deletePod(shutdownCtx, pod.name)
+		if err != nil {
+			p.logger.Err(err).Str("pod", pod.name).Msg("failed to remove pod")
+		} else {
+			p.metr.EjectContainer()
+		}
+	}
+
+	p.pool = make(map[string]*warmPod)
+
+	p.logger.Info().Msg("prewarmer has been stopped")
+}
+
+// prewarm creates a pod for the request image and adds it to the pool.
+func (p *prewarmer) prewarm(request *requestState) error {
+	p.lock.Lock()
+	_, found := p.pool[request.image]
+	p.lock.Unlock()
+	if found {
+		return nil
+	}
+
+	state := requestState{
+		runID:   prewarmingRunID,
+		version: request.version,
+		image:   request.image,
+	}
+	err := p.pods.createPod(p.ctx, &state)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a new pod")
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pool[request.image] = &warmPod{
+		name:      state.podName,
+		image:     state.image,
+		createdAt: time.Now(),
+	}
+
+	// If the number of prewarmed pods exceeds the limit, delete the oldest.
+	if len(p.pool) > int(p.maxWarmPods) {
+		p.ejectPod()
+	}
+
+	p.metr.AddContainer()
+	p.logger.Debug().Str("pod", state.podName).Str("image", state.image).
+		Msg("a new pod has been added to the prewarmed pool")
+
+	return nil
+}
+
+// ejectPod removes the oldest pod to allow a new pod to be created.
+func (p *prewarmer) ejectPod() {
+	var oldest *warmPod
+	for _, pod := range p.pool {
+		if oldest == nil || pod.createdAt.Before(oldest.createdAt) {
+			oldest = pod
+		}
+	}
+
+	delete(p.pool, oldest.image)
+
+	p.metr.EjectContainer()
+	p.logger.Debug().Str("pod", oldest.name).Str("image", oldest.image).
+		Msg("a pod has been ejected from the prewarmed pool")
+
+	go func() {
+		err := p.pods.deletePod(p.ctx, oldest.name)
+		if err != nil {
+			p.logger.Err(err).Str("pod", oldest.name).Msg("failed to remove pod")
+		}
+	}()
+}
+
+// pushNewRequest should be called when a new request comes.
+// It remembers the request and signals the background worker to prewarm its image.
+func (p *prewarmer) pushNewRequest(request requestState) {
+	p.queue.Push(request)
+}
+
+// fetch extracts a warm pod of the image from the pool and labels it with the run id,
+// so the run can be cancelled after a restart. The pod may be still starting.
+func (p *prewarmer) fetch(ctx context.Context, image string, runID string) (podName string, found bool) {
+	p.lock.Lock()
+	pod, found := p.pool[image]
+	delete(p.pool, image)
+	p.lock.Unlock()
+
+	if !found {
+		p.metr.FetchMiss()
+		p.logger.Debug().Str("image", image).Msg("prewarmer cache miss")
+
+		return "", false
+	}
+
+	p.metr.FetchContainer()
+
+	err := p.pods.assignPod(ctx, pod.name, runID)
+	if err != nil {
+		p.logger.Err(err).Str("pod", pod.name).Msg("failed to assign a prewarmed pod")
+
+		go func() {
+			_ = p.pods.deletePod(p.ctx, pod.name)
+		}()
+
+		return "", false
+	}
+
+	p.metr.FetchHit()
+	p.logger.Debug().Str("pod", pod.name).Str("image", image).Msg("prewarmer cache hit")
+
+	return pod.name, true
+}
+
+// assignPod replaces the run label of the pod.
+func (r *Runner) assignPod(ctx context.Context, name string, runID string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{dockerengine.LabelRun: runID},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal patch")
+	}
+
+	_, err = r.client.CoreV1().Pods(r.cfg.Namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+
+	return err
+}
@@ -0,0 +1,578 @@
+package kuberunner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/runnerutil"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type ImageStorage interface {
+	Find(version string) (dockertag.Image, bool)
+}
+
+// Runner is a runner that creates database instances in Kubernetes pods.
+//
+// Every run gets a short-lived pod, queries are executed via the pods/exec subresource.
+// The runner doesn't need a Docker socket, only access to pods of the namespace:
+// create, get, list, patch and delete pods, create pods/exec.
+// If the network is isolated, it also needs to create, get and update networkpolicies.
+type Runner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	logger zerolog.Logger
+
+	name string
+	cfg  Config
+
+	client       kubernetes.Interface
+	executor     podExecutor
+	tagStorage   ImageStorage
+	pipelineMetr *metrics.PipelineExporter
+
+	inflight *runnerutil.InflightRuns
+
+	workers   sync.WaitGroup
+	gc        *garbageCollector
+	status    *statusCollector
+	prewarmer *prewarmer
+}
+
+func New(ctx context.Context, logger zerolog.Logger, name string, cfg Config, tagStorage ImageStorage) (*Runner, error) {
+	restConfig, err := loadRESTConfig(cfg.Kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load Kubernetes config")
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Kubernetes client")
+	}
+
+	return newRunner(ctx, logger, name, cfg, tagStorage, client, newSPDYExecutor(client, restConfig)), nil
+}
+
+// loadRESTConfig loads the kubeconfig file. If the path is nil, the in-cluster config is used.
+func loadRESTConfig(kubeconfig *string) (*rest.Config, error) {
+	if kubeconfig == nil {
+		return rest.InClusterConfig()
+	}
+
+	return clientcmd.BuildConfigFromFlags("", *kubeconfig)
+}
+
+func newRunner(ctx context.Context, logger zerolog.Logger, name string, cfg Config, tagStorage ImageStorage,
+	client kubernetes.Interface, executor podExecutor) *Runner {
+	ctx, cancel := context.WithCancel(ctx)
+
+	logger = logger.With().Str("runner", name).Logger()
+
+	runner := &Runner{
+		ctx:          ctx,
+		cancel:       cancel,
+		logger:       logger,
+		name:         name,
+		cfg:          cfg,
+		client:       client,
+		executor:     executor,
+		tagStorage:   tagStorage,
+		inflight:     runnerutil.NewInflightRuns(),
+		pipelineMetr: metrics.NewPipelineExporter(string(qrunner.TypeKubernetes), name),
+	}
+
+	runner.gc = newGarbageCollector(ctx, logger, cfg.GC, runner, metrics.NewRunnerGCExporter(string(qrunner.TypeKubernetes), name))
+	runner.status = newStatusCollector(ctx, logger, cfg.StatusCollectionFrequency, runner, metrics.NewRunnerStatusExporter(string(qrunner.TypeKubernetes), name))
+	runner.prewarmer = newPrewarmer(ctx, logger, runner, cfg.MaxWarmPods)
+
+	return runner
+}
+
+func (r *Runner) Type() qrunner.Type {
+	return qrunner.TypeKubernetes
+}
+
+func (r *Runner) Name() string {
+	return r.name
+}
+
+// Features returns run features the runner supports.
+// Datasets, files and clusters rely on host paths and Docker networks, so none of them is supported.
+func (r *Runner) Features() qrunner.Features {
+	return 0
+}
+
+// Status checks that pods of the namespace can be listed.
+func (r *Runner) Status(ctx context.Context) qrunner.RunnerStatus {
+	_, err := r.client.CoreV1().Pods(r.cfg.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+
+	return qrunner.RunnerStatus{
+		Alive:            err == nil,
+		LivenessProbeErr: err,
+	}
+}
+
+// Start runs the following background tasks:
+// 1) gc -- removes orphaned pods;
+// 2) status exporter -- exports information about current state of the runner;
+// 3) prewarmer -- keeps pods of recently requested versions.
+//
+// If the network is isolated, the NetworkPolicy for pods is created before that.
+func (r *Runner) Start() error {
+	if r.cfg.IsolateNetwork {
+		err := r.ensureNetworkPolicy(r.ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to ensure the network policy")
+		}
+	}
+
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		r.gc.start()
+	}()
+
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		r.status.start()
+	}()
+
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		r.prewarmer.start()
+	}()
+
+	r.logger.Info().Str("namespace", r.cfg.Namespace).Msg("runner has been started")
+
+	return nil
+}
+
+func (r *Runner) Stop(shutdownCtx context.Context) error {
+	r.logger.Info().Msg("stopping")
+
+	r.prewarmer.stop(shutdownCtx)
+
+	r.cancel()
+	r.workers.Wait()
+
+	r.logger.Info().Msg("runner has been stopped")
+
+	return nil
+}
+
+// ReleaseIdleResources removes prewarmed pods. Prewarming is not resumed, so it's called
+// when the runner is drained and is going to be stopped.
+func (r *Runner) ReleaseIdleResources(ctx context.Context) {
+	r.prewarmer.stop(ctx)
+}
+
+func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (output string, err error) {
+	return r.RunQueryStream(ctx, run, nil)
+}
+
+// RunQueryStream runs the query and passes its output to onOutput while the query is being executed.
+// If onOutput is nil, the output is only returned when the query is finished.
+//
+// If the run is cancelled via CancelRun, the partial output and qrunner.ErrRunCancelled are returned.
+func (r *Runner) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (output string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	inflight := runnerutil.NewInflightRun(cancel)
+	r.inflight.Add(run.ID, inflight)
+	defer r.inflight.Remove(run.ID)
+
+	output, err = r.runQuery(ctx, run, func(chunk qrunner.OutputChunk) {
+		inflight.AppendOutput(chunk)
+		if onOutput != nil {
+			onOutput(chunk)
+		}
+	})
+	if inflight.IsCancelled() {
+		return inflight.PartialOutput(), qrunner.ErrRunCancelled
+	}
+
+	return output, err
+}
+
+// CancelRun stops the run by removing its pod.
+//
+// If the run is not being executed by this runner (e.g. the runner has been restarted),
+// pods labeled with the run id are removed anyway.
+func (r *Runner) CancelRun(ctx context.Context, runID string) (string, error) {
+	inflight, found := r.inflight.Get(runID)
+	if found {
+		inflight.MarkCancelled()
+		r.logger.Info().Str("run_id", runID).Msg("run has been cancelled")
+
+		return inflight.PartialOutput(), nil
+	}
+
+	pods, err := r.client.CoreV1().Pods(r.cfg.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: runPodsSelector(r.name, runID),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find run pods")
+	}
+	if len(pods.Items) == 0 {
+		return "", qrunner.ErrRunNotFound
+	}
+
+	for _, pod := range pods.Items {
+		err = r.deletePod(ctx, pod.Name)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to remove run pod")
+		}
+
+		r.logger.Info().Str("run_id", runID).Str("pod", pod.Name).Msg("orphaned run pod has been removed")
+	}
+
+	return "", nil
+}
+
+func (r *Runner) runQuery(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (output string, err error) {
+	// The coordinator doesn't dispatch such runs to the runner, see Features.
+	if qrunner.RunFeatures(run) != 0 {
+		return "", errors.New("datasets, files and clusters are not supported by the kubernetes runner")
+	}
+
+	state := &requestState{
+		runID:    run.ID,
+		database: run.Database,
+		version:  run.Version,
+		query:    run.Input,
+		settings: run.Settings,
+	}
+
+	driver, found := dbdriver.Get(dbsettings.Type(run.Database))
+	if !found {
+		return "", errors.Errorf("unknown database %s", run.Database)
+	}
+	state.driver = driver
+
+	if run.Mode == queryrun.ModeLocal {
+		state.local, found = driver.(dbdriver.LocalDriver)
+		if !found {
+			return "", errors.Errorf("database %s doesn't support the local mode", run.Database)
+		}
+	}
+
+	img, found := r.tagStorage.Find(state.version)
+	if !found {
+		return "", errors.New("version not found")
+	}
+	state.image = imageName(img, state.version)
+
+	if state.local != nil {
+		err = r.createPod(ctx, state)
+	} else {
+		state.podName, found = r.prewarmer.fetch(ctx, state.image, state.runID)
+		if !found {
+			err = r.createPod(ctx, state)
+		}
+
+		r.prewarmer.pushNewRequest(*state)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create pod: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+
+		startedAt := time.Now()
+
+		err := r.deletePod(r.ctx, state.podName)
+		r.pipelineMetr.RemoveContainer(err == nil, "", startedAt)
+		if err != nil {
+			r.logger.Error().Err(err).Str("run_id", state.runID).Msg("failed to remove pod")
+			return
+		}
+
+		r.logger.Debug().Str("pod", state.podName).Msg("pod has been removed")
+	}()
+
+	res, err := r.runQueryWithPod(ctx, state, onOutput)
+	run.Container = state.containerState
+	if err != nil {
+		return "", errors.Wrap(err, "failed to run query")
+	}
+
+	run.QueryError = res.queryError
+
+	return res.output, nil
+}
+
+// createPod creates a pod with a database, it doesn't wait until the pod is running.
+func (r *Runner) createPod(ctx context.Context, state *requestState) (err error) {
+	invokedAt := time.Now()
+	defer func() {
+		if state.local != nil {
+			r.pipelineMetr.CreateLocalContainer(err == nil, state.version, invokedAt)
+		} else {
+			r.pipelineMetr.CreateContainer(err == nil, state.version, invokedAt)
+		}
+	}()
+
+	pod, err := r.client.CoreV1().Pods(r.cfg.Namespace).Create(ctx, r.podSpec(state), metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "pod cannot be created")
+	}
+
+	state.podName = pod.Name
+
+	r.logger.Debug().
+		Str("run_id", state.runID).
+		Str("image", state.image).
+		Str("pod", pod.Name).
+		Dur("elapsed_ms", time.Since(invokedAt)).
+		Msg("pod has been created")
+
+	return nil
+}
+
+// deletePod removes the pod immediately. It's not an error if the pod has already been removed.
+func (r *Runner) deletePod(ctx context.Context, name string) error {
+	gracePeriod := int64(0)
+
+	err := r.client.CoreV1().Pods(r.cfg.Namespace).Delete(ctx, name, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriod,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// execResult is the result of a command executed in a pod.
+type execResult struct {
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+// queryError returns the database error if the query has failed.
+func (res execResult) queryError(driver dbdriver.Driver) *queryrun.QueryError {
+	return driver.QueryError(res.stderr, res.exitCode)
+}
+
+// execCommand executes the command in the pod and waits until it exits.
+func (r *Runner) execCommand(ctx context.Context, state *requestState, args []string, onStdout qrunner.OutputHandler) (execResult, error) {
+	invokedAt := time.Now()
+
+	outBuf := runnerutil.NewOutputWriter(qrunner.StreamStdout, onStdout)
+	errBuf := runnerutil.NewOutputWriter(qrunner.StreamStderr, nil)
+
+	exitCode, err := r.executor.exec(ctx, r.cfg.Namespace, state.podName, args, outBuf, errBuf)
+	if err != nil {
+		return execResult{}, errors.Wrap(err, "exec failed")
+	}
+
+	r.logger.Debug().Str("run_id", state.runID).Dur("elapsed_ms", time.Since(invokedAt)).Msg("exec finished")
+
+	return execResult{
+		stdout:   outBuf.String(),
+		stderr:   errBuf.String(),
+		exitCode: exitCode,
+	}, nil
+}
+
+// execQuery executes the whole query with one client command. The stdout is passed to onStdout as soon as it's received.
+func (r *Runner) execQuery(ctx context.Context, state *requestState, onStdout qrunner.OutputHandler) (res execResult, err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.ExecCommand(err == nil, state.version, invokedAt)
+	}()
+
+	var args []string
+	if state.local != nil {
+		args, err = state.local.LocalQueryCommand(state.version, state.query, state.settings, r.cfg.DefaultOutputFormat)
+	} else {
+		args, err = state.driver.QueryCommand(state.version, state.query, state.settings, r.cfg.DefaultOutputFormat)
+	}
+	if err != nil {
+		return res, errors.Wrap(err, "failed to build the query command")
+	}
+
+	res, err = r.execCommand(ctx, state, args, onStdout)
+
+	// The client may fail or the exec may be rejected because the server has been killed.
+	if (err != nil || res.exitCode != 0) && ctx.Err() == nil {
+		inspectErr := r.inspectPod(ctx, state)
+		if inspectErr != nil {
+			return res, inspectErr
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	r.pipelineMetr.ExecExited(state.version, res.exitCode)
+
+	return res, nil
+}
+
+// waitReady polls the pod until it's running and the database accepts queries.
+// Probes are retried with exponential backoff.
+func (r *Runner) waitReady(ctx context.Context, state *requestState) (err error) {
+	invokedAt := time.Now()
+	defer func() {
+		r.pipelineMetr.WaitReady(err == nil, state.version, invokedAt)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Readiness.Timeout)
+	defer cancel()
+
+	attempts, err := runnerutil.Poll(ctx, r.cfg.Readiness, func(ctx context.Context) (bool, error) {
+		return r.probe(ctx, state)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.logger.Debug().
+		Str("run_id", state.runID).
+		Int("attempts", attempts).
+		Dur("elapsed_ms", time.Since(invokedAt)).
+		Msg("database is ready")
+
+	return nil
+}
+
+// probe checks whether the pod is running and the database accepts queries from the client in the pod.
+func (r *Runner) probe(ctx context.Context, state *requestState) (bool, error) {
+	pod, err := r.client.CoreV1().Pods(r.cfg.Namespace).Get(ctx, state.podName, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get pod")
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+
+	case corev1.PodPending, "":
+		return false, nil
+
+	default:
+		return false, errors.Errorf("pod has finished with phase %s", pod.Status.Phase)
+	}
+
+	// There is no server in the local mode.
+	if state.local != nil {
+		return true, nil
+	}
+
+	res, err := r.execCommand(ctx, state, state.driver.ReadinessCommand(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Connection errors are expected while the server is starting, other failures are logged.
+	if res.exitCode != 0 && !state.driver.IsStarting(res.stderr) {
+		r.logger.Debug().Str("run_id", state.runID).Str("stderr", res.stderr).Msg("readiness probe failed")
+	}
+
+	return res.exitCode == 0, nil
+}
+
+// inspectPod saves the container state after a failed exec.
+// If the database has been killed by the OOM killer, ErrMemoryLimitExceeded is returned.
+func (r *Runner) inspectPod(ctx context.Context, state *requestState) error {
+	pod, err := r.client.CoreV1().Pods(r.cfg.Namespace).Get(ctx, state.podName, metav1.GetOptions{})
+	if err != nil {
+		r.logger.Warn().Err(err).Str("run_id", state.runID).Msg("failed to inspect pod")
+		return nil
+	}
+
+	state.containerState = containerState(pod)
+	if state.containerState == nil || !state.containerState.OOMKilled {
+		return nil
+	}
+
+	r.pipelineMetr.OOMKilled(state.version)
+	r.logger.Info().
+		Str("run_id", state.runID).
+		Str("pod", state.podName).
+		Msg("database has been killed by the OOM killer")
+
+	return qrunner.ErrMemoryLimitExceeded
+}
+
+// queryResult is the result of a query executed in a pod.
+type queryResult struct {
+	output     string
+	queryError *queryrun.QueryError
+}
+
+// runQueryWithPod waits until the database is ready and executes the query at once.
+func (r *Runner) runQueryWithPod(ctx context.Context, state *requestState, onOutput qrunner.OutputHandler) (res queryResult, err error) {
+	invokedAt := time.Now()
+	defer func() {
+		if state.local != nil {
+			r.pipelineMetr.RunLocalQuery(err == nil, state.version, invokedAt)
+		} else {
+			r.pipelineMetr.RunQuery(err == nil, state.version, invokedAt)
+		}
+	}()
+
+	err = r.waitReady(ctx, state)
+	if err != nil {
+		// The server may have been killed during the startup.
+		if ctx.Err() == nil {
+			inspectErr := r.inspectPod(ctx, state)
+			if inspectErr != nil {
+				return res, inspectErr
+			}
+		}
+
+		return res, err
+	}
+
+	exec, err := r.execQuery(ctx, state, onOutput)
+	if err != nil {
+		return res, err
+	}
+
+	res.output = appendStderr(exec.stdout, exec.stderr, onOutput)
+	res.queryError = exec.queryError(state.driver)
+
+	return res, nil
+}
+
+// appendStderr appends non-empty stderr to the stdout and passes it to onOutput.
+func appendStderr(stdout string, stderr string, onOutput qrunner.OutputHandler) string {
+	if stderr == "" {
+		return stdout
+	}
+
+	if onOutput != nil {
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStderr, Data: "\n" + stderr})
+	}
+
+	return stdout + "\n" + stderr
+}
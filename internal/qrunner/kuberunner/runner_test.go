@@ -0,0 +1,301 @@
+package kuberunner
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testDigest = "sha256:edfee043e4f909dd471c6e282ce3cfd0ce90a4cad3fc234cb27633debe26ea05"
+
+type tagStorageMock struct{}
+
+func (tagStorageMock) Find(version string) (dockertag.Image, bool) {
+	if version != "23.3" {
+		return dockertag.Image{}, false
+	}
+
+	return dockertag.Image{Repository: "clickhouse/clickhouse-server", Tag: version, Digest: testDigest}, true
+}
+
+// executorMock emulates the database in pods: the readiness probe succeeds, queries are handled by query.
+type executorMock struct {
+	lock     sync.Mutex
+	commands [][]string
+
+	query func(pod string, args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+func (e *executorMock) exec(_ context.Context, _ string, pod string, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	e.lock.Lock()
+	e.commands = append(e.commands, args)
+	e.lock.Unlock()
+
+	if slices.Equal(args, dbdriver.ClickHouse{}.ReadinessCommand()) {
+		return 0, nil
+	}
+
+	return e.query(pod, args, stdout, stderr), nil
+}
+
+// newTestRunner creates a runner with a fake clientset where created pods are running immediately.
+// Runner names must be unique, because metrics are registered per runner.
+func newTestRunner(t *testing.T, name string, exec *executorMock) (*Runner, *fake.Clientset) {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		if pod.Status.Phase == "" {
+			pod.Status.Phase = corev1.PodRunning
+		}
+
+		return false, nil, nil
+	})
+
+	cfg := DefaultConfig
+	cfg.Namespace = "playground"
+	cfg.MaxWarmPods = 0
+	cfg.Readiness.Timeout = 5 * time.Second
+
+	runner := newRunner(context.Background(), zerolog.Nop(), name, cfg, tagStorageMock{}, client, exec)
+	t.Cleanup(func() {
+		require.NoError(t, runner.Stop(context.Background()))
+	})
+
+	return runner, client
+}
+
+func listPods(t *testing.T, client *fake.Clientset) []corev1.Pod {
+	pods, err := client.CoreV1().Pods("playground").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	return pods.Items
+}
+
+func TestRunner_RunQuery(t *testing.T) {
+	exec := &executorMock{
+		query: func(_ string, _ []string, stdout io.Writer, _ io.Writer) int {
+			_, _ = io.WriteString(stdout, "1\n")
+			return 0
+		},
+	}
+	runner, client := newTestRunner(t, "k8s-run-query", exec)
+
+	var created *corev1.Pod
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).DeepCopy()
+		return false, nil, nil
+	})
+
+	run := queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	output, err := runner.RunQuery(context.Background(), run)
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", output)
+	assert.Nil(t, run.QueryError)
+
+	require.NotNil(t, created)
+	assert.Equal(t, dockerengine.CreateContainerLabels("k8s-run-query", run.ID, "23.3"), created.Labels)
+
+	container := created.Spec.Containers[0]
+	assert.Equal(t, "clickhouse/clickhouse-server:23.3@"+testDigest, container.Image)
+	assert.True(t, resource.MustParse("2").Equal(container.Resources.Limits[corev1.ResourceCPU]))
+	assert.True(t, resource.MustParse("1G").Equal(container.Resources.Limits[corev1.ResourceMemory]))
+	assert.Equal(t, container.Resources.Limits, container.Resources.Requests)
+	assert.Equal(t, corev1.RestartPolicyNever, created.Spec.RestartPolicy)
+
+	// The query is executed after the readiness probe.
+	require.Len(t, exec.commands, 2)
+	assert.Contains(t, exec.commands[1], "--query")
+
+	assert.Eventually(t, func() bool {
+		return len(listPods(t, client)) == 0
+	}, time.Second, 10*time.Millisecond, "pod must be removed after the run")
+}
+
+func TestRunner_RunQueryError(t *testing.T) {
+	exec := &executorMock{
+		query: func(_ string, _ []string, _ io.Writer, stderr io.Writer) int {
+			_, _ = io.WriteString(stderr, "Code: 62. DB::Exception: Syntax error. (SYNTAX_ERROR)\n")
+			return 62
+		},
+	}
+	runner, _ := newTestRunner(t, "k8s-query-error", exec)
+
+	run := queryrun.New("SELEC 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	_, err := runner.RunQuery(context.Background(), run)
+	require.NoError(t, err)
+
+	require.NotNil(t, run.QueryError)
+	assert.Equal(t, "SYNTAX_ERROR", run.QueryError.Name)
+	assert.Equal(t, 62, run.QueryError.ExitCode)
+}
+
+func TestRunner_RunQueryOOMKilled(t *testing.T) {
+	var runner *Runner
+	exec := &executorMock{
+		query: func(pod string, _ []string, _ io.Writer, _ io.Writer) int {
+			p, err := runner.client.CoreV1().Pods("playground").Get(context.Background(), pod, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			p.Status.Phase = corev1.PodFailed
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: containerName,
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+				},
+			}}
+			_, err = runner.client.CoreV1().Pods("playground").UpdateStatus(context.Background(), p, metav1.UpdateOptions{})
+			require.NoError(t, err)
+
+			return 137
+		},
+	}
+	runner, _ = newTestRunner(t, "k8s-oom", exec)
+
+	run := queryrun.New("SELECT * FROM numbers(1e10) ORDER BY number", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	_, err := runner.RunQuery(context.Background(), run)
+	assert.ErrorIs(t, err, qrunner.ErrMemoryLimitExceeded)
+	assert.Equal(t, &queryrun.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}, run.Container)
+}
+
+func TestRunner_RunQueryUnsupported(t *testing.T) {
+	runner, client := newTestRunner(t, "k8s-unsupported", &executorMock{})
+
+	run := queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	run.Topology = &queryrun.Topology{Shards: 2, Replicas: 1}
+	_, err := runner.RunQuery(context.Background(), run)
+	assert.Error(t, err)
+
+	_, err = runner.RunQuery(context.Background(), queryrun.New("SELECT 1", "clickhouse", "1.1", &runsettings.ClickHouseSettings{}))
+	assert.Error(t, err)
+
+	assert.Empty(t, listPods(t, client))
+}
+
+func TestRunner_CancelOrphanedRun(t *testing.T) {
+	runner, client := newTestRunner(t, "k8s-cancel", &executorMock{})
+
+	_, err := client.CoreV1().Pods("playground").Create(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "chp-orphaned",
+			Labels: dockerengine.CreateContainerLabels("k8s-cancel", "run-1", "23.3"),
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = runner.CancelRun(context.Background(), "run-2")
+	assert.ErrorIs(t, err, qrunner.ErrRunNotFound)
+
+	_, err = runner.CancelRun(context.Background(), "run-1")
+	require.NoError(t, err)
+	assert.Empty(t, listPods(t, client))
+}
+
+func TestPrewarmer_Fetch(t *testing.T) {
+	runner, client := newTestRunner(t, "k8s-prewarm", &executorMock{})
+	runner.prewarmer.maxWarmPods = 1
+
+	image := "clickhouse/clickhouse-server:23.3@" + testDigest
+	require.NoError(t, runner.prewarmer.prewarm(&requestState{version: "23.3", image: image}))
+
+	pods := listPods(t, client)
+	require.Len(t, pods, 1)
+	assert.Equal(t, prewarmingRunID, pods[0].Labels[dockerengine.LabelRun])
+
+	_, found := runner.prewarmer.fetch(context.Background(), "clickhouse/clickhouse-server:22.8", "run-1")
+	assert.False(t, found)
+
+	name, found := runner.prewarmer.fetch(context.Background(), image, "run-1")
+	require.True(t, found)
+	assert.Equal(t, pods[0].Name, name)
+
+	// The pod is labeled with the run, so the run can be cancelled by the label.
+	pods = listPods(t, client)
+	require.Len(t, pods, 1)
+	assert.Equal(t, "run-1", pods[0].Labels[dockerengine.LabelRun])
+
+	_, found = runner.prewarmer.fetch(context.Background(), image, "run-2")
+	assert.False(t, found)
+}
+
+func TestGarbageCollector_CollectPods(t *testing.T) {
+	runner, client := newTestRunner(t, "k8s-gc", &executorMock{})
+
+	createPod := func(name string, runID string, age time.Duration, phase corev1.PodPhase) {
+		_, err := client.CoreV1().Pods("playground").Create(context.Background(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Labels:            dockerengine.CreateContainerLabels("k8s-gc", runID, "23.3"),
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	createPod("fresh", "run-1", time.Minute, corev1.PodRunning)
+	createPod("finished", "run-2", time.Minute, corev1.PodSucceeded)
+	createPod("orphaned", "run-3", time.Hour, corev1.PodRunning)
+	createPod("prewarmed", prewarmingRunID, time.Hour, corev1.PodRunning)
+	createPod("stale-prewarmed", prewarmingRunID, 2*PrewarmedPodsMaxTTL, corev1.PodRunning)
+
+	// Pods of other runners are not touched.
+	_, err := client.CoreV1().Pods("playground").Create(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "other",
+			Labels: dockerengine.CreateContainerLabels("other", "run-4", "23.3"),
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	count, err := runner.gc.collectPods()
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), count)
+
+	var names []string
+	for _, pod := range listPods(t, client) {
+		names = append(names, pod.Name)
+	}
+	assert.ElementsMatch(t, []string{"fresh", "prewarmed", "other"}, names)
+}
+
+func TestRunner_EnsureNetworkPolicy(t *testing.T) {
+	runner, client := newTestRunner(t, "k8s-netpol", &executorMock{})
+
+	// The second call updates the existing policy.
+	require.NoError(t, runner.ensureNetworkPolicy(context.Background()))
+	require.NoError(t, runner.ensureNetworkPolicy(context.Background()))
+
+	policies, err := client.NetworkingV1().NetworkPolicies("playground").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, policies.Items, 1)
+
+	policy := policies.Items[0]
+	assert.Equal(t, map[string]string{
+		dockerengine.LabelOwnership: "1",
+		dockerengine.LabelRunner:    "k8s-netpol",
+	}, policy.Spec.PodSelector.MatchLabels)
+	assert.ElementsMatch(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}, policy.Spec.PolicyTypes)
+	assert.Empty(t, policy.Spec.Ingress)
+	assert.Empty(t, policy.Spec.Egress)
+}
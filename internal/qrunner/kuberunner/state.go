@@ -0,0 +1,31 @@
+package kuberunner
+
+import (
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+)
+
+// requestState holds information about a processing query execution request.
+type requestState struct {
+	runID string
+
+	database string
+	version  string
+	query    string
+
+	driver dbdriver.Driver
+
+	// local is set if the query is executed without a server.
+	local dbdriver.LocalDriver
+
+	settings runsettings.RunSettings
+
+	// image reference pinned to the digest
+	image string
+
+	podName string
+
+	// containerState is set when the pod is inspected after a failed exec.
+	containerState *queryrun.ContainerState
+}
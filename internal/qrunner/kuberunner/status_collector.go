@@ -0,0 +1,75 @@
+package kuberunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type statusCollector struct {
+	ctx context.Context
+
+	logger zerolog.Logger
+
+	runner *Runner
+	metr   *metrics.RunnerStatusExporter
+
+	frequency time.Duration
+}
+
+func newStatusCollector(ctx context.Context, logger zerolog.Logger, collectFrequency time.Duration, runner *Runner, metr *metrics.RunnerStatusExporter) *statusCollector {
+	return &statusCollector{
+		ctx:       ctx,
+		logger:    logger,
+		runner:    runner,
+		metr:      metr,
+		frequency: collectFrequency,
+	}
+}
+
+func (s *statusCollector) start() {
+	s.logger.Info().Dur("trigger_frequency", s.frequency).Msg("status collector has been started")
+	defer s.logger.Info().Msg("status collector has been finished")
+
+	collect := func() {
+		err := s.collect()
+		if err != nil {
+			s.logger.Err(err).Msg("failed to collect runner status")
+		}
+	}
+
+	collect()
+
+	t := time.NewTicker(s.frequency)
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+
+		case <-t.C:
+		}
+
+		collect()
+	}
+}
+
+// collect exports the number of pods created by the runner. Pods have no writable layer
+// the runner can measure, so the space consumption is not reported.
+func (s *statusCollector) collect() error {
+	pods, err := s.runner.client.CoreV1().Pods(s.runner.cfg.Namespace).List(s.ctx, metav1.ListOptions{
+		LabelSelector: ownedPodsSelector(s.runner.name),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list pods")
+	}
+
+	s.metr.UpdateContainerStatus(uint(len(pods.Items)), 0)
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package qrunner
+
+type OutputStream string
+
+const (
+	StreamStdout OutputStream = "stdout"
+	StreamStderr OutputStream = "stderr"
+)
+
+// OutputChunk is a part of the query output received while the query is being executed.
+type OutputChunk struct {
+	Stream OutputStream
+	Data   string
+}
+
+// OutputHandler is called for every received output chunk.
+// Chunks are passed sequentially, and the handler must not block for a long time.
+type OutputHandler = func(chunk OutputChunk)
@@ -0,0 +1,190 @@
+package remoteagent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/agentapi"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+type ImageStorage interface {
+	Find(version string) (dockertag.Image, bool)
+}
+
+type Config struct {
+	// URL of the agent, e.g. https://worker-1:9090.
+	URL string
+
+	// Token authenticates requests to the agent.
+	Token string
+
+	// CACertPath is a path to PEM certificates the agent certificate is verified with.
+	// If empty, the system certificate pool is used.
+	CACertPath string
+}
+
+// Runner executes queries on a playground agent.
+//
+// The agent wraps a Docker engine runner on a worker host, so the Docker API traffic
+// (pulls, container starts and execs) stays local to the worker. Only runs and their results
+// are sent over the network.
+type Runner struct {
+	logger zerolog.Logger
+
+	name string
+
+	client     *agentapi.Client
+	tagStorage ImageStorage
+
+	// features are reported by the agent with its status.
+	features uint32
+}
+
+// New creates a runner. If httpCli is passed, it's used instead of the client built from the config.
+func New(logger zerolog.Logger, name string, cfg Config, tagStorage ImageStorage, httpCli ...*http.Client) (*Runner, error) {
+	if len(httpCli) == 0 && cfg.CACertPath != "" {
+		cli, err := newTLSClient(cfg.CACertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create TLS client")
+		}
+
+		httpCli = append(httpCli, cli)
+	}
+
+	return &Runner{
+		logger:     logger.With().Str("runner", name).Logger(),
+		name:       name,
+		client:     agentapi.NewClient(cfg.URL, cfg.Token, httpCli...),
+		tagStorage: tagStorage,
+
+		// Until the agent reports its features, runs wait for the agent instead of being rejected.
+		features: uint32(qrunner.AllFeatures),
+	}, nil
+}
+
+// newTLSClient returns an HTTP client that verifies certificates of agents with the given CA certificates.
+func newTLSClient(caCertPath string) (*http.Client, error) {
+	pem, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CA certificates")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no valid CA certificates found")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (r *Runner) Type() qrunner.Type {
+	return qrunner.TypeRemoteAgent
+}
+
+func (r *Runner) Name() string {
+	return r.name
+}
+
+// Features returns the run features the agent reported with the last status.
+func (r *Runner) Features() qrunner.Features {
+	return qrunner.Features(atomic.LoadUint32(&r.features))
+}
+
+// Status reports the agent runner status. The agent is not alive if it doesn't respond.
+// Supported run features are updated from the status.
+func (r *Runner) Status(ctx context.Context) qrunner.RunnerStatus {
+	status, err := r.client.Status(ctx)
+	if err != nil {
+		return qrunner.RunnerStatus{
+			Alive:            false,
+			LivenessProbeErr: err,
+		}
+	}
+
+	atomic.StoreUint32(&r.features, uint32(status.Features))
+
+	var probeErr error
+	if status.Error != "" {
+		probeErr = errors.New(status.Error)
+	}
+
+	return qrunner.RunnerStatus{
+		Alive:            status.Alive,
+		LivenessProbeErr: probeErr,
+	}
+}
+
+func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (string, error) {
+	return r.RunQueryStream(ctx, run, nil)
+}
+
+// RunQueryStream sends the run to the agent and passes the output to onOutput as soon as the agent streams it.
+// Results filled by the agent runner are copied to the run.
+func (r *Runner) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+	img, found := r.tagStorage.Find(run.Version)
+	if !found {
+		return "", errors.New("version not found")
+	}
+
+	req := &agentapi.RunQueryRequest{
+		Run:   run,
+		Image: img,
+	}
+	for _, f := range run.Files {
+		req.Files = append(req.Files, agentapi.File{Name: f.Name, Content: f.Content})
+	}
+
+	result, err := r.client.RunQuery(ctx, req, onOutput)
+	if err != nil {
+		return "", errors.Wrap(err, "agent request failed")
+	}
+
+	if result.Run != nil {
+		run.Statements = result.Run.Statements
+		run.QueryError = result.Run.QueryError
+		run.ResultSet = result.Run.ResultSet
+		run.Container = result.Run.Container
+	}
+
+	return result.Output, result.Error.Err()
+}
+
+// CancelRun stops the run on the agent.
+func (r *Runner) CancelRun(ctx context.Context, runID string) (string, error) {
+	output, err := r.client.StopRun(ctx, runID)
+	if err != nil {
+		return "", err
+	}
+
+	r.logger.Info().Str("run_id", runID).Msg("run has been cancelled on the agent")
+
+	return output, nil
+}
+
+// Start does nothing, background tasks are run by the agent.
+func (r *Runner) Start() error {
+	r.logger.Info().Msg("runner has been started")
+	return nil
+}
+
+// Stop does nothing, the agent keeps running and removes containers of interrupted runs itself.
+func (r *Runner) Stop(_ context.Context) error {
+	r.logger.Info().Msg("runner has been stopped")
+	return nil
+}
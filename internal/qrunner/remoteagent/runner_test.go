@@ -0,0 +1,195 @@
+package remoteagent
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/agentapi"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+
+var testImage = dockertag.Image{
+	Repository: "clickhouse/clickhouse-server",
+	Tag:        "23.3",
+	Digest:     "sha256:edfee043e4f909dd471c6e282ce3cfd0ce90a4cad3fc234cb27633debe26ea05",
+}
+
+type tagStorageMock struct{}
+
+func (tagStorageMock) Find(version string) (dockertag.Image, bool) {
+	return testImage, version == testImage.Tag
+}
+
+// newTestAgent starts an agent with a stub runner and returns the remote runner connected to it.
+func newTestAgent(t *testing.T, token string, run stubrunner.Run) *Runner {
+	srv := httptest.NewServer(agentapi.NewHandler(agentapi.HandlerOpts{
+		Logger: zerolog.Nop(),
+		Runner: stubrunner.New(context.Background(), "agent", run),
+		Token:  testToken,
+	}))
+	t.Cleanup(srv.Close)
+
+	runner, err := New(zerolog.Nop(), "remote", Config{URL: srv.URL, Token: token}, tagStorageMock{})
+	require.NoError(t, err)
+
+	return runner
+}
+
+func TestRunner_RunQuery(t *testing.T) {
+	runner := newTestAgent(t, testToken, func(ctx context.Context, run *queryrun.Run) (string, error) {
+		img, found := qrunner.ImageFromContext(ctx)
+		require.True(t, found)
+		assert.Equal(t, testImage, img)
+
+		require.IsType(t, &runsettings.ClickHouseSettings{}, run.Settings)
+		require.Len(t, run.Files, 1)
+		assert.Equal(t, "1\t2\n", string(run.Files[0].Content))
+
+		run.QueryError = &queryrun.QueryError{Code: 62, Name: "SYNTAX_ERROR"}
+		run.Statements = []queryrun.StatementResult{{Statement: "SELECT 1", Output: "1\n"}}
+
+		return "1\n", nil
+	})
+
+	assert.True(t, runner.Status(context.Background()).Alive)
+
+	run := queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	run.Files = []queryrun.InputFile{queryrun.NewInputFile("data.tsv", []byte("1\t2\n"))}
+
+	var chunks []qrunner.OutputChunk
+	output, err := runner.RunQueryStream(context.Background(), run, func(chunk qrunner.OutputChunk) {
+		chunks = append(chunks, chunk)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", output)
+	assert.Equal(t, []qrunner.OutputChunk{{Stream: qrunner.StreamStdout, Data: "1\n"}}, chunks)
+
+	assert.Equal(t, &queryrun.QueryError{Code: 62, Name: "SYNTAX_ERROR"}, run.QueryError)
+	require.Len(t, run.Statements, 1)
+	assert.Equal(t, "1\n", run.Statements[0].Output)
+
+	_, err = runner.RunQuery(context.Background(), queryrun.New("SELECT 1", "clickhouse", "1.1", &runsettings.ClickHouseSettings{}))
+	assert.Error(t, err)
+}
+
+func TestRunner_RunQueryError(t *testing.T) {
+	runner := newTestAgent(t, testToken, func(_ context.Context, _ *queryrun.Run) (string, error) {
+		return "", qrunner.ErrMemoryLimitExceeded
+	})
+
+	_, err := runner.RunQuery(context.Background(), queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	assert.ErrorIs(t, err, qrunner.ErrMemoryLimitExceeded)
+}
+
+func TestRunner_CancelRun(t *testing.T) {
+	started := make(chan struct{})
+	runner := newTestAgent(t, testToken, func(ctx context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	})
+
+	_, err := runner.CancelRun(context.Background(), "unknown")
+	assert.ErrorIs(t, err, qrunner.ErrRunNotFound)
+
+	run := queryrun.New("SELECT sleep(3)", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := runner.RunQuery(context.Background(), run)
+		errs <- err
+	}()
+
+	<-started
+	_, err = runner.CancelRun(context.Background(), run.ID)
+	require.NoError(t, err)
+
+	select {
+	case err = <-errs:
+		assert.ErrorIs(t, err, qrunner.ErrRunCancelled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run has not been cancelled")
+	}
+}
+
+// limitedRunner supports only the given run features.
+type limitedRunner struct {
+	*stubrunner.Runner
+	features qrunner.Features
+}
+
+func (r *limitedRunner) Features() qrunner.Features {
+	return r.features
+}
+
+func TestRunner_Features(t *testing.T) {
+	srv := httptest.NewServer(agentapi.NewHandler(agentapi.HandlerOpts{
+		Logger: zerolog.Nop(),
+		Runner: &limitedRunner{
+			Runner:   stubrunner.New(context.Background(), "agent", stubrunner.StubRun),
+			features: qrunner.FeatureFiles,
+		},
+		Token: testToken,
+	}))
+	t.Cleanup(srv.Close)
+
+	runner, err := New(zerolog.Nop(), "remote", Config{URL: srv.URL, Token: testToken}, tagStorageMock{})
+	require.NoError(t, err)
+
+	// All features are assumed until the agent reports them.
+	assert.Equal(t, qrunner.AllFeatures, runner.Features())
+
+	require.True(t, runner.Status(context.Background()).Alive)
+	assert.Equal(t, qrunner.FeatureFiles, runner.Features())
+}
+
+func TestRunner_Unauthorized(t *testing.T) {
+	runner := newTestAgent(t, "invalid", stubrunner.StubRun)
+
+	status := runner.Status(context.Background())
+	assert.False(t, status.Alive)
+	assert.Error(t, status.LivenessProbeErr)
+
+	_, err := runner.RunQuery(context.Background(), queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}))
+	assert.Error(t, err)
+}
+
+func TestRunner_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(agentapi.NewHandler(agentapi.HandlerOpts{
+		Logger: zerolog.Nop(),
+		Runner: stubrunner.New(context.Background(), "agent", stubrunner.StubRun),
+		Token:  testToken,
+	}))
+	t.Cleanup(srv.Close)
+
+	// The agent certificate is not trusted without the CA certificate.
+	untrusted, err := New(zerolog.Nop(), "untrusted", Config{URL: srv.URL, Token: testToken}, tagStorageMock{})
+	require.NoError(t, err)
+	assert.False(t, untrusted.Status(context.Background()).Alive)
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caPath, caPEM, 0o600))
+
+	trusted, err := New(zerolog.Nop(), "trusted", Config{URL: srv.URL, Token: testToken, CACertPath: caPath}, tagStorageMock{})
+	require.NoError(t, err)
+	assert.True(t, trusted.Status(context.Background()).Alive)
+
+	_, err = New(zerolog.Nop(), "missing", Config{URL: srv.URL, Token: testToken, CACertPath: filepath.Join(t.TempDir(), "missing.crt")}, tagStorageMock{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,16 @@
+package resultcache
+
+import "time"
+
+type Config struct {
+	// MaxEntries is the maximum number of cached results, the least recently used ones are evicted.
+	MaxEntries int
+
+	// TTL defines how long a result is cached.
+	TTL time.Duration
+}
+
+var DefaultConfig = Config{
+	MaxEntries: 1000,
+	TTL:        10 * time.Minute,
+}
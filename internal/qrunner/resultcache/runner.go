@@ -0,0 +1,156 @@
+package resultcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/metrics"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
+	"github.com/lodthe/clickhouse-playground/pkg/lrucache"
+
+	"github.com/rs/zerolog"
+)
+
+type QueryRunner interface {
+	RunQuery(ctx context.Context, run *queryrun.Run) (string, error)
+	RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error)
+	CancelRun(ctx context.Context, runID string) (string, error)
+}
+
+type ImageStorage interface {
+	Find(tag string) (dockertag.Image, bool)
+}
+
+type entry struct {
+	output     string
+	statements []queryrun.StatementResult
+	queryError *queryrun.QueryError
+	resultSet  *queryrun.ResultSet
+}
+
+// Runner caches results of successful runs and returns them for identical runs
+// without executing queries. Other runs are passed to the underlying runner.
+//
+// Runs are identical if they have the same database, image digest, normalized query and settings.
+// Queries whose results differ between executions, e.g. calling now() or rand(), are not cached.
+// The digest is used instead of the version, so the cache is not stale when a tag is moved to another image.
+type Runner struct {
+	logger     zerolog.Logger
+	underlying QueryRunner
+	images     ImageStorage
+
+	cache   *lrucache.Cache[string, entry]
+	metrics *metrics.ResultCacheExporter
+}
+
+func New(logger zerolog.Logger, underlying QueryRunner, images ImageStorage, cfg Config) *Runner {
+	return &Runner{
+		logger:     logger.With().Str("runner", "result_cache").Logger(),
+		underlying: underlying,
+		images:     images,
+		cache:      lrucache.New[string, entry](cfg.MaxEntries, cfg.TTL),
+		metrics:    metrics.NewResultCacheExporter(),
+	}
+}
+
+// Released passes availability notifications of the underlying runner.
+func (r *Runner) Released() <-chan struct{} {
+	notifier, ok := r.underlying.(qrunner.AvailabilityNotifier)
+	if !ok {
+		return nil
+	}
+
+	return notifier.Released()
+}
+
+func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (string, error) {
+	return r.RunQueryStream(ctx, run, nil)
+}
+
+// RunQueryStream returns the cached output if there is one, the output is passed to onOutput as a single chunk.
+// Otherwise, the query is executed by the underlying runner and its output is cached if the run succeeds.
+func (r *Runner) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+	key, cacheable := r.key(run)
+	if cacheable {
+		cached, found := r.cache.Get(key)
+		if found {
+			r.metrics.Hit()
+			r.logger.Debug().Str("run_id", run.ID).Msg("result cache hit")
+
+			run.CacheHit = true
+			run.Statements = cached.statements
+			run.QueryError = cached.queryError
+			run.ResultSet = cached.resultSet
+			if onOutput != nil && cached.output != "" {
+				onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: cached.output})
+			}
+
+			return cached.output, nil
+		}
+
+		r.metrics.Miss()
+	}
+
+	var output string
+	var err error
+	if onOutput != nil {
+		output, err = r.underlying.RunQueryStream(ctx, run, onOutput)
+	} else {
+		output, err = r.underlying.RunQuery(ctx, run)
+	}
+	if err != nil || !cacheable {
+		return output, err
+	}
+
+	r.cache.Set(key, entry{
+		output:     output,
+		statements: run.Statements,
+		queryError: run.QueryError,
+		resultSet:  run.ResultSet,
+	})
+
+	return output, nil
+}
+
+func (r *Runner) CancelRun(ctx context.Context, runID string) (string, error) {
+	return r.underlying.CancelRun(ctx, runID)
+}
+
+// key calculates the cache key of the run.
+// The run cannot be cached if its image is unknown or results of the query may differ between executions.
+func (r *Runner) key(run *queryrun.Run) (string, bool) {
+	if chspec.IsNondeterministic(run.Input) {
+		return "", false
+	}
+
+	img, found := r.images.Find(run.Version)
+	if !found || img.Digest == "" {
+		return "", false
+	}
+
+	// Files are identified by their checksums, contents are not marshaled.
+	params, err := json.Marshal(struct {
+		Settings any
+		Datasets []queryrun.DatasetRef
+		Files    []queryrun.InputFile
+		Topology *queryrun.Topology
+		Mode     queryrun.Mode
+	}{run.Settings, run.Datasets, run.Files, run.Topology, run.Mode})
+	if err != nil {
+		r.logger.Error().Err(err).Str("run_id", run.ID).Msg("failed to marshal run parameters")
+		return "", false
+	}
+
+	h := sha256.New()
+	for _, part := range []string{run.Database, img.Digest, chspec.NormalizeQuery(run.Input), string(params)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
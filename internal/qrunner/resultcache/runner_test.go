@@ -0,0 +1,166 @@
+package resultcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type imageStorageMock map[string]dockertag.Image
+
+func (m imageStorageMock) Find(tag string) (dockertag.Image, bool) {
+	img, found := m[tag]
+	return img, found
+}
+
+func newTestRunner(t *testing.T, run stubrunner.Run) (*Runner, *int32) {
+	t.Helper()
+
+	var calls int32
+	stub := stubrunner.New(context.Background(), "stub", func(ctx context.Context, r *queryrun.Run) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return run(ctx, r)
+	})
+
+	images := imageStorageMock{
+		"latest":   {Tag: "latest", Digest: "sha256:aaa"},
+		"head":     {Tag: "head", Digest: "sha256:aaa"},
+		"22.1":     {Tag: "22.1", Digest: "sha256:bbb"},
+		"nodigest": {Tag: "nodigest"},
+	}
+
+	return New(zerolog.Nop(), stub, images, DefaultConfig), &calls
+}
+
+func echoRun(_ context.Context, run *queryrun.Run) (string, error) {
+	return "output: " + run.Input, nil
+}
+
+func newRun(query, version, format string) *queryrun.Run {
+	return queryrun.New(query, "clickhouse", version, &runsettings.ClickHouseSettings{OutputFormat: format})
+}
+
+func TestRunner_CachesIdenticalRuns(t *testing.T) {
+	r, calls := newTestRunner(t, echoRun)
+
+	first := newRun("SELECT 1", "latest", "")
+	output, err := r.RunQuery(context.Background(), first)
+	require.NoError(t, err)
+	assert.Equal(t, "output: SELECT 1", output)
+	assert.False(t, first.CacheHit)
+
+	// The same image digest and the query that differs only in formatting.
+	second := newRun("  SELECT 1;\n", "head", "")
+	output, err = r.RunQuery(context.Background(), second)
+	require.NoError(t, err)
+	assert.Equal(t, "output: SELECT 1", output)
+	assert.True(t, second.CacheHit)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
+
+func TestRunner_DifferentRuns(t *testing.T) {
+	r, calls := newTestRunner(t, echoRun)
+
+	runs := []*queryrun.Run{
+		newRun("SELECT 1", "latest", ""),
+		newRun("SELECT 2", "latest", ""),
+		newRun("SELECT 1", "22.1", ""),
+		newRun("SELECT 1", "latest", "JSON"),
+	}
+
+	withDataset := newRun("SELECT 1", "latest", "")
+	withDataset.Datasets = []queryrun.DatasetRef{{Name: "hits", Version: "1"}}
+	withFile := newRun("SELECT 1", "latest", "")
+	withFile.Files = []queryrun.InputFile{queryrun.NewInputFile("data.csv", []byte("1\n"))}
+	withTopology := newRun("SELECT 1", "latest", "")
+	withTopology.Topology = &queryrun.Topology{Shards: 2, Replicas: 1}
+	runs = append(runs, withDataset, withFile, withTopology)
+
+	for _, run := range runs {
+		_, err := r.RunQuery(context.Background(), run)
+		require.NoError(t, err)
+		assert.False(t, run.CacheHit)
+	}
+
+	assert.EqualValues(t, len(runs), atomic.LoadInt32(calls))
+}
+
+func TestRunner_UnknownDigestIsNotCached(t *testing.T) {
+	r, calls := newTestRunner(t, echoRun)
+
+	for i := 0; i < 2; i++ {
+		run := newRun("SELECT 1", "nodigest", "")
+		_, err := r.RunQuery(context.Background(), run)
+		require.NoError(t, err)
+		assert.False(t, run.CacheHit)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
+
+func TestRunner_NondeterministicQueriesAreNotCached(t *testing.T) {
+	queries := []string{
+		"SELECT now()",
+		"SELECT rand()",
+		"SELECT * FROM generateRandom('x UInt8') LIMIT 1",
+		"SELECT count() FROM system.tables",
+		"SELECT sleep(1)",
+	}
+
+	for _, query := range queries {
+		r, calls := newTestRunner(t, echoRun)
+
+		for i := 0; i < 2; i++ {
+			run := newRun(query, "latest", "")
+			_, err := r.RunQuery(context.Background(), run)
+			require.NoError(t, err)
+			assert.False(t, run.CacheHit, query)
+		}
+
+		assert.EqualValues(t, 2, atomic.LoadInt32(calls), query)
+	}
+}
+
+func TestRunner_FailedRunsAreNotCached(t *testing.T) {
+	r, calls := newTestRunner(t, func(ctx context.Context, run *queryrun.Run) (string, error) {
+		return "", errors.New("runner failed")
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := r.RunQuery(context.Background(), newRun("SELECT 1", "latest", ""))
+		require.Error(t, err)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
+
+func TestRunner_StreamCacheHit(t *testing.T) {
+	r, calls := newTestRunner(t, echoRun)
+
+	_, err := r.RunQueryStream(context.Background(), newRun("SELECT 1", "latest", ""), func(qrunner.OutputChunk) {})
+	require.NoError(t, err)
+
+	var chunks []qrunner.OutputChunk
+	run := newRun("SELECT 1", "latest", "")
+	output, err := r.RunQueryStream(context.Background(), run, func(chunk qrunner.OutputChunk) {
+		chunks = append(chunks, chunk)
+	})
+	require.NoError(t, err)
+
+	assert.True(t, run.CacheHit)
+	assert.Equal(t, "output: SELECT 1", output)
+	assert.Equal(t, []qrunner.OutputChunk{{Stream: qrunner.StreamStdout, Data: output}}, chunks)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
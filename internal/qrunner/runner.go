@@ -14,6 +14,14 @@ type Runner interface {
 
 	RunQuery(ctx context.Context, run *queryrun.Run) (string, error)
 
+	// RunQueryStream does the same as RunQuery, but also passes the output to onOutput
+	// as soon as it's received from the database.
+	RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput OutputHandler) (string, error)
+
+	// CancelRun stops the execution of the run and returns the output received so far.
+	// ErrRunNotFound is returned if the run is not being executed by the runner.
+	CancelRun(ctx context.Context, runID string) (string, error)
+
 	// Start initializes background processes (like garbage collection and status exporter).
 	// This function is non-blocking.
 	Start() error
@@ -21,3 +29,16 @@ type Runner interface {
 	// Stop stops background tasks and waits for their finish.
 	Stop(shutdownCtx context.Context) error
 }
+
+// IdleResourceReleaser is implemented by runners that reserve resources for future runs, e.g. prewarmed containers.
+type IdleResourceReleaser interface {
+	// ReleaseIdleResources releases resources reserved for future runs. Runs being executed are not affected.
+	ReleaseIdleResources(ctx context.Context)
+}
+
+// AvailabilityNotifier is implemented by runners that reject runs with ErrNoAvailableRunners when they are busy.
+type AvailabilityNotifier interface {
+	// Released returns a channel that is closed when capacity for a new run may have been released.
+	// A nil channel means that no notifications are sent.
+	Released() <-chan struct{}
+}
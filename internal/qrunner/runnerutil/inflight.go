@@ -0,0 +1,85 @@
+// Package runnerutil contains building blocks shared by runners that start databases in containers.
+package runnerutil
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+)
+
+// InflightRun is a run that is being executed by the runner.
+// It keeps the output received so far to return it when the run is cancelled.
+type InflightRun struct {
+	cancel    context.CancelFunc
+	cancelled int32
+
+	lock   sync.Mutex
+	output strings.Builder
+}
+
+// NewInflightRun creates a run that is interrupted with cancel.
+func NewInflightRun(cancel context.CancelFunc) *InflightRun {
+	return &InflightRun{cancel: cancel}
+}
+
+func (r *InflightRun) AppendOutput(chunk qrunner.OutputChunk) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.output.WriteString(chunk.Data)
+}
+
+func (r *InflightRun) PartialOutput() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.output.String()
+}
+
+// MarkCancelled interrupts the run execution.
+func (r *InflightRun) MarkCancelled() {
+	atomic.StoreInt32(&r.cancelled, 1)
+	r.cancel()
+}
+
+func (r *InflightRun) IsCancelled() bool {
+	return atomic.LoadInt32(&r.cancelled) == 1
+}
+
+// InflightRuns is a set of runs that are being executed by the runner.
+type InflightRuns struct {
+	lock sync.Mutex
+	runs map[string]*InflightRun
+}
+
+func NewInflightRuns() *InflightRuns {
+	return &InflightRuns{
+		runs: make(map[string]*InflightRun),
+	}
+}
+
+func (s *InflightRuns) Add(runID string, run *InflightRun) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.runs[runID] = run
+}
+
+func (s *InflightRuns) Remove(runID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.runs, runID)
+}
+
+func (s *InflightRuns) Get(runID string) (*InflightRun, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run, found := s.runs[runID]
+
+	return run, found
+}
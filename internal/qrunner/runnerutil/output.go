@@ -0,0 +1,37 @@
+package runnerutil
+
+import (
+	"bytes"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+)
+
+// OutputWriter collects the output of a stream and passes every written chunk to the handler.
+type OutputWriter struct {
+	buf bytes.Buffer
+
+	stream  qrunner.OutputStream
+	handler qrunner.OutputHandler
+}
+
+func NewOutputWriter(stream qrunner.OutputStream, handler qrunner.OutputHandler) *OutputWriter {
+	return &OutputWriter{
+		stream:  stream,
+		handler: handler,
+	}
+}
+
+func (w *OutputWriter) Write(p []byte) (int, error) {
+	if w.handler != nil && len(p) > 0 {
+		w.handler(qrunner.OutputChunk{
+			Stream: w.stream,
+			Data:   string(p),
+		})
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *OutputWriter) String() string {
+	return w.buf.String()
+}
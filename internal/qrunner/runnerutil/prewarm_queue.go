@@ -0,0 +1,98 @@
+package runnerutil
+
+import (
+	"context"
+	"sync"
+)
+
+// PrewarmQueue keeps the latest requests of images that should be prewarmed.
+// Requests of images that are already queued are skipped, the oldest requests are dropped
+// if the queue is full. Requests are processed one by one by the worker started with Run.
+type PrewarmQueue[T any] struct {
+	key     func(T) string
+	maxSize int
+
+	lock     sync.Mutex
+	requests []T
+	signals  chan struct{}
+}
+
+// NewPrewarmQueue creates a queue of at most maxSize requests, key returns the image of a request.
+func NewPrewarmQueue[T any](maxSize uint, key func(T) string) *PrewarmQueue[T] {
+	return &PrewarmQueue[T]{
+		key:     key,
+		maxSize: int(maxSize),
+		signals: make(chan struct{}, 1),
+	}
+}
+
+// Push remembers the request and signals the worker.
+func (q *PrewarmQueue[T]) Push(request T) {
+	if q.maxSize == 0 {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, r := range q.requests {
+		if q.key(r) == q.key(request) {
+			return
+		}
+	}
+
+	q.requests = append(q.requests, request)
+	if len(q.requests) > q.maxSize {
+		q.requests = q.requests[1:]
+	}
+
+	q.notify()
+}
+
+// Run passes requests to process until the context is done.
+func (q *PrewarmQueue[T]) Run(ctx context.Context, process func(request T)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-q.signals:
+		}
+
+		req, count := q.pop()
+		if count == 0 {
+			continue
+		}
+
+		process(req)
+
+		// Images are processed one by one, the rest is processed in the next iterations,
+		// so the latest requests are taken into account.
+		if count > 1 {
+			q.notify()
+		}
+	}
+}
+
+func (q *PrewarmQueue[T]) notify() {
+	select {
+	case q.signals <- struct{}{}:
+	default:
+	}
+}
+
+// pop extracts the next request and returns the number of requests that have been waiting in the queue.
+func (q *PrewarmQueue[T]) pop() (request T, count int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	count = len(q.requests)
+	if count == 0 {
+		return request, 0
+	}
+
+	request = q.requests[0]
+	q.requests = q.requests[1:]
+
+	return request, count
+}
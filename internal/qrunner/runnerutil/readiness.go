@@ -0,0 +1,43 @@
+package runnerutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadinessConfig configures readiness probes. A failed probe is retried after a delay
+// that starts from InitialDelay and is doubled after each attempt up to MaxDelay.
+type ReadinessConfig struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+
+	// Timeout limits the total time of probing, the run fails if the database isn't ready by then.
+	Timeout time.Duration
+}
+
+// Poll calls probe until it reports readiness, probes are retried with exponential backoff.
+// The timeout is applied by the caller, so several probes can share it.
+func Poll(ctx context.Context, cfg ReadinessConfig, probe func(ctx context.Context) (bool, error)) (attempts int, err error) {
+	delay := cfg.InitialDelay
+
+	for attempt := 1; ; attempt++ {
+		ready, err := probe(ctx)
+		if err != nil {
+			return attempt, errors.Wrap(err, "readiness probe failed")
+		}
+
+		if ready {
+			return attempt, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, errors.Wrapf(ctx.Err(), "database is not ready after %d probes", attempt)
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, cfg.MaxDelay)
+	}
+}
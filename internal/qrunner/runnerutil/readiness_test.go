@@ -0,0 +1,39 @@
+package runnerutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoll(t *testing.T) {
+	cfg := ReadinessConfig{
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     40 * time.Millisecond,
+	}
+
+	var probedAt []time.Time
+	attempts, err := Poll(context.Background(), cfg, func(_ context.Context) (bool, error) {
+		probedAt = append(probedAt, time.Now())
+		return len(probedAt) == 5, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, attempts)
+
+	// Delays are doubled up to the max delay.
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	for i, delay := range expected {
+		assert.GreaterOrEqual(t, probedAt[i+1].Sub(probedAt[i]), delay, "attempt %d", i+2)
+	}
+
+	// Probe errors are not retried.
+	attempts, err = Poll(context.Background(), cfg, func(_ context.Context) (bool, error) {
+		return false, errors.New("exec failed")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
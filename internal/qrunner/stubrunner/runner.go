@@ -2,6 +2,7 @@ package stubrunner
 
 import (
 	"context"
+	"sync"
 
 	"github.com/lodthe/clickhouse-playground/internal/qrunner"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
@@ -21,13 +22,17 @@ type Runner struct {
 	name string
 
 	run Run
+
+	lock     sync.Mutex
+	inflight map[string]context.CancelCauseFunc
 }
 
 func New(ctx context.Context, name string, run Run) *Runner {
 	return &Runner{
-		ctx:  ctx,
-		name: name,
-		run:  run,
+		ctx:      ctx,
+		name:     name,
+		run:      run,
+		inflight: make(map[string]context.CancelCauseFunc),
 	}
 }
 
@@ -54,5 +59,47 @@ func (r *Runner) Stop(_ context.Context) error {
 }
 
 func (r *Runner) RunQuery(ctx context.Context, run *queryrun.Run) (string, error) {
-	return r.run(ctx, run)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	r.lock.Lock()
+	r.inflight[run.ID] = cancel
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		delete(r.inflight, run.ID)
+		r.lock.Unlock()
+	}()
+
+	output, err := r.run(ctx, run)
+	if errors.Is(context.Cause(ctx), qrunner.ErrRunCancelled) {
+		return output, qrunner.ErrRunCancelled
+	}
+
+	return output, err
+}
+
+func (r *Runner) RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error) {
+	output, err := r.RunQuery(ctx, run)
+	if onOutput != nil && err == nil && output != "" {
+		onOutput(qrunner.OutputChunk{Stream: qrunner.StreamStdout, Data: output})
+	}
+
+	return output, err
+}
+
+// CancelRun cancels the context passed to the run function.
+func (r *Runner) CancelRun(_ context.Context, runID string) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cancel, found := r.inflight[runID]
+	if !found {
+		return "", qrunner.ErrRunNotFound
+	}
+
+	cancel(qrunner.ErrRunCancelled)
+
+	return "", nil
 }
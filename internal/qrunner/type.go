@@ -6,4 +6,6 @@ const (
 	TypeCoordinator  Type = "COORDINATOR"
 	TypeStub         Type = "STUB"
 	TypeDockerEngine Type = "DOCKER_ENGINE"
+	TypeKubernetes   Type = "KUBERNETES"
+	TypeRemoteAgent  Type = "REMOTE_AGENT"
 )
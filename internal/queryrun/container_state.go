@@ -0,0 +1,12 @@
+package queryrun
+
+// ContainerState is the state of the container the query has been executed in.
+// It's inspected only if the query execution has failed.
+type ContainerState struct {
+	// Status is the container status, e.g. running or exited.
+	Status   string `dynamodbav:"Status" json:"status"`
+	ExitCode int    `dynamodbav:"ExitCode" json:"exit_code"`
+
+	// OOMKilled is true if a process in the container has been killed because of the memory limit.
+	OOMKilled bool `dynamodbav:"OOMKilled" json:"oom_killed"`
+}
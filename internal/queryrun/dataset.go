@@ -0,0 +1,7 @@
+package queryrun
+
+// DatasetRef references a dataset loaded before the query.
+type DatasetRef struct {
+	Name    string `dynamodbav:"Name" json:"name"`
+	Version string `dynamodbav:"Version" json:"version"`
+}
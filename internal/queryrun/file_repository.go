@@ -0,0 +1,116 @@
+package queryrun
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileRepo is a Repository that stores every run as a JSON file in a local directory.
+// It's suitable for on-premise installations with a single coordinator.
+type FileRepo struct {
+	dir string
+}
+
+func NewFileRepository(dir string) (*FileRepo, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create runs directory")
+	}
+
+	return &FileRepo{
+		dir: dir,
+	}, nil
+}
+
+// path returns the location of the run file.
+// Runs are spread across subdirectories by the id prefix to keep directories small.
+func (r *FileRepo) path(id string) (string, bool) {
+	if len(id) < 2 || strings.ContainsAny(id, `/\.`) {
+		return "", false
+	}
+
+	return filepath.Join(r.dir, id[:2], id+".json"), true
+}
+
+func (r *FileRepo) Create(run *Run) error {
+	path, ok := r.path(run.ID)
+	if !ok {
+		return errors.Errorf("invalid run id %q", run.ID)
+	}
+
+	marshaled, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return errors.Wrap(err, "mkdir failed")
+	}
+
+	// Write to a temporary file and rename it to prevent partially written runs.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".run-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create a temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(marshaled)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write failed")
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "close failed")
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "rename failed")
+	}
+
+	return nil
+}
+
+func (r *FileRepo) Update(run *Run) error {
+	path, ok := r.path(run.ID)
+	if !ok {
+		return ErrNotFound
+	}
+
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return r.Create(run)
+}
+
+func (r *FileRepo) Get(id string) (*Run, error) {
+	path, ok := r.path(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read failed")
+	}
+
+	run := new(Run)
+	err = json.Unmarshal(data, run)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+
+	return run, nil
+}
@@ -0,0 +1,28 @@
+package queryrun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// InputFile is a file uploaded with the run, the query can read it with the file() table function.
+//
+// Only the metadata is saved, the content is kept in memory until the run is executed.
+type InputFile struct {
+	Name   string `dynamodbav:"Name" json:"name"`
+	Size   int    `dynamodbav:"Size" json:"size"`
+	SHA256 string `dynamodbav:"SHA256" json:"sha256"`
+
+	Content []byte `dynamodbav:"-" json:"-"`
+}
+
+func NewInputFile(name string, content []byte) InputFile {
+	sum := sha256.Sum256(content)
+
+	return InputFile{
+		Name:    name,
+		Size:    len(content),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Content: content,
+	}
+}
@@ -0,0 +1,44 @@
+package queryrun
+
+import (
+	"encoding/json"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+
+	"github.com/pkg/errors"
+)
+
+// newSettings returns an empty settings instance for the given database.
+// It is used to decode runs because settings are stored as an interface.
+func newSettings(database string) runsettings.RunSettings {
+	return runsettings.New(dbsettings.Type(database))
+}
+
+// UnmarshalJSON decodes a run picking the settings type by the run database.
+func (r *Run) UnmarshalJSON(data []byte) error {
+	type plain Run
+	aux := struct {
+		*plain
+		Settings json.RawMessage `json:"settings"`
+	}{
+		plain: (*plain)(r),
+	}
+
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+
+	r.Settings = newSettings(r.Database)
+	if r.Settings == nil || len(aux.Settings) == 0 || string(aux.Settings) == "null" {
+		return nil
+	}
+
+	err = json.Unmarshal(aux.Settings, r.Settings)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode settings")
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package queryrun
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/pkg/lrucache"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultMemoryMaxRuns = 10000
+
+// MemoryRepo is a Repository that keeps runs in the process memory.
+// It's intended for development and tests: runs are lost on restart.
+//
+// The number of stored runs is bounded, the least recently used runs are evicted first.
+// If ttl is set, runs older than ttl are evicted as well.
+type MemoryRepo struct {
+	runs *lrucache.Cache[string, []byte]
+}
+
+func NewMemoryRepository(maxRuns int, ttl time.Duration) *MemoryRepo {
+	if maxRuns <= 0 {
+		maxRuns = DefaultMemoryMaxRuns
+	}
+
+	return &MemoryRepo{
+		runs: lrucache.New[string, []byte](maxRuns, ttl),
+	}
+}
+
+func (r *MemoryRepo) Create(run *Run) error {
+	return r.set(run)
+}
+
+func (r *MemoryRepo) Update(run *Run) error {
+	_, found := r.runs.Get(run.ID)
+	if !found {
+		return ErrNotFound
+	}
+
+	return r.set(run)
+}
+
+func (r *MemoryRepo) Get(id string) (*Run, error) {
+	data, found := r.runs.Get(id)
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	run := new(Run)
+	err := json.Unmarshal(data, run)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+
+	return run, nil
+}
+
+// set stores the run encoded like the other repositories do, so stored runs don't share
+// slices and pointers with callers. Contents of input files are not encoded.
+func (r *MemoryRepo) set(run *Run) error {
+	marshaled, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	r.runs.Set(run.ID, marshaled)
+
+	return nil
+}
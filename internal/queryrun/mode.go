@@ -0,0 +1,12 @@
+package queryrun
+
+// Mode defines how the query is executed.
+type Mode string
+
+const (
+	// ModeServer executes the query on a database server. Runs saved before modes were introduced have an empty mode.
+	ModeServer Mode = "SERVER"
+
+	// ModeLocal executes the query with clickhouse local without starting a server.
+	ModeLocal Mode = "LOCAL"
+)
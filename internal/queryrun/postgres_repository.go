@@ -0,0 +1,111 @@
+package queryrun
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PostgresRepo is a Repository that stores runs in a PostgreSQL table.
+// A run is kept as a JSON document, so new run fields do not require schema migrations.
+type PostgresRepo struct {
+	ctx context.Context
+	db  *sql.DB
+
+	// Quoted table identifier.
+	table string
+}
+
+func NewPostgresRepository(ctx context.Context, db *sql.DB, tableName string) *PostgresRepo {
+	return &PostgresRepo{
+		ctx:   ctx,
+		db:    db,
+		table: quoteIdentifier(tableName),
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Migrate creates the runs table if it does not exist.
+func (r *PostgresRepo) Migrate() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id TEXT PRIMARY KEY,
+		data JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`, r.table)
+
+	_, err := r.db.ExecContext(r.ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "create table failed")
+	}
+
+	return nil
+}
+
+func (r *PostgresRepo) Create(run *Run) error {
+	marshaled, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (id, data, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, r.table) // nolint:gosec // the table name is quoted
+
+	_, err = r.db.ExecContext(r.ctx, query, run.ID, marshaled, run.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert failed")
+	}
+
+	return nil
+}
+
+func (r *PostgresRepo) Update(run *Run) error {
+	marshaled, err := json.Marshal(run)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET data = $2 WHERE id = $1`, r.table) // nolint:gosec // the table name is quoted
+
+	res, err := r.db.ExecContext(r.ctx, query, run.ID, marshaled)
+	if err != nil {
+		return errors.Wrap(err, "update failed")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to get affected rows")
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *PostgresRepo) Get(id string) (*Run, error) {
+	query := fmt.Sprintf(`SELECT data FROM %s WHERE id = $1`, r.table) // nolint:gosec // the table name is quoted
+
+	var data []byte
+	err := r.db.QueryRowContext(r.ctx, query, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "select failed")
+	}
+
+	run := new(Run)
+	err = json.Unmarshal(data, run)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal failed")
+	}
+
+	return run, nil
+}
@@ -0,0 +1,14 @@
+package queryrun
+
+// QueryError describes why the database has rejected the query.
+// Unlike FailureReason, it's a problem of the query, not of the playground.
+type QueryError struct {
+	// Code and Name are the error code reported by the database, they are empty if it's unknown.
+	Code int    `dynamodbav:"Code" json:"code,omitempty"`
+	Name string `dynamodbav:"Name" json:"name,omitempty"`
+
+	Message string `dynamodbav:"Message" json:"message"`
+
+	// ExitCode is the exit code of the database client.
+	ExitCode int `dynamodbav:"ExitCode" json:"exit_code"`
+}
@@ -3,9 +3,6 @@ package queryrun
 import (
 	"context"
 
-	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
-	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
-
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -18,8 +15,12 @@ var ErrNotFound = errors.New("not found")
 type Repository interface {
 	Create(run *Run) error
 	Get(id string) (*Run, error)
+
+	// Update replaces a previously created run.
+	Update(run *Run) error
 }
 
+// Repo is a Repository that stores runs in a DynamoDB table.
 type Repo struct {
 	ctx    context.Context
 	client *dynamodb.Client
@@ -36,14 +37,30 @@ func NewRepository(ctx context.Context, client *dynamodb.Client, tableName strin
 }
 
 func (r *Repo) Create(run *Run) error {
+	return r.put(run, nil)
+}
+
+func (r *Repo) Update(run *Run) error {
+	err := r.put(run, aws.String("attribute_exists(Id)"))
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (r *Repo) put(run *Run, condition *string) error {
 	marshaled, err := attributevalue.MarshalMap(run)
 	if err != nil {
 		return errors.Wrap(err, "marshal failed")
 	}
 
 	_, err = r.client.PutItem(r.ctx, &dynamodb.PutItemInput{
-		TableName: r.tableName,
-		Item:      marshaled,
+		TableName:           r.tableName,
+		Item:                marshaled,
+		ConditionExpression: condition,
 	})
 	if err != nil {
 		return errors.Wrap(err, "put failed")
@@ -66,12 +83,9 @@ func (r *Repo) Get(id string) (*Run, error) {
 	run := new(Run)
 
 	// Done because UnmarshalMap can't unmarshal in interface{}
-	var databaseType dbsettings.Type
-	_ = attributevalue.Unmarshal(out.Item["Database"], &databaseType)
-	// TODO: add switch-case in the future
-	if databaseType == dbsettings.TypeClickHouse {
-		run.Settings = &runsettings.ClickHouseSettings{}
-	}
+	var database string
+	_ = attributevalue.Unmarshal(out.Item["Database"], &database)
+	run.Settings = newSettings(database)
 
 	err = attributevalue.UnmarshalMap(out.Item, run)
 	if err != nil {
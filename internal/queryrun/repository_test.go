@@ -0,0 +1,193 @@
+package queryrun
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+
+	awsconf "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRepositoryConformance verifies the behavior every Repository implementation must provide.
+func testRepositoryConformance(t *testing.T, repo Repository) {
+	t.Run("create and get", func(t *testing.T) {
+		run := New("SELECT 1", "clickhouse", "22.8", &runsettings.ClickHouseSettings{OutputFormat: "JSON"})
+		run.Output = "1\n"
+		run.ExecutionTime = 1500 * time.Millisecond
+		run.Status = StatusSucceeded
+
+		require.NoError(t, repo.Create(run))
+
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, run.ID, found.ID)
+		assert.Equal(t, run.Version, found.Version)
+		assert.Equal(t, run.Input, found.Input)
+		assert.Equal(t, run.Output, found.Output)
+		assert.Equal(t, run.Database, found.Database)
+		assert.Equal(t, run.ExecutionTime, found.ExecutionTime)
+		assert.Equal(t, run.Status, found.Status)
+		assert.WithinDuration(t, run.CreatedAt, found.CreatedAt, time.Second)
+		assert.Equal(t, run.Settings, found.Settings)
+	})
+
+	t.Run("empty settings", func(t *testing.T) {
+		run := New("SELECT 2", "clickhouse", "head", &runsettings.ClickHouseSettings{})
+		require.NoError(t, repo.Create(run))
+
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, &runsettings.ClickHouseSettings{}, found.Settings)
+	})
+
+	t.Run("input files", func(t *testing.T) {
+		run := New("SELECT * FROM file('data.csv')", "clickhouse", "head", &runsettings.ClickHouseSettings{})
+		run.Files = []InputFile{NewInputFile("data.csv", []byte("1,2\n"))}
+		require.NoError(t, repo.Create(run))
+
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+		require.Len(t, found.Files, 1)
+		assert.Equal(t, "data.csv", found.Files[0].Name)
+		assert.Equal(t, 4, found.Files[0].Size)
+		assert.Equal(t, run.Files[0].SHA256, found.Files[0].SHA256)
+		assert.Empty(t, found.Files[0].Content)
+		assert.NotEmpty(t, run.Files[0].Content)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := repo.Get(uuid.New().String())
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		run := New("SELECT sleep(1)", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+		run.Status = StatusQueued
+		require.NoError(t, repo.Create(run))
+
+		run.Status = StatusSucceeded
+		run.Output = "0\n"
+		require.NoError(t, repo.Update(run))
+
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, StatusSucceeded, found.Status)
+		assert.Equal(t, "0\n", found.Output)
+	})
+
+	t.Run("stored runs are copies", func(t *testing.T) {
+		run := New("SELECT 1", "clickhouse", "head", &runsettings.ClickHouseSettings{})
+		run.Statements = []StatementResult{{Statement: "SELECT 1", Output: "1\n"}}
+		run.QueryError = &QueryError{Message: "original"}
+		require.NoError(t, repo.Create(run))
+
+		run.Statements[0].Output = "changed\n"
+		run.QueryError.Message = "changed"
+
+		found, err := repo.Get(run.ID)
+		require.NoError(t, err)
+		require.Len(t, found.Statements, 1)
+		assert.Equal(t, "1\n", found.Statements[0].Output)
+		assert.Equal(t, "original", found.QueryError.Message)
+
+		found.Statements[0].Output = "changed\n"
+
+		found, err = repo.Get(run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "1\n", found.Statements[0].Output)
+	})
+
+	t.Run("runs are isolated", func(t *testing.T) {
+		first := New("SELECT 'first'", "clickhouse", "21.8", &runsettings.ClickHouseSettings{})
+		second := New("SELECT 'second'", "clickhouse", "23.3", &runsettings.ClickHouseSettings{})
+
+		require.NoError(t, repo.Create(first))
+		require.NoError(t, repo.Create(second))
+
+		found, err := repo.Get(first.ID)
+		require.NoError(t, err)
+		assert.Equal(t, first.Input, found.Input)
+
+		found, err = repo.Get(second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, second.Input, found.Input)
+	})
+}
+
+func TestFileRepo(t *testing.T) {
+	repo, err := NewFileRepository(t.TempDir())
+	require.NoError(t, err)
+
+	testRepositoryConformance(t, repo)
+
+	_, err = repo.Get("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryRepo(t *testing.T) {
+	testRepositoryConformance(t, NewMemoryRepository(DefaultMemoryMaxRuns, time.Hour))
+}
+
+func TestMemoryRepo_Eviction(t *testing.T) {
+	repo := NewMemoryRepository(2, 0)
+
+	runs := make([]*Run, 3)
+	for i := range runs {
+		runs[i] = New("SELECT 1", "clickhouse", "head", &runsettings.ClickHouseSettings{})
+		require.NoError(t, repo.Create(runs[i]))
+	}
+
+	_, err := repo.Get(runs[0].ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	for _, run := range runs[1:] {
+		_, err := repo.Get(run.ID)
+		assert.NoError(t, err)
+	}
+}
+
+func TestPostgresRepo(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("Skipping a postgres test. Set POSTGRES_TEST_DSN to enable.")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	table := "query_runs_test_" + time.Now().Format("20060102150405")
+
+	repo := NewPostgresRepository(ctx, db, table)
+	require.NoError(t, repo.Migrate())
+	t.Cleanup(func() {
+		_, err := db.ExecContext(ctx, "DROP TABLE "+quoteIdentifier(table))
+		assert.NoError(t, err)
+	})
+
+	testRepositoryConformance(t, repo)
+}
+
+func TestDynamoDBRepo(t *testing.T) {
+	table := os.Getenv("DYNAMODB_TEST_TABLE")
+	if table == "" {
+		t.Skip("Skipping a dynamodb test. Set DYNAMODB_TEST_TABLE to enable.")
+	}
+
+	ctx := context.Background()
+	cfg, err := awsconf.LoadDefaultConfig(ctx)
+	require.NoError(t, err)
+
+	testRepositoryConformance(t, NewRepository(ctx, dynamodb.NewFromConfig(cfg), table))
+}
@@ -0,0 +1,27 @@
+package queryrun
+
+import "encoding/json"
+
+// ResultSet is a structured result of a statement.
+type ResultSet struct {
+	Columns []Column `dynamodbav:"Columns" json:"columns"`
+
+	// Rows is a JSON array of rows, every row is an array of typed values.
+	Rows json.RawMessage `dynamodbav:"Rows" json:"rows"`
+
+	Statistics Statistics `dynamodbav:"Statistics" json:"statistics"`
+}
+
+type Column struct {
+	Name string `dynamodbav:"Name" json:"name"`
+	Type string `dynamodbav:"Type" json:"type"`
+}
+
+type Statistics struct {
+	Rows      uint64 `dynamodbav:"Rows" json:"rows"`
+	RowsRead  uint64 `dynamodbav:"RowsRead" json:"rows_read"`
+	BytesRead uint64 `dynamodbav:"BytesRead" json:"bytes_read"`
+
+	// Elapsed is the execution time reported by the database in seconds.
+	Elapsed float64 `dynamodbav:"Elapsed" json:"elapsed"`
+}
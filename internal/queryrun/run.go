@@ -9,17 +9,55 @@ import (
 )
 
 type Run struct {
-	ID string `dynamodbav:"Id"`
+	ID string `dynamodbav:"Id" json:"id"`
 
-	Version string `dynamodbav:"Version"`
-	Input   string `dynamodbav:"Input"`
-	Output  string `dynamodbav:"Output"`
+	Version string `dynamodbav:"Version" json:"version"`
+	Input   string `dynamodbav:"Input" json:"input"`
+	Output  string `dynamodbav:"Output" json:"output"`
 
-	Database string                  `dynamodbav:"Database"`
-	Settings runsettings.RunSettings `dynamodbav:"Settings"`
+	// Statements contains results of executed statements in order.
+	// Statements following the failed one are not executed, so they are missed.
+	Statements []StatementResult `dynamodbav:"Statements" json:"statements,omitempty"`
 
-	CreatedAt     time.Time     `dynamodbav:"CreatedAt"`
-	ExecutionTime time.Duration `dynamodbav:"ExecutionTime"`
+	// QueryError is set if the query has been executed, but the database has returned an error.
+	QueryError *QueryError `dynamodbav:"QueryError" json:"query_error,omitempty"`
+
+	// Container is set if the query execution has failed, it helps to find out
+	// whether the database has been killed because of resource limits.
+	Container *ContainerState `dynamodbav:"Container" json:"container,omitempty"`
+
+	// ResultSet is the structured result of the last statement, it's set only if structured output is requested.
+	ResultSet *ResultSet `dynamodbav:"ResultSet" json:"result_set,omitempty"`
+
+	// GroupID links runs created by one request, e.g. runs of a comparison.
+	GroupID string `dynamodbav:"GroupId" json:"group_id,omitempty"`
+
+	Database string                  `dynamodbav:"Database" json:"database"`
+	Settings runsettings.RunSettings `dynamodbav:"Settings" json:"settings"`
+
+	// Datasets are loaded in order before the query is executed.
+	Datasets []DatasetRef `dynamodbav:"Datasets" json:"datasets,omitempty"`
+
+	// Files are copied to the database before the query is executed.
+	Files []InputFile `dynamodbav:"Files" json:"files,omitempty"`
+
+	// Mode is empty for runs executed on a server before modes were introduced.
+	Mode Mode `dynamodbav:"Mode" json:"mode,omitempty"`
+
+	// If Topology is set, the query is run against a cluster instead of a single server.
+	Topology *Topology `dynamodbav:"Topology" json:"topology,omitempty"`
+
+	// Runs saved before statuses were introduced have an empty status, they are succeeded.
+	Status Status `dynamodbav:"Status" json:"status"`
+
+	// FailureReason explains why the run has failed.
+	FailureReason string `dynamodbav:"FailureReason" json:"failure_reason,omitempty"`
+
+	// CacheHit is true if the output has been taken from the result cache instead of running the query.
+	CacheHit bool `dynamodbav:"CacheHit" json:"cache_hit,omitempty"`
+
+	CreatedAt     time.Time     `dynamodbav:"CreatedAt" json:"created_at"`
+	ExecutionTime time.Duration `dynamodbav:"ExecutionTime" json:"execution_time"`
 }
 
 func New(input string, database string, version string, settings runsettings.RunSettings) *Run {
@@ -32,3 +70,12 @@ func New(input string, database string, version string, settings runsettings.Run
 		Settings:  settings,
 	}
 }
+
+// CurrentStatus returns the run status taking into account runs saved without a status.
+func (r *Run) CurrentStatus() Status {
+	if r.Status == "" {
+		return StatusSucceeded
+	}
+
+	return r.Status
+}
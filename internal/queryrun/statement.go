@@ -0,0 +1,18 @@
+package queryrun
+
+import "time"
+
+// StatementResult is the result of one statement of a multi-statement query.
+type StatementResult struct {
+	Statement string `dynamodbav:"Statement" json:"statement"`
+	Output    string `dynamodbav:"Output" json:"output"`
+
+	// Error contains the database error if the statement has failed.
+	Error string `dynamodbav:"Error" json:"error,omitempty"`
+
+	ExecutionTime time.Duration `dynamodbav:"ExecutionTime" json:"execution_time"`
+
+	// RowsRead and BytesRead are reported only if the query is sent via the HTTP interface.
+	RowsRead  uint64 `dynamodbav:"RowsRead" json:"rows_read,omitempty"`
+	BytesRead uint64 `dynamodbav:"BytesRead" json:"bytes_read,omitempty"`
+}
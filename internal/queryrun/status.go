@@ -0,0 +1,26 @@
+package queryrun
+
+// Status describes the lifecycle stage of a run.
+//
+// Synchronous runs are saved when they are finished, so only final statuses are stored for them.
+// Asynchronous runs are saved as QUEUED and then move to RUNNING and one of the final statuses.
+type Status string
+
+const (
+	StatusQueued    Status = "QUEUED"
+	StatusRunning   Status = "RUNNING"
+	StatusSucceeded Status = "SUCCEEDED"
+	StatusFailed    Status = "FAILED"
+	StatusCancelled Status = "CANCELLED"
+)
+
+// IsFinal reports whether the run cannot change its status anymore.
+func (s Status) IsFinal() bool {
+	switch s {
+	case StatusSucceeded, StatusFailed, StatusCancelled:
+		return true
+
+	default:
+		return false
+	}
+}
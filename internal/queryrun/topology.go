@@ -0,0 +1,23 @@
+package queryrun
+
+// Topology describes a cluster the query is run against.
+// Every shard has the same number of replicas, the query is executed on the first replica of the first shard.
+type Topology struct {
+	Shards   int `dynamodbav:"Shards" json:"shards"`
+	Replicas int `dynamodbav:"Replicas" json:"replicas"`
+}
+
+// Nodes returns the number of database servers in the cluster.
+func (t Topology) Nodes() int {
+	return t.Shards * t.Replicas
+}
+
+// Exceeds reports whether the cluster has more than maxNodes servers.
+// Shards and replicas are checked separately first, so huge factors don't overflow the product.
+func (t Topology) Exceeds(maxNodes int) bool {
+	if t.Shards > maxNodes || t.Replicas > maxNodes {
+		return true
+	}
+
+	return t.Nodes() > maxNodes
+}
@@ -0,0 +1,36 @@
+// Package runnerconfig contains config sections shared by the playground and the agent.
+// Both binaries load them from YAML files and convert them to configs of runners.
+package runnerconfig
+
+import "github.com/lodthe/clickhouse-playground/internal/dataset"
+
+type Dataset struct {
+	Name    string `mapstructure:"name"`
+	Version string `mapstructure:"version"`
+	Path    string `mapstructure:"path"`
+	Format  string `mapstructure:"format"`
+	Schema  string `mapstructure:"schema"`
+	Table   string `mapstructure:"table"`
+}
+
+// Datasets converts dataset sections to datasets.
+func Datasets(sections []Dataset) []dataset.Dataset {
+	datasets := make([]dataset.Dataset, 0, len(sections))
+	for _, d := range sections {
+		datasets = append(datasets, dataset.Dataset{
+			Name:    d.Name,
+			Version: d.Version,
+			Path:    d.Path,
+			Format:  d.Format,
+			Schema:  d.Schema,
+			Table:   d.Table,
+		})
+	}
+
+	return datasets
+}
+
+type Clusters struct {
+	// MaxNodes limits the number of servers in a cluster requested by a user. If 0, clusters are disabled.
+	MaxNodes int `mapstructure:"max_nodes"`
+}
@@ -0,0 +1,121 @@
+package runnerconfig
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+
+	"github.com/pkg/errors"
+)
+
+type DockerEngine struct {
+	DaemonURL        *string         `mapstructure:"daemon_url" json:"daemon_url"`
+	CustomConfigPath *string         `mapstructure:"custom_config_path" json:"custom_config_path"`
+	QuotasPath       *string         `mapstructure:"quotas_path" json:"quotas_path"`
+	GC               *DockerEngineGC `mapstructure:"gc" json:"gc"`
+	Prewarm          *Prewarm        `mapstructure:"prewarm" json:"prewarm"`
+
+	// Executor defines how queries are sent to containers: EXEC (default) or HTTP.
+	Executor dockerengine.ExecutorType `mapstructure:"executor" json:"executor"`
+	HTTP     DockerEngineHTTP          `mapstructure:"http" json:"http"`
+
+	Container ContainerSettings `mapstructure:"container" json:"container"`
+}
+
+type DockerEngineHTTP struct {
+	Network string `mapstructure:"network" json:"network"`
+	Port    int    `mapstructure:"port" json:"port"`
+}
+
+type DockerEngineGC struct {
+	TriggerFrequency time.Duration `mapstructure:"trigger_frequency" json:"trigger_frequency"`
+
+	ContainerTTL *time.Duration `mapstructure:"container_ttl" json:"container_ttl"`
+
+	ImageGCCountThreshold *uint `mapstructure:"image_count_threshold" json:"image_count_threshold"`
+	ImageBufferSize       uint  `mapstructure:"image_buffer_size" json:"image_buffer_size"`
+}
+
+type Prewarm struct {
+	MaxWarmContainers *uint `mapstructure:"max_warm_containers" json:"max_warm_containers"`
+}
+
+type ContainerSettings struct {
+	NetworkMode   *string `mapstructure:"network_mode" json:"network_mode"`
+	CPULimit      float64 `mapstructure:"cpu_limit" json:"cpu_limit"`
+	CPUSet        string  `mapstructure:"cpu_cores_set" json:"cpu_cores_set"`
+	MemoryLimitMB float64 `mapstructure:"memory_limit_mb" json:"memory_limit_mb"`
+}
+
+// Validate verifies the section and sets default values for missed fields.
+func (d *DockerEngine) Validate() error {
+	if d.DaemonURL != nil && !strings.HasPrefix(*d.DaemonURL, "ssh://") {
+		return errors.Errorf("daemon_url must be empty or start with 'ssh://', but %s found", *d.DaemonURL)
+	}
+
+	switch d.Executor {
+	case "":
+		d.Executor = dockerengine.DefaultConfig.Executor
+
+	case dockerengine.ExecutorExec, dockerengine.ExecutorHTTP:
+
+	default:
+		return errors.Errorf("unknown executor %s (supported: %s, %s)",
+			d.Executor, dockerengine.ExecutorExec, dockerengine.ExecutorHTTP)
+	}
+
+	if d.HTTP.Port == 0 {
+		d.HTTP.Port = dockerengine.DefaultConfig.HTTP.Port
+	}
+
+	if d.GC != nil && d.GC.TriggerFrequency == 0 {
+		d.GC.TriggerFrequency = 1 * time.Minute
+	}
+
+	return nil
+}
+
+// RunnerConfig converts the section to the config of the Docker engine runner.
+// The default output format is used if it's not nil.
+func (d *DockerEngine) RunnerConfig(datasets []dataset.Dataset, clusters Clusters, defaultFormat *string) dockerengine.Config {
+	rcfg := dockerengine.DefaultConfig
+	rcfg.DaemonURL = d.DaemonURL
+	rcfg.CustomConfigPath = d.CustomConfigPath
+	rcfg.QuotasPath = d.QuotasPath
+	rcfg.Executor = d.Executor
+	rcfg.Datasets = datasets
+	rcfg.Cluster.MaxNodes = clusters.MaxNodes
+	rcfg.HTTP = dockerengine.HTTPConfig{
+		Network: d.HTTP.Network,
+		Port:    d.HTTP.Port,
+	}
+	rcfg.GC = nil
+
+	if defaultFormat != nil {
+		rcfg.DefaultOutputFormat = *defaultFormat
+	}
+
+	if d.GC != nil {
+		rcfg.GC = &dockerengine.GCConfig{
+			TriggerFrequency:      d.GC.TriggerFrequency,
+			ContainerTTL:          d.GC.ContainerTTL,
+			ImageGCCountThreshold: d.GC.ImageGCCountThreshold,
+			ImageBufferSize:       d.GC.ImageBufferSize,
+		}
+	}
+
+	rcfg.Container = dockerengine.ContainerSettings{
+		NetworkMode: d.Container.NetworkMode,
+		CPULimit:    uint64(d.Container.CPULimit * 1e9), // cpu -> nano cpu.
+		CPUSet:      d.Container.CPUSet,
+		MemoryLimit: uint64(d.Container.MemoryLimitMB * 1e6), // mb -> bytes.
+	}
+
+	if d.Prewarm != nil && d.Prewarm.MaxWarmContainers != nil {
+		rcfg.MaxWarmContainers = *d.Prewarm.MaxWarmContainers
+	}
+
+	return rcfg
+}
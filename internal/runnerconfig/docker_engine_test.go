@@ -0,0 +1,48 @@
+package runnerconfig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/dockerengine"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerEngine_Validate(t *testing.T) {
+	d := DockerEngine{GC: &DockerEngineGC{}}
+	require.NoError(t, d.Validate())
+	assert.Equal(t, dockerengine.ExecutorExec, d.Executor)
+	assert.Equal(t, dockerengine.DefaultConfig.HTTP.Port, d.HTTP.Port)
+	assert.Equal(t, time.Minute, d.GC.TriggerFrequency)
+
+	daemonURL := "tcp://127.0.0.1:2375"
+	assert.Error(t, (&DockerEngine{DaemonURL: &daemonURL}).Validate())
+	assert.Error(t, (&DockerEngine{Executor: "SSH"}).Validate())
+}
+
+func TestDockerEngine_RunnerConfig(t *testing.T) {
+	daemonURL := "ssh://worker-1"
+	maxWarm := uint(2)
+	format := "JSON"
+	d := DockerEngine{
+		DaemonURL: &daemonURL,
+		Executor:  dockerengine.ExecutorHTTP,
+		Prewarm:   &Prewarm{MaxWarmContainers: &maxWarm},
+		Container: ContainerSettings{CPULimit: 1.5, MemoryLimitMB: 500},
+	}
+	datasets := []dataset.Dataset{{Name: "tpch"}}
+
+	rcfg := d.RunnerConfig(datasets, Clusters{MaxNodes: 3}, &format)
+	assert.Equal(t, &daemonURL, rcfg.DaemonURL)
+	assert.Equal(t, dockerengine.ExecutorHTTP, rcfg.Executor)
+	assert.Equal(t, datasets, rcfg.Datasets)
+	assert.Equal(t, 3, rcfg.Cluster.MaxNodes)
+	assert.Equal(t, "JSON", rcfg.DefaultOutputFormat)
+	assert.Nil(t, rcfg.GC)
+	assert.Equal(t, uint(2), rcfg.MaxWarmContainers)
+	assert.Equal(t, uint64(1.5e9), rcfg.Container.CPULimit)
+	assert.Equal(t, uint64(500e6), rcfg.Container.MemoryLimit)
+}
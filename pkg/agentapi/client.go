@@ -0,0 +1,141 @@
+package agentapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+
+	"github.com/pkg/errors"
+)
+
+// Client sends requests to a playground agent.
+type Client struct {
+	baseURL string
+	token   string
+
+	cli *http.Client
+}
+
+// NewClient creates a client of the agent listening on baseURL, e.g. http://worker-1:9090.
+func NewClient(baseURL string, token string, httpCli ...*http.Client) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		cli:     http.DefaultClient,
+	}
+	if len(httpCli) == 1 {
+		c.cli = httpCli[0]
+	}
+
+	return c
+}
+
+func (c *Client) Status(ctx context.Context) (*StatusResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, PathStatus, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	status := new(StatusResponse)
+	err = json.NewDecoder(resp.Body).Decode(status)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode response")
+	}
+
+	return status, nil
+}
+
+// RunQuery executes the run on the agent. The output is passed to onOutput as soon as it's received.
+// The RESULT event is returned, the run error is in its Error field.
+func (c *Client) RunQuery(ctx context.Context, req *RunQueryRequest, onOutput qrunner.OutputHandler) (*Event, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode request")
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, PathRuns, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event Event
+		err = decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("agent has closed the stream without a result")
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the stream")
+		}
+
+		switch event.Type {
+		case EventOutput:
+			if onOutput != nil {
+				onOutput(qrunner.OutputChunk{Stream: event.Stream, Data: event.Data})
+			}
+
+		case EventResult:
+			return &event, nil
+		}
+	}
+}
+
+// StopRun cancels the run and returns its output received so far.
+func (c *Client) StopRun(ctx context.Context, runID string) (string, error) {
+	path := strings.Replace(PathStopRun, "{id}", url.PathEscape(runID), 1)
+
+	resp, err := c.do(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out StopRunResponse
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode response")
+	}
+
+	return out.Output, nil
+}
+
+// do sends the authenticated request. If the agent responds with an error, it's converted to the runner error.
+func (c *Client) do(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	var agentErr Error
+	err = json.NewDecoder(resp.Body).Decode(&agentErr)
+	if err != nil || agentErr.Code == "" {
+		return nil, errors.Errorf("agent responded with status %d", resp.StatusCode)
+	}
+
+	return nil, agentErr.Err()
+}
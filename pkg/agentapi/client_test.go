@@ -0,0 +1,136 @@
+package agentapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient starts an HTTPS agent with a stub runner and returns a client trusting its certificate.
+func newTestClient(t *testing.T, token string, run stubrunner.Run) *Client {
+	srv := httptest.NewTLSServer(newTestHandler(run))
+	t.Cleanup(srv.Close)
+
+	return NewClient(srv.URL+"/", token, srv.Client())
+}
+
+func newTestRequest() *RunQueryRequest {
+	return &RunQueryRequest{
+		Run:   queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}),
+		Image: testImage,
+	}
+}
+
+func TestClient_Status(t *testing.T) {
+	status, err := newTestClient(t, testToken, stubrunner.StubRun).Status(context.Background())
+	require.NoError(t, err)
+	assert.True(t, status.Alive)
+	assert.Equal(t, qrunner.AllFeatures, status.Features)
+
+	_, err = newTestClient(t, "invalid", stubrunner.StubRun).Status(context.Background())
+	assert.ErrorContains(t, err, string(ErrorCodeUnauthorized))
+}
+
+func TestClient_RunQuery(t *testing.T) {
+	cli := newTestClient(t, testToken, func(_ context.Context, _ *queryrun.Run) (string, error) {
+		return "1\n", nil
+	})
+
+	var chunks []qrunner.OutputChunk
+	result, err := cli.RunQuery(context.Background(), newTestRequest(), func(chunk qrunner.OutputChunk) {
+		chunks = append(chunks, chunk)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", result.Output)
+	assert.NoError(t, result.Error.Err())
+	assert.Equal(t, []qrunner.OutputChunk{{Stream: qrunner.StreamStdout, Data: "1\n"}}, chunks)
+}
+
+func TestClient_RunQueryError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		target error
+	}{
+		{"memory limit", qrunner.ErrMemoryLimitExceeded, qrunner.ErrMemoryLimitExceeded},
+		{"cancelled", qrunner.ErrRunCancelled, qrunner.ErrRunCancelled},
+		{"internal", errors.New("docker is down"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := newTestClient(t, testToken, func(_ context.Context, _ *queryrun.Run) (string, error) {
+				return "", tt.err
+			})
+
+			result, err := cli.RunQuery(context.Background(), newTestRequest(), nil)
+			require.NoError(t, err)
+
+			runErr := result.Error.Err()
+			if tt.target != nil {
+				assert.ErrorIs(t, runErr, tt.target)
+			} else {
+				assert.ErrorContains(t, runErr, "docker is down")
+			}
+		})
+	}
+}
+
+func TestClient_StopRun(t *testing.T) {
+	started := make(chan struct{})
+	cli := newTestClient(t, testToken, func(ctx context.Context, _ *queryrun.Run) (string, error) {
+		close(started)
+		<-ctx.Done()
+
+		return "partial", nil
+	})
+
+	_, err := cli.StopRun(context.Background(), "unknown")
+	assert.ErrorIs(t, err, qrunner.ErrRunNotFound)
+
+	req := newTestRequest()
+	done := make(chan *Event)
+	go func() {
+		result, err := cli.RunQuery(context.Background(), req, nil)
+		assert.NoError(t, err)
+		done <- result
+	}()
+
+	<-started
+	_, err = cli.StopRun(context.Background(), req.Run.ID)
+	require.NoError(t, err)
+
+	result := <-done
+	assert.ErrorIs(t, result.Error.Err(), qrunner.ErrRunCancelled)
+	assert.Equal(t, "partial", result.Output)
+}
+
+func TestClient_streamWithoutResult(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"type":"OUTPUT","stream":"stdout","data":"1\n"}` + "\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := NewClient(srv.URL, testToken).RunQuery(context.Background(), newTestRequest(), nil)
+	assert.ErrorContains(t, err, "without a result")
+}
+
+func TestClient_unexpectedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := NewClient(srv.URL, testToken).Status(context.Background())
+	assert.ErrorContains(t, err, "status 502")
+}
@@ -0,0 +1,122 @@
+// Package agentapi implements the protocol between the coordinator and playground agents.
+//
+// An agent runs on a worker host next to the Docker daemon and executes queries with a local runner.
+// Requests are authenticated with a bearer token. The run response is a stream of JSON events:
+// OUTPUT events carry the output as soon as it's received, the last RESULT event carries the run result.
+package agentapi
+
+import (
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PathStatus = "/api/v1/status"
+	PathRuns   = "/api/v1/runs"
+
+	// PathStopRun is the path of the run stop endpoint, {id} is the run id.
+	PathStopRun = "/api/v1/runs/{id}/stop"
+)
+
+// File is an input file of a run. Contents of files are not serialized with runs, so they're sent separately.
+type File struct {
+	Name    string `json:"name"`
+	Content []byte `json:"content"`
+}
+
+type RunQueryRequest struct {
+	Run   *queryrun.Run `json:"run"`
+	Files []File        `json:"files,omitempty"`
+
+	// Image is the image of the run version. Agents don't resolve versions themselves,
+	// so all agents run the image the coordinator has chosen.
+	Image dockertag.Image `json:"image"`
+}
+
+type EventType string
+
+const (
+	EventOutput EventType = "OUTPUT"
+	EventResult EventType = "RESULT"
+)
+
+// Event is a line of the run response stream.
+type Event struct {
+	Type EventType `json:"type"`
+
+	// Stream and Data are set for OUTPUT events.
+	Stream qrunner.OutputStream `json:"stream,omitempty"`
+	Data   string               `json:"data,omitempty"`
+
+	// Output, Run and Error are set for the RESULT event. Run contains results
+	// filled by the runner: statements, query error, result set and container state.
+	Output string        `json:"output,omitempty"`
+	Run    *queryrun.Run `json:"run,omitempty"`
+	Error  *Error        `json:"error,omitempty"`
+}
+
+type StatusResponse struct {
+	Alive bool   `json:"alive"`
+	Error string `json:"error,omitempty"`
+
+	// Features are optional run features the agent runner supports.
+	Features qrunner.Features `json:"features"`
+}
+
+type StopRunResponse struct {
+	Output string `json:"output"`
+}
+
+type ErrorCode string
+
+const (
+	ErrorCodeInternal            ErrorCode = "INTERNAL"
+	ErrorCodeBadRequest          ErrorCode = "BAD_REQUEST"
+	ErrorCodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrorCodeRunNotFound         ErrorCode = "RUN_NOT_FOUND"
+	ErrorCodeRunCancelled        ErrorCode = "RUN_CANCELLED"
+	ErrorCodeMemoryLimitExceeded ErrorCode = "MEMORY_LIMIT_EXCEEDED"
+)
+
+// codeErrors maps codes to errors runners return, so the coordinator can handle them like errors of local runners.
+var codeErrors = map[ErrorCode]error{
+	ErrorCodeRunNotFound:         qrunner.ErrRunNotFound,
+	ErrorCodeRunCancelled:        qrunner.ErrRunCancelled,
+	ErrorCodeMemoryLimitExceeded: qrunner.ErrMemoryLimitExceeded,
+}
+
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func newError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	for code, target := range codeErrors {
+		if errors.Is(err, target) {
+			return &Error{Code: code, Message: err.Error()}
+		}
+	}
+
+	return &Error{Code: ErrorCodeInternal, Message: err.Error()}
+}
+
+// Err converts the error back to the runner error.
+func (e *Error) Err() error {
+	if e == nil {
+		return nil
+	}
+
+	target, found := codeErrors[e.Code]
+	if found {
+		return target
+	}
+
+	return errors.Errorf("agent error (%s): %s", e.Code, e.Message)
+}
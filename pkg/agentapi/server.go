@@ -0,0 +1,192 @@
+package agentapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+type HandlerOpts struct {
+	Logger zerolog.Logger
+
+	// Runner executes queries on the agent host. Images chosen by the coordinator
+	// are passed to the runner with qrunner.WithImage.
+	Runner qrunner.Runner
+
+	// Token authenticates the coordinator.
+	Token string
+}
+
+type handler struct {
+	logger zerolog.Logger
+	runner qrunner.Runner
+	token  string
+}
+
+// NewHandler returns the HTTP handler of the agent protocol.
+func NewHandler(opts HandlerOpts) http.Handler {
+	h := &handler{
+		logger: opts.Logger,
+		runner: opts.Runner,
+		token:  opts.Token,
+	}
+
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{
+		Logger:  &opts.Logger,
+		NoColor: true,
+	}))
+	r.Use(middleware.Recoverer)
+	r.Use(h.authenticate)
+
+	r.Get(PathStatus, h.status)
+	r.Post(PathRuns, h.runQuery)
+	r.Post(PathStopRun, h.stopRun)
+
+	return r
+}
+
+// authenticate checks the bearer token of the request.
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, &Error{Code: ErrorCodeUnauthorized, Message: "invalid token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	status := h.runner.Status(r.Context())
+
+	resp := StatusResponse{
+		Alive:    status.Alive,
+		Features: qrunner.SupportedFeatures(h.runner),
+	}
+	if status.LivenessProbeErr != nil {
+		resp.Error = status.LivenessProbeErr.Error()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runQuery executes the run and streams its output. The run is cancelled if the coordinator disconnects.
+func (h *handler) runQuery(w http.ResponseWriter, r *http.Request) {
+	var req RunQueryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, &Error{Code: ErrorCodeBadRequest, Message: "invalid request: " + err.Error()})
+		return
+	}
+	if req.Run == nil || req.Run.Settings == nil {
+		writeError(w, http.StatusBadRequest, &Error{Code: ErrorCodeBadRequest, Message: "run with settings is required"})
+		return
+	}
+	if req.Image.Repository == "" {
+		writeError(w, http.StatusBadRequest, &Error{Code: ErrorCodeBadRequest, Message: "image is required"})
+		return
+	}
+
+	run := req.Run
+	run.Files = make([]queryrun.InputFile, 0, len(req.Files))
+	for _, f := range req.Files {
+		run.Files = append(run.Files, queryrun.NewInputFile(f.Name, f.Content))
+	}
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, newError(err))
+		return
+	}
+
+	// The image is passed with the run, so concurrent runs of the same version may use different images.
+	ctx := qrunner.WithImage(r.Context(), req.Image)
+
+	output, err := h.runner.RunQueryStream(ctx, run, func(chunk qrunner.OutputChunk) {
+		stream.send(Event{Type: EventOutput, Stream: chunk.Stream, Data: chunk.Data})
+	})
+	if err != nil {
+		h.logger.Debug().Err(err).Str("run_id", run.ID).Msg("run failed")
+	}
+
+	stream.send(Event{
+		Type:   EventResult,
+		Output: output,
+		Run:    run,
+		Error:  newError(err),
+	})
+}
+
+func (h *handler) stopRun(w http.ResponseWriter, r *http.Request) {
+	output, err := h.runner.CancelRun(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, qrunner.ErrRunNotFound) {
+		writeError(w, http.StatusNotFound, newError(err))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, newError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StopRunResponse{Output: output})
+}
+
+// eventStream writes newline-delimited events and flushes them immediately.
+type eventStream struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	flusher http.Flusher
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	return &eventStream{
+		encoder: json.NewEncoder(w),
+		flusher: flusher,
+	}, nil
+}
+
+func (s *eventStream) send(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The coordinator may have disconnected, the run is cancelled then.
+	err := s.encoder.Encode(event)
+	if err != nil {
+		return
+	}
+
+	s.flusher.Flush()
+}
+
+func writeError(w http.ResponseWriter, code int, e *Error) {
+	writeJSON(w, code, e)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
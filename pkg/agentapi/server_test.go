@@ -0,0 +1,151 @@
+package agentapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+
+var testImage = dockertag.Image{
+	Repository: "clickhouse/clickhouse-server",
+	Tag:        "23.3",
+	Digest:     "sha256:edfee043e4f909dd471c6e282ce3cfd0ce90a4cad3fc234cb27633debe26ea05",
+}
+
+func newTestHandler(run stubrunner.Run) http.Handler {
+	return NewHandler(HandlerOpts{
+		Logger: zerolog.Nop(),
+		Runner: stubrunner.New(context.Background(), "agent", run),
+		Token:  testToken,
+	})
+}
+
+func serve(h http.Handler, method string, path string, authorization string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandler_authenticate(t *testing.T) {
+	h := newTestHandler(stubrunner.StubRun)
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          int
+	}{
+		{"valid", "Bearer " + testToken, http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"without scheme", testToken, http.StatusUnauthorized},
+		{"invalid token", "Bearer invalid", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, http.MethodGet, PathStatus, tt.authorization, nil)
+			assert.Equal(t, tt.code, rec.Code)
+
+			if tt.code == http.StatusUnauthorized {
+				var e Error
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&e))
+				assert.Equal(t, ErrorCodeUnauthorized, e.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_runQuery(t *testing.T) {
+	h := newTestHandler(func(ctx context.Context, run *queryrun.Run) (string, error) {
+		img, found := qrunner.ImageFromContext(ctx)
+		require.True(t, found)
+		assert.Equal(t, testImage, img)
+
+		require.Len(t, run.Files, 1)
+		assert.Equal(t, "data", string(run.Files[0].Content))
+
+		run.QueryError = &queryrun.QueryError{Code: 62, Name: "SYNTAX_ERROR"}
+
+		return "1\n", nil
+	})
+
+	body, err := json.Marshal(RunQueryRequest{
+		Run:   queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}),
+		Files: []File{{Name: "data.tsv", Content: []byte("data")}},
+		Image: testImage,
+	})
+	require.NoError(t, err)
+
+	rec := serve(h, http.MethodPost, PathRuns, "Bearer "+testToken, body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+	var events []Event
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+
+	assert.Equal(t, Event{Type: EventOutput, Stream: qrunner.StreamStdout, Data: "1\n"}, events[0])
+
+	result := events[1]
+	assert.Equal(t, EventResult, result.Type)
+	assert.Equal(t, "1\n", result.Output)
+	assert.Nil(t, result.Error)
+	require.NotNil(t, result.Run)
+	assert.Equal(t, &queryrun.QueryError{Code: 62, Name: "SYNTAX_ERROR"}, result.Run.QueryError)
+}
+
+func TestHandler_runQueryBadRequest(t *testing.T) {
+	h := newTestHandler(stubrunner.StubRun)
+
+	withoutImage, err := json.Marshal(RunQueryRequest{
+		Run: queryrun.New("SELECT 1", "clickhouse", "23.3", &runsettings.ClickHouseSettings{}),
+	})
+	require.NoError(t, err)
+
+	for _, body := range [][]byte{[]byte("{"), []byte("{}"), withoutImage} {
+		rec := serve(h, http.MethodPost, PathRuns, "Bearer "+testToken, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, string(body))
+
+		var e Error
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&e))
+		assert.Equal(t, ErrorCodeBadRequest, e.Code)
+	}
+}
+
+func TestHandler_stopRun(t *testing.T) {
+	h := newTestHandler(stubrunner.StubRun)
+
+	rec := serve(h, http.MethodPost, strings.Replace(PathStopRun, "{id}", "unknown", 1), "Bearer "+testToken, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	var e Error
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&e))
+	assert.Equal(t, ErrorCodeRunNotFound, e.Code)
+}
@@ -0,0 +1,71 @@
+package chspec
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Exception is an error reported by ClickHouse.
+type Exception struct {
+	// Code is the numeric error code, e.g. 62 for SYNTAX_ERROR.
+	Code int
+
+	// Name is the symbolic error code, e.g. SYNTAX_ERROR. Old versions don't print it.
+	Name string
+
+	Message string
+}
+
+var (
+	// Old versions print 'Code: 60, e.displayText() = DB::Exception: ...',
+	// new ones print 'Code: 60. DB::Exception: ...'.
+	exceptionCodeRegexp = regexp.MustCompile(`(?m)^Code: (\d+)[.,] (?:e\.displayText\(\) = )?`)
+
+	exceptionSourceRegexp  = regexp.MustCompile(`^(?:DB::\w*Exception: )?Received from \S+\. `)
+	exceptionVersionRegexp = regexp.MustCompile(`\s*\(version [^)]*\)$`)
+	exceptionNameRegexp    = regexp.MustCompile(`\s*\(([A-Z][A-Z0-9_]*)\)$`)
+)
+
+// ParseException finds the first exception printed by clickhouse client to stderr.
+//
+// Example:
+// ParseException("Code: 62. DB::Exception: Syntax error: failed at position 1 ('SELEC'). (SYNTAX_ERROR)") =
+// {Code: 62, Name: "SYNTAX_ERROR", Message: "Syntax error: failed at position 1 ('SELEC')."}
+func ParseException(stderr string) (Exception, bool) {
+	loc := exceptionCodeRegexp.FindStringSubmatchIndex(stderr)
+	if loc == nil {
+		return Exception{}, false
+	}
+
+	code, err := strconv.Atoi(stderr[loc[2]:loc[3]])
+	if err != nil {
+		return Exception{}, false
+	}
+
+	message := stderr[loc[1]:]
+
+	// The client prints the failed query and the stack trace after the message.
+	for _, suffix := range []string{"\n(query: ", "\nStack trace"} {
+		if i := strings.Index(message, suffix); i != -1 {
+			message = message[:i]
+		}
+	}
+
+	message = strings.TrimSpace(message)
+	message = exceptionSourceRegexp.ReplaceAllString(message, "")
+	message = strings.TrimPrefix(message, "DB::Exception: ")
+	message = exceptionVersionRegexp.ReplaceAllString(message, "")
+
+	var name string
+	if match := exceptionNameRegexp.FindStringSubmatch(message); match != nil {
+		name = match[1]
+		message = message[:len(message)-len(match[0])]
+	}
+
+	return Exception{
+		Code:    code,
+		Name:    name,
+		Message: message,
+	}, true
+}
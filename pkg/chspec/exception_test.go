@@ -0,0 +1,48 @@
+package chspec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseException(t *testing.T) {
+	tests := []struct {
+		stderr   string
+		expected Exception
+	}{
+		{
+			stderr: "Received exception from server (version 23.3.1):\n" +
+				"Code: 47. DB::Exception: Received from localhost:9000. DB::Exception: Missing columns: 'x' while processing query: 'SELECT x'. (UNKNOWN_IDENTIFIER)\n" +
+				"(query: SELECT x)\n",
+			expected: Exception{Code: 47, Name: "UNKNOWN_IDENTIFIER", Message: "Missing columns: 'x' while processing query: 'SELECT x'."},
+		},
+		{
+			stderr:   "Code: 62. DB::Exception: Syntax error: failed at position 1 ('SELEC'): SELEC 1. Expected one of: SELECT, WITH. (SYNTAX_ERROR)\n",
+			expected: Exception{Code: 62, Name: "SYNTAX_ERROR", Message: "Syntax error: failed at position 1 ('SELEC'): SELEC 1. Expected one of: SELECT, WITH."},
+		},
+		{
+			stderr: "Received exception from server (version 20.3.1):\n" +
+				"Code: 60. DB::Exception: Received from localhost:9000. DB::Exception: Table default.t doesn't exist.. \n",
+			expected: Exception{Code: 60, Message: "Table default.t doesn't exist.."},
+		},
+		{
+			stderr:   "Code: 60, e.displayText() = DB::Exception: Table default.t doesn't exist. (version 19.17.1.1)\n",
+			expected: Exception{Code: 60, Message: "Table default.t doesn't exist."},
+		},
+	}
+
+	for _, tt := range tests {
+		exc, ok := ParseException(tt.stderr)
+		assert.True(t, ok, tt.stderr)
+		assert.Equal(t, tt.expected, exc, tt.stderr)
+	}
+}
+
+func TestParseException_NoException(t *testing.T) {
+	_, ok := ParseException("")
+	assert.False(t, ok)
+
+	_, ok = ParseException("Warning: the query is deprecated\n")
+	assert.False(t, ok)
+}
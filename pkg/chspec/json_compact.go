@@ -0,0 +1,50 @@
+package chspec
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// FormatJSONCompact is a ClickHouse output format with column metadata, rows as arrays and query statistics.
+// https://clickhouse.com/docs/en/interfaces/formats#jsoncompact
+const FormatJSONCompact = "JSONCompact"
+
+type ColumnMeta struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type QueryStatistics struct {
+	// Elapsed is the query execution time in seconds.
+	Elapsed   float64 `json:"elapsed"`
+	RowsRead  uint64  `json:"rows_read"`
+	BytesRead uint64  `json:"bytes_read"`
+}
+
+// JSONCompactOutput is a result set printed in the JSONCompact format.
+type JSONCompactOutput struct {
+	Meta []ColumnMeta `json:"meta"`
+
+	// Data contains rows as arrays of values. Values are kept as they are printed,
+	// e.g. 64-bit integers are quoted by default.
+	Data []json.RawMessage `json:"data"`
+
+	Rows       uint64          `json:"rows"`
+	Statistics QueryStatistics `json:"statistics"`
+}
+
+// ParseJSONCompact parses the output of a query executed with FORMAT JSONCompact.
+func ParseJSONCompact(output string) (*JSONCompactOutput, error) {
+	var result JSONCompactOutput
+	err := json.Unmarshal([]byte(output), &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JSONCompact output")
+	}
+
+	if result.Meta == nil {
+		return nil, errors.New("invalid JSONCompact output: meta is missed")
+	}
+
+	return &result, nil
+}
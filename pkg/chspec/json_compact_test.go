@@ -0,0 +1,70 @@
+package chspec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonCompactOutput = `{
+	"meta":
+	[
+		{
+			"name": "number",
+			"type": "UInt64"
+		},
+		{
+			"name": "s",
+			"type": "String"
+		},
+		{
+			"name": "arr",
+			"type": "Array(UInt8)"
+		}
+	],
+
+	"data":
+	[
+		["0", "a\tb", [1,2]],
+		["1", "c", []]
+	],
+
+	"rows": 2,
+
+	"rows_before_limit_at_least": 2,
+
+	"statistics":
+	{
+		"elapsed": 0.000123,
+		"rows_read": 2,
+		"bytes_read": 16
+	}
+}
+`
+
+func TestParseJSONCompact(t *testing.T) {
+	out, err := ParseJSONCompact(jsonCompactOutput)
+	require.NoError(t, err)
+
+	assert.Equal(t, []ColumnMeta{
+		{Name: "number", Type: "UInt64"},
+		{Name: "s", Type: "String"},
+		{Name: "arr", Type: "Array(UInt8)"},
+	}, out.Meta)
+	assert.Equal(t, []json.RawMessage{
+		json.RawMessage(`["0", "a\tb", [1,2]]`),
+		json.RawMessage(`["1", "c", []]`),
+	}, out.Data)
+	assert.EqualValues(t, 2, out.Rows)
+	assert.Equal(t, QueryStatistics{Elapsed: 0.000123, RowsRead: 2, BytesRead: 16}, out.Statistics)
+}
+
+func TestParseJSONCompact_Invalid(t *testing.T) {
+	_, err := ParseJSONCompact("0\n1\n")
+	assert.Error(t, err)
+
+	_, err = ParseJSONCompact(`{"data": []}`)
+	assert.Error(t, err)
+}
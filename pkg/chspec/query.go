@@ -0,0 +1,34 @@
+package chspec
+
+import (
+	"strings"
+)
+
+// NormalizeQuery returns a canonical representation of the query text,
+// so that queries that differ only in formatting have the same representation.
+//
+// Comments are removed, whitespace sequences and comments outside of string literals and quoted identifiers
+// are replaced with a single space, leading and trailing whitespace and semicolons are removed.
+//
+// Example:
+// NormalizeQuery("-- comment\nSELECT  1,\n\t'a  b';  ") = "SELECT 1, 'a  b'"
+func NormalizeQuery(query string) string {
+	tokens := tokenize(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenPunct && query[tokens[len(tokens)-1].start] == ';' {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i, t := range tokens {
+		// Tokens separated by whitespace or comments are separated by a single space.
+		if i > 0 && tokens[i-1].end < t.start {
+			b.WriteByte(' ')
+		}
+
+		b.WriteString(query[t.start:t.end])
+	}
+
+	return b.String()
+}
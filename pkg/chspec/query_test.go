@@ -0,0 +1,35 @@
+package chspec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"  SELECT\n\t1  ", "SELECT 1"},
+		{"SELECT 1;\n", "SELECT 1"},
+		{"SELECT 1;; ;", "SELECT 1"},
+		{"SELECT 1;\n\nSELECT   2;", "SELECT 1; SELECT 2"},
+		{"SELECT 'a  b',  \"c  d\",\n`e  f`", "SELECT 'a  b', \"c  d\", `e  f`"},
+		{"SELECT 'it\\'s   ok',  1", "SELECT 'it\\'s   ok', 1"},
+		{"SELECT 'it''s   ok',  1", "SELECT 'it''s   ok', 1"},
+		{"SELECT ';  '", "SELECT ';  '"},
+		{"-- c\nSELECT 1", "SELECT 1"},
+		{"-- c SELECT 1", ""},
+		{"SELECT 1 -- comment", "SELECT 1"},
+		{"SELECT /* a */ 1, /* b */\n2", "SELECT 1, 2"},
+		{"SELECT/**/1", "SELECT 1"},
+		{"SELECT '-- not a comment', \"/* neither */\"", "SELECT '-- not a comment', \"/* neither */\""},
+		{"SELECT 1; -- trailing\n;", "SELECT 1"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NormalizeQuery(tt.query), tt.query)
+	}
+}
@@ -0,0 +1,62 @@
+package chspec
+
+import (
+	"path"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var settingNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// SettingsPolicy restricts ClickHouse settings users can pass with a query.
+//
+// Patterns are globs matched against setting names, e.g. "allow_experimental_*".
+// A setting is allowed if it matches any of the allowed patterns and none of the denied ones.
+//
+// The policy only applies to settings passed with the API request. It is not a security
+// boundary: a query can still change settings with SET or a SETTINGS clause, so limits that
+// must hold are to be enforced by ClickHouse itself, e.g. with quotas and settings constraints
+// of the user profile.
+type SettingsPolicy struct {
+	Allowed []string
+	Denied  []string
+}
+
+// Validate checks that patterns are well-formed.
+func (p SettingsPolicy) Validate() error {
+	for _, pattern := range append(p.Allowed[:len(p.Allowed):len(p.Allowed)], p.Denied...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+
+	return nil
+}
+
+// Check returns an error if any of the settings is not allowed.
+func (p SettingsPolicy) Check(settings map[string]string) error {
+	for name := range settings {
+		if !settingNameRegexp.MatchString(name) {
+			return errors.Errorf("invalid setting name %q", name)
+		}
+
+		if !matchAny(p.Allowed, name) || matchAny(p.Denied, name) {
+			return errors.Errorf("setting %s is not allowed", name)
+		}
+	}
+
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		matched, _ := path.Match(pattern, name)
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package chspec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsPolicy_Check(t *testing.T) {
+	policy := SettingsPolicy{
+		Allowed: []string{"max_threads", "join_algorithm", "allow_experimental_*"},
+		Denied:  []string{"allow_experimental_object_type"},
+	}
+
+	assert.NoError(t, policy.Check(nil))
+	assert.NoError(t, policy.Check(map[string]string{"max_threads": "1", "join_algorithm": "hash"}))
+	assert.NoError(t, policy.Check(map[string]string{"allow_experimental_analyzer": "1"}))
+
+	assert.Error(t, policy.Check(map[string]string{"max_memory_usage": "0"}))
+	assert.Error(t, policy.Check(map[string]string{"allow_experimental_object_type": "1"}))
+	assert.Error(t, policy.Check(map[string]string{"config-file": "/etc/passwd"}))
+}
+
+func TestSettingsPolicy_Empty(t *testing.T) {
+	assert.NoError(t, SettingsPolicy{}.Check(nil))
+	assert.Error(t, SettingsPolicy{}.Check(map[string]string{"max_threads": "1"}))
+}
+
+func TestSettingsPolicy_Validate(t *testing.T) {
+	assert.NoError(t, SettingsPolicy{Allowed: []string{"max_*"}, Denied: []string{"max_memory_usage"}}.Validate())
+	assert.Error(t, SettingsPolicy{Denied: []string{"max_["}}.Validate())
+}
@@ -0,0 +1,375 @@
+package chspec
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenLiteral
+	tokenPunct
+)
+
+// token is a lexeme of a query located at query[start:end].
+// Whitespace and comments are not tokens.
+type token struct {
+	kind  tokenKind
+	start int
+	end   int
+
+	// Nesting level of parentheses.
+	depth int
+}
+
+// tokenize splits the query into words, quoted literals and punctuation.
+// It's not a complete SQL lexer, it's enough to find statement boundaries and top-level keywords.
+func tokenize(query string) []token {
+	var tokens []token
+	depth := 0
+
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				return tokens
+			}
+			i += end + 1
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				return tokens
+			}
+			i += 2 + end + 2
+
+		case r == '\'' || r == '"' || r == '`':
+			end := closingQuote(query, i)
+			tokens = append(tokens, token{kind: tokenLiteral, start: i, end: end, depth: depth})
+			i = end
+
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			end := i + size
+			for end < len(query) {
+				next, nextSize := utf8.DecodeRuneInString(query[end:])
+				if next != '_' && next != '.' && !unicode.IsLetter(next) && !unicode.IsDigit(next) {
+					break
+				}
+				end += nextSize
+			}
+
+			tokens = append(tokens, token{kind: tokenWord, start: i, end: end, depth: depth})
+			i = end
+
+		default:
+			if r == ')' && depth > 0 {
+				depth--
+			}
+
+			tokens = append(tokens, token{kind: tokenPunct, start: i, end: i + size, depth: depth})
+			i += size
+
+			if r == '(' {
+				depth++
+			}
+		}
+	}
+
+	return tokens
+}
+
+// closingQuote returns the position after the literal started at query[start].
+// Quotes can be escaped with a backslash or doubled.
+func closingQuote(query string, start int) int {
+	quote := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+// SplitStatements splits a multi-statement query into separate statements by semicolons.
+// Semicolons inside string literals, quoted identifiers and comments are ignored.
+// Statements are trimmed, empty statements and statements consisting only of comments are skipped.
+//
+// Example:
+// SplitStatements("SELECT ';'; -- comment\nSELECT 2;") = ["SELECT ';'", "-- comment\nSELECT 2"]
+func SplitStatements(query string) []string {
+	var statements []string
+
+	start := 0
+	hasTokens := false
+	for _, t := range tokenize(query) {
+		if t.kind == tokenPunct && query[t.start] == ';' {
+			if hasTokens {
+				statements = append(statements, strings.TrimSpace(query[start:t.start]))
+			}
+
+			start = t.end
+			hasTokens = false
+
+			continue
+		}
+
+		hasTokens = true
+	}
+
+	if hasTokens {
+		statements = append(statements, strings.TrimSpace(query[start:]))
+	}
+
+	return statements
+}
+
+// FirstKeyword returns the first word of the statement in upper case.
+// Leading comments and parentheses are skipped.
+func FirstKeyword(statement string) string {
+	for _, t := range tokenize(statement) {
+		if t.kind == tokenWord {
+			return strings.ToUpper(statement[t.start:t.end])
+		}
+		if t.kind != tokenPunct || statement[t.start] != '(' {
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// IsSessionStatement checks whether the statement changes the client session state,
+// e.g. SET changes settings and USE changes the current database.
+func IsSessionStatement(statement string) bool {
+	switch FirstKeyword(statement) {
+	case "SET", "USE":
+		return true
+	default:
+		return false
+	}
+}
+
+// ReturnsRows checks whether the statement returns a result set that can be formatted with a FORMAT clause.
+func ReturnsRows(statement string) bool {
+	switch FirstKeyword(statement) {
+	case "SELECT", "WITH", "SHOW", "DESC", "DESCRIBE", "EXPLAIN", "EXISTS":
+		return true
+	default:
+		return false
+	}
+}
+
+// HasFormatClause checks whether the statement has a top-level FORMAT clause.
+func HasFormatClause(statement string) bool {
+	tokens := tokenize(statement)
+	for i, t := range tokens {
+		if t.kind != tokenWord || t.depth != 0 || !strings.EqualFold(statement[t.start:t.end], "FORMAT") {
+			continue
+		}
+
+		// format(...) is a function call.
+		if i+1 < len(tokens) && tokens[i+1].kind == tokenWord {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serverFunctions are table functions that require a server or external access and functions
+// whose results depend on the server, e.g. its host name, version or current database.
+var serverFunctions = map[string]struct{}{
+	"remote":             {},
+	"remotesecure":       {},
+	"cluster":            {},
+	"clusterallreplicas": {},
+	"url":                {},
+	"s3":                 {},
+	"file":               {},
+	"mysql":              {},
+	"postgresql":         {},
+	"dictionary":         {},
+
+	"hostname":               {},
+	"fqdn":                   {},
+	"displayname":            {},
+	"version":                {},
+	"revision":               {},
+	"buildid":                {},
+	"uptime":                 {},
+	"serveruuid":             {},
+	"tcpport":                {},
+	"getserverport":          {},
+	"getmacro":               {},
+	"shardnum":               {},
+	"shardcount":             {},
+	"currentdatabase":        {},
+	"database":               {},
+	"currentschemas":         {},
+	"currentuser":            {},
+	"user":                   {},
+	"currentprofiles":        {},
+	"currentroles":           {},
+	"enabledroles":           {},
+	"defaultroles":           {},
+	"zookeepersessionuptime": {},
+	"filesystemavailable":    {},
+	"filesystemcapacity":     {},
+	"filesystemunreserved":   {},
+}
+
+// identifier returns the lowercased name of a word or a quoted identifier.
+// String literals are not identifiers, false is returned for them.
+func identifier(stmt string, t token) (string, bool) {
+	text := stmt[t.start:t.end]
+
+	switch {
+	case t.kind == tokenWord:
+		return strings.ToLower(text), true
+
+	case t.kind == tokenLiteral && (text[0] == '`' || text[0] == '"'):
+		if len(text) >= 2 && text[len(text)-1] == text[0] {
+			text = text[1 : len(text)-1]
+		} else {
+			text = text[1:]
+		}
+
+		return strings.ToLower(text), true
+
+	default:
+		return "", false
+	}
+}
+
+// isPunct checks whether the token is the given punctuation character.
+func isPunct(stmt string, t token, punct string) bool {
+	return t.kind == tokenPunct && stmt[t.start:t.end] == punct
+}
+
+// NeedsServer checks whether the query uses features available only on a server,
+// e.g. DDL, system tables, dictionaries, distributed table functions or functions
+// returning properties of the server. Quoted identifiers are checked as well.
+// The check is conservative: only read-only statements without such features can be executed
+// by clickhouse local.
+func NeedsServer(query string) bool {
+	for _, stmt := range SplitStatements(query) {
+		switch FirstKeyword(stmt) {
+		case "SELECT", "WITH", "EXPLAIN", "DESC", "DESCRIBE", "SET":
+		default:
+			return true
+		}
+
+		if usesSystemOrFunction(stmt, isServerFunction) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isServerFunction(name string) bool {
+	_, found := serverFunctions[name]
+	return found || strings.HasPrefix(name, "dictget")
+}
+
+// nondeterministicFunctions are functions whose results differ between executions of the same query,
+// e.g. the current time, random values or properties of the running server.
+var nondeterministicFunctions = map[string]struct{}{
+	"now":               {},
+	"now64":             {},
+	"nowinblock":        {},
+	"today":             {},
+	"yesterday":         {},
+	"current_timestamp": {},
+	"current_date":      {},
+	"utc_timestamp":     {},
+	"utctimestamp":      {},
+	"curdate":           {},
+
+	"canonicalrand":        {},
+	"fuzzbits":             {},
+	"sleep":                {},
+	"sleepeachrow":         {},
+	"blocknumber":          {},
+	"rownumberinblock":     {},
+	"rownumberinallblocks": {},
+
+	"hostname":               {},
+	"fqdn":                   {},
+	"serveruuid":             {},
+	"uptime":                 {},
+	"zookeepersessionuptime": {},
+	"filesystemavailable":    {},
+	"filesystemcapacity":     {},
+	"filesystemunreserved":   {},
+}
+
+// IsNondeterministic checks whether results of the query may differ between executions,
+// e.g. it reads system tables or calls now(), rand(), generateRandom() or sleep().
+// Quoted identifiers are checked as well.
+func IsNondeterministic(query string) bool {
+	for _, stmt := range SplitStatements(query) {
+		if usesSystemOrFunction(stmt, isNondeterministicFunction) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNondeterministicFunction(name string) bool {
+	if _, found := nondeterministicFunctions[name]; found {
+		return true
+	}
+
+	// rand(), randUniform(), randomString(), generateRandom(), generateUUIDv4(), etc.
+	return strings.HasPrefix(name, "rand") || strings.HasPrefix(name, "generate")
+}
+
+// usesSystemOrFunction checks whether the statement reads tables of the system database
+// or calls a function matched by isFunction. Function names are passed lowercased.
+func usesSystemOrFunction(stmt string, isFunction func(name string) bool) bool {
+	tokens := tokenize(stmt)
+	for i, t := range tokens {
+		name, ok := identifier(stmt, t)
+		if !ok {
+			continue
+		}
+
+		var next *token
+		if i+1 < len(tokens) {
+			next = &tokens[i+1]
+		}
+
+		// Tables of the system database: system.tables, `system`.`tables`, "system".tables.
+		if strings.HasPrefix(name, "system.") || (name == "system" && next != nil && isPunct(stmt, *next, ".")) {
+			return true
+		}
+
+		if next != nil && isPunct(stmt, *next, "(") && isFunction(name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package chspec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1;", []string{"SELECT 1"}},
+		{" SELECT 1 ;\n\nSELECT 2; ", []string{"SELECT 1", "SELECT 2"}},
+		{"SELECT ';'; SELECT \"a;b\", `c;d`", []string{"SELECT ';'", "SELECT \"a;b\", `c;d`"}},
+		{"SELECT 'it''s;' ; SELECT 'x\\';'", []string{"SELECT 'it''s;'", "SELECT 'x\\';'"}},
+		{"SELECT 1; -- comment; with semicolon\nSELECT 2", []string{"SELECT 1", "-- comment; with semicolon\nSELECT 2"}},
+		{"SELECT /* ; */ 1;;; /* only comment */;", []string{"SELECT /* ; */ 1"}},
+		{"SELECT 1; -- trailing comment", []string{"SELECT 1"}},
+		{"", nil},
+		{";;", nil},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, SplitStatements(tt.query), tt.query)
+	}
+}
+
+func TestFirstKeyword(t *testing.T) {
+	assert.Equal(t, "SELECT", FirstKeyword("select 1"))
+	assert.Equal(t, "SELECT", FirstKeyword("-- comment\n/* block */ ((select 1))"))
+	assert.Equal(t, "WITH", FirstKeyword("With x AS (SELECT 1) SELECT * FROM x"))
+	assert.Equal(t, "", FirstKeyword("'literal'"))
+	assert.Equal(t, "", FirstKeyword(""))
+}
+
+func TestIsSessionStatement(t *testing.T) {
+	assert.True(t, IsSessionStatement("SET max_threads = 1"))
+	assert.True(t, IsSessionStatement("use db"))
+	assert.False(t, IsSessionStatement("SELECT 1"))
+	assert.False(t, IsSessionStatement("CREATE TABLE settings (x UInt8) ENGINE = Memory"))
+}
+
+func TestReturnsRows(t *testing.T) {
+	assert.True(t, ReturnsRows("SELECT 1"))
+	assert.True(t, ReturnsRows("(SELECT 1) UNION ALL (SELECT 2)"))
+	assert.True(t, ReturnsRows("SHOW TABLES"))
+	assert.True(t, ReturnsRows("describe table t"))
+	assert.False(t, ReturnsRows("INSERT INTO t SELECT 1"))
+	assert.False(t, ReturnsRows("CREATE TABLE t (x UInt8) ENGINE = Memory"))
+}
+
+func TestHasFormatClause(t *testing.T) {
+	assert.True(t, HasFormatClause("SELECT 1 FORMAT JSON"))
+	assert.True(t, HasFormatClause("SELECT 1 SETTINGS max_threads = 1 format Vertical"))
+	assert.False(t, HasFormatClause("SELECT 1"))
+	assert.False(t, HasFormatClause("SELECT format('{} {}', 'a', 'b')"))
+	assert.False(t, HasFormatClause("SELECT 'FORMAT JSON'"))
+	assert.False(t, HasFormatClause("SELECT * FROM (SELECT 1 FORMAT JSON)"))
+	assert.False(t, HasFormatClause("SELECT 1 -- FORMAT JSON"))
+}
+
+func TestNeedsServer(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"SELECT 1", false},
+		{"SET max_threads = 1; SELECT toDate('2024-01-01') + 1 FORMAT JSON", false},
+		{"WITH 1 AS x SELECT x; EXPLAIN SELECT sum(number) FROM numbers(10)", false},
+		{"SELECT 'system.tables', 'remote'", false},
+		{"SELECT * FROM system.tables", true},
+		{"SELECT * FROM remote('127.0.0.1', system.one)", true},
+		{"SELECT dictGet('dict', 'value', 1)", true},
+		{"SELECT hostName()", true},
+		{"CREATE TABLE t (x UInt8) ENGINE = Memory; SELECT * FROM t", true},
+		{"SELECT 1; INSERT INTO t VALUES (1)", true},
+		{"SELECT * FROM `system`.`tables`", true},
+		{`SELECT * FROM "system".tables`, true},
+		{"SELECT * FROM system.`tables`", true},
+		{"SELECT * FROM `system` . `one`", true},
+		{"SELECT currentDatabase()", true},
+		{"SELECT version(), 1", true},
+		{"SELECT `hostName`()", true},
+		{"SELECT count() FROM numbers(10) WHERE number > uptime ()", true},
+		{"SELECT 1 AS version, 'currentDatabase()'", false},
+		{"SELECT `system` FROM (SELECT 1 AS `system`)", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NeedsServer(tt.query), tt.query)
+	}
+}
+
+func TestIsNondeterministic(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"SELECT 1", false},
+		{"CREATE TABLE t (x UInt8) ENGINE = Memory; INSERT INTO t VALUES (1); SELECT * FROM t", false},
+		{"SELECT toDate('2024-01-01') + 1, 'now()', 'rand'", false},
+		{"SELECT 1 AS now, number AS rand FROM numbers(3)", false},
+		{"SELECT now()", true},
+		{"SELECT toStartOfDay(NOW64(3))", true},
+		{"SELECT today() - 1", true},
+		{"SELECT rand(), rand64()", true},
+		{"SELECT randUniform(0, 1)", true},
+		{"SELECT randomString(10)", true},
+		{"SELECT * FROM generateRandom('x UInt8') LIMIT 1", true},
+		{"SELECT generateUUIDv4()", true},
+		{"SELECT sleep(1); SELECT 1", true},
+		{"SELECT count() FROM system.tables", true},
+		{"SELECT * FROM `system`.`one`", true},
+		{"INSERT INTO t SELECT `rand`()", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, IsNondeterministic(tt.query), tt.query)
+	}
+}
@@ -0,0 +1,141 @@
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is a concurrency-safe key-value cache with bounded size.
+//
+// When the cache is full, the least recently used entry is evicted.
+// Entries older than ttl are considered expired and are never returned.
+type Cache[K comparable, V any] struct {
+	mu sync.Mutex
+
+	maxSize int
+	ttl     time.Duration
+
+	items map[K]*list.Element
+	order *list.List // The front element is the most recently used.
+
+	now func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// New creates a cache that keeps at most maxSize entries.
+// If ttl is 0, entries don't expire and are only evicted when the cache is full.
+func New[K comparable, V any](maxSize int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		maxSize: maxSize,
+		ttl:     ttl,
+		items:   make(map[K]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value stored by the key and marks it as recently used.
+func (c *Cache[K, V]) Get(key K) (value V, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.items[key]
+	if !found {
+		return value, false
+	}
+
+	e := elem.Value.(*entry[K, V])
+	if c.isExpired(e) {
+		c.removeElement(elem)
+		return value, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return e.value, true
+}
+
+// Set stores the value by the key. If the cache is full, the least recently used entry is evicted.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = c.now().Add(c.ttl)
+	}
+
+	elem, found := c.items[key]
+	if found {
+		e := elem.Value.(*entry[K, V])
+		e.value = value
+		e.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Delete removes the value stored by the key.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.items[key]
+	if found {
+		c.removeElement(elem)
+	}
+}
+
+// Len returns the number of stored entries including expired ones that haven't been evicted yet.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// RemoveExpired evicts all expired entries and returns how many entries have been removed.
+func (c *Cache[K, V]) RemoveExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var removed int
+	for elem := c.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if c.isExpired(elem.Value.(*entry[K, V])) {
+			c.removeElement(elem)
+			removed++
+		}
+
+		elem = prev
+	}
+
+	return removed
+}
+
+func (c *Cache[K, V]) isExpired(e *entry[K, V]) bool {
+	return !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt)
+}
+
+func (c *Cache[K, V]) removeElement(elem *list.Element) {
+	e := elem.Value.(*entry[K, V])
+	delete(c.items, e.key)
+	c.order.Remove(elem)
+}
@@ -0,0 +1,105 @@
+package lrucache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2, 0)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	_, found := c.Get("a")
+	assert.True(t, found)
+
+	c.Set("c", 3)
+
+	_, found = c.Get("b")
+	assert.False(t, found)
+
+	value, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, value)
+
+	value, found = c.Get("c")
+	assert.True(t, found)
+	assert.Equal(t, 3, value)
+
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Now()
+
+	c := New[string, int](10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(30 * time.Second)
+	c.Set("b", 2)
+
+	_, found := c.Get("a")
+	assert.True(t, found)
+
+	now = now.Add(31 * time.Second)
+
+	_, found = c.Get("a")
+	assert.False(t, found)
+
+	_, found = c.Get("b")
+	assert.True(t, found)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, c.RemoveExpired())
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCache_Overwrite(t *testing.T) {
+	c := New[string, int](2, 0)
+
+	c.Set("a", 1)
+	c.Set("a", 2)
+
+	value, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, 1, c.Len())
+
+	c.Delete("a")
+	_, found = c.Get("a")
+	assert.False(t, found)
+}
+
+func TestCache_Concurrency(t *testing.T) {
+	const workers = 10
+	const iterations = 1000
+	const size = 50
+
+	c := New[string, int](size, time.Minute)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("%d-%d", w, i%100)
+				c.Set(key, i)
+				c.Get(key)
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	assert.LessOrEqual(t, c.Len(), size)
+}
@@ -0,0 +1,184 @@
+package restapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	zlog "github.com/rs/zerolog/log"
+)
+
+// adminHandler manages runners at runtime. Requests must be authenticated with the admin token.
+type adminHandler struct {
+	manager RunnerManager
+	token   string
+}
+
+func newAdminHandler(manager RunnerManager, token string) *adminHandler {
+	return &adminHandler{
+		manager: manager,
+		token:   token,
+	}
+}
+
+func (h *adminHandler) handle(r chi.Router) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(h.authenticate)
+
+		r.Get("/runners", h.getRunners)
+		r.Post("/runners", h.addRunner)
+		r.Delete("/runners/{name}", h.removeRunner)
+		r.Post("/runners/{name}/drain", h.drainRunner)
+		r.Put("/runners/{name}/weight", h.setRunnerWeight)
+	})
+}
+
+func (h *adminHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			writeError(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type RunnerOutput struct {
+	Name           string       `json:"name"`
+	Type           qrunner.Type `json:"type"`
+	Weight         uint         `json:"weight"`
+	MaxConcurrency *uint32      `json:"max_concurrency,omitempty"`
+	Concurrency    uint32       `json:"concurrency"`
+	Alive          bool         `json:"alive"`
+	State          string       `json:"state"`
+}
+
+type GetRunnersOutput struct {
+	Runners []RunnerOutput `json:"runners"`
+}
+
+func (h *adminHandler) getRunners(w http.ResponseWriter, _ *http.Request) {
+	runners := h.manager.Runners()
+
+	out := GetRunnersOutput{Runners: make([]RunnerOutput, 0, len(runners))}
+	for _, r := range runners {
+		out.Runners = append(out.Runners, RunnerOutput{
+			Name:           r.Name,
+			Type:           r.Type,
+			Weight:         r.Weight,
+			MaxConcurrency: r.MaxConcurrency,
+			Concurrency:    r.Concurrency,
+			Alive:          r.Alive,
+			State:          r.State.String(),
+		})
+	}
+
+	writeResult(w, out)
+}
+
+func (h *adminHandler) addRunner(w http.ResponseWriter, r *http.Request) {
+	var spec map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&spec)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.manager.AddRunner(r.Context(), spec)
+	if err != nil {
+		h.writeManagerError(w, err)
+		return
+	}
+
+	writeResult(w, struct{}{})
+}
+
+func (h *adminHandler) removeRunner(w http.ResponseWriter, r *http.Request) {
+	err := h.manager.RemoveRunner(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		h.writeManagerError(w, err)
+		return
+	}
+
+	writeResult(w, struct{}{})
+}
+
+type DrainRunnerInput struct {
+	// Timeout limits how long runs being executed are waited for, e.g. 5m.
+	// If empty, the configured drain timeout is used.
+	Timeout string `json:"timeout"`
+}
+
+func (h *adminHandler) drainRunner(w http.ResponseWriter, r *http.Request) {
+	var req DrainRunnerInput
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var timeout time.Duration
+	if req.Timeout != "" {
+		timeout, err = time.ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 {
+			writeError(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.manager.DrainRunner(chi.URLParam(r, "name"), timeout)
+	if err != nil {
+		h.writeManagerError(w, err)
+		return
+	}
+
+	writeResult(w, struct{}{})
+}
+
+type SetRunnerWeightInput struct {
+	Weight uint `json:"weight"`
+}
+
+func (h *adminHandler) setRunnerWeight(w http.ResponseWriter, r *http.Request) {
+	var req SetRunnerWeightInput
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.manager.SetRunnerWeight(chi.URLParam(r, "name"), req.Weight)
+	if err != nil {
+		h.writeManagerError(w, err)
+		return
+	}
+
+	writeResult(w, struct{}{})
+}
+
+func (h *adminHandler) writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, coordinator.ErrRunnerNotFound):
+		writeError(w, "runner not found", http.StatusNotFound)
+
+	case errors.Is(err, coordinator.ErrRunnerAlreadyExists):
+		writeError(w, "runner already exists", http.StatusConflict)
+
+	case errors.Is(err, ErrInvalidRunner), errors.Is(err, coordinator.ErrInvalidWeight):
+		writeError(w, err.Error(), http.StatusBadRequest)
+
+	default:
+		zlog.Error().Err(err).Msg("runner cannot be changed")
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,123 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "admin-secret"
+
+// runnerManagerMock creates stub runners named after the spec.
+type runnerManagerMock struct {
+	*coordinator.Coordinator
+}
+
+func (m runnerManagerMock) AddRunner(_ context.Context, spec map[string]interface{}) error {
+	name, _ := spec["name"].(string)
+	if name == "" {
+		return ErrInvalidRunner
+	}
+
+	return m.Coordinator.AddRunner(coordinator.NewRunner(stubrunner.New(context.Background(), name, echoRun), coordinator.DefaultWeight, nil))
+}
+
+func newAdminTestServer(t *testing.T) http.Handler {
+	coord := coordinator.New(context.Background(), zerolog.Nop(), []*coordinator.Runner{
+		coordinator.NewRunner(stubrunner.New(context.Background(), "stub", echoRun), coordinator.DefaultWeight, nil),
+	}, coordinator.Config{HealthChecksEnabled: true, HealthCheckRetryDelay: time.Second})
+	require.NoError(t, coord.Start())
+	t.Cleanup(func() {
+		assert.NoError(t, coord.Stop(context.Background()))
+	})
+
+	return NewRouter(RouterOpts{
+		Logger:        zerolog.Nop(),
+		Runner:        coord,
+		TagStorage:    tagStorageMock{},
+		RunRepo:       queryrun.NewMemoryRepository(100, 0),
+		Timeout:       10 * time.Second,
+		RunnerManager: runnerManagerMock{coord},
+		AdminToken:    testAdminToken,
+	})
+}
+
+func doAdmin(t *testing.T, handler http.Handler, token, method, path string, body any, out any) (int, *ErrorResponse) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &reqBody)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	resp := Response{Result: out}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	return rec.Code, resp.Error
+}
+
+func TestAdminHandler_Runners(t *testing.T) {
+	h := newAdminTestServer(t)
+
+	code, _ := doAdmin(t, h, "invalid", http.MethodGet, "/api/admin/runners", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// The token without the Bearer scheme is rejected.
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/runners", nil)
+	req.Header.Set("Authorization", testAdminToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	code, respErr := doAdmin(t, h, testAdminToken, http.MethodPost, "/api/admin/runners", map[string]any{"name": "worker"}, nil)
+	require.Equal(t, http.StatusOK, code, respErr)
+
+	code, _ = doAdmin(t, h, testAdminToken, http.MethodPost, "/api/admin/runners", map[string]any{"name": "worker"}, nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, _ = doAdmin(t, h, testAdminToken, http.MethodPost, "/api/admin/runners", map[string]any{}, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, respErr = doAdmin(t, h, testAdminToken, http.MethodPut, "/api/admin/runners/worker/weight", SetRunnerWeightInput{Weight: 300}, nil)
+	require.Equal(t, http.StatusOK, code, respErr)
+
+	code, _ = doAdmin(t, h, testAdminToken, http.MethodPut, "/api/admin/runners/worker/weight", SetRunnerWeightInput{Weight: 0}, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = doAdmin(t, h, testAdminToken, http.MethodPost, "/api/admin/runners/worker/drain", DrainRunnerInput{Timeout: "soon"}, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, respErr = doAdmin(t, h, testAdminToken, http.MethodPost, "/api/admin/runners/worker/drain", DrainRunnerInput{Timeout: "1m"}, nil)
+	require.Equal(t, http.StatusOK, code, respErr)
+
+	var out GetRunnersOutput
+	code, respErr = doAdmin(t, h, testAdminToken, http.MethodGet, "/api/admin/runners", nil, &out)
+	require.Equal(t, http.StatusOK, code, respErr)
+	require.Len(t, out.Runners, 2)
+	assert.Equal(t, "stub", out.Runners[0].Name)
+	assert.Equal(t, "ACTIVE", out.Runners[0].State)
+	assert.Equal(t, "worker", out.Runners[1].Name)
+	assert.Equal(t, uint(300), out.Runners[1].Weight)
+	assert.NotEqual(t, "ACTIVE", out.Runners[1].State)
+
+	code, respErr = doAdmin(t, h, testAdminToken, http.MethodDelete, "/api/admin/runners/worker", nil, nil)
+	require.Equal(t, http.StatusOK, code, respErr)
+
+	code, _ = doAdmin(t, h, testAdminToken, http.MethodDelete, "/api/admin/runners/worker", nil, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,330 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/bisect"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/lrucache"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	// bisectionTimeout limits the execution time of a bisection.
+	bisectionTimeout = 30 * time.Minute
+
+	// maxRunningBisections limits the number of bisections executed simultaneously.
+	maxRunningBisections = 10
+
+	// Finished bisections are kept in memory, they can be retrieved until they are evicted.
+	maxStoredBisections = 1000
+	bisectionTTL        = 24 * time.Hour
+)
+
+type bisectionHandler struct {
+	*queryHandler
+
+	// ctx is the parent context of bisections executed in background.
+	ctx context.Context
+
+	running    int32
+	bisections *lrucache.Cache[string, *bisection]
+}
+
+func newBisectionHandler(ctx context.Context, qh *queryHandler) *bisectionHandler {
+	return &bisectionHandler{
+		queryHandler: qh,
+		ctx:          ctx,
+		bisections:   lrucache.New[string, *bisection](maxStoredBisections, bisectionTTL),
+	}
+}
+
+func (h *bisectionHandler) handle(r chi.Router) {
+	r.Post("/bisections", h.bisect)
+	r.Get("/bisections/{id}", h.getBisection)
+}
+
+type BisectInput struct {
+	Query       string `json:"query"`
+	GoodVersion string `json:"good_version"`
+	BadVersion  string `json:"bad_version"`
+
+	// If ExpectedOutput is set, a version is good when its output equals the expected one.
+	// Otherwise, a version is good when it behaves as the good version.
+	ExpectedOutput *string `json:"expected_output,omitempty"`
+
+	Database string             `json:"database"`
+	Settings RunSettings        `json:"settings"`
+	Datasets []string           `json:"datasets,omitempty"`
+	Files    []InputFile        `json:"files,omitempty"`
+	Topology *queryrun.Topology `json:"topology,omitempty"`
+	Mode     string             `json:"mode,omitempty"`
+}
+
+type BisectOutput struct {
+	BisectionID string `json:"bisection_id"`
+
+	// Status is RUNNING, SUCCEEDED or FAILED.
+	Status        queryrun.Status `json:"status"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+
+	LastGoodVersion string       `json:"last_good_version,omitempty"`
+	FirstBadVersion string       `json:"first_bad_version,omitempty"`
+	Steps           []BisectStep `json:"steps,omitempty"`
+}
+
+type BisectStep struct {
+	Version       string          `json:"version"`
+	QueryRunID    string          `json:"query_run_id"`
+	Status        queryrun.Status `json:"status"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	Good          bool            `json:"good"`
+	Output        string          `json:"output"`
+}
+
+// bisection is the state of a bisection executed in background.
+type bisection struct {
+	mu  sync.Mutex
+	out BisectOutput
+}
+
+func (b *bisection) get() BisectOutput {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.out
+}
+
+func (b *bisection) finish(out BisectOutput) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.out = out
+}
+
+// bisect validates the request and starts searching the first version where the query behavior diverged.
+// The bisection is executed in background, its result can be retrieved via the get endpoint.
+//
+// Versions between the good and the bad ones are taken in the order the tag storage returns them.
+// Each checked version is saved as a run linked by the bisection id.
+func (h *bisectionHandler) bisect(w http.ResponseWriter, r *http.Request) {
+	var req BisectInput
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.BadVersion == "" {
+		writeError(w, "bad_version is required", http.StatusBadRequest)
+		return
+	}
+
+	// Validate the request, the run of the good version is executed first.
+	goodRun, ok := h.newRun(w, &RunQueryInput{
+		Query:    req.Query,
+		Version:  req.GoodVersion,
+		Database: req.Database,
+		Settings: req.Settings,
+		Datasets: req.Datasets,
+		Files:    req.Files,
+		Topology: req.Topology,
+		Mode:     req.Mode,
+	})
+	if !ok {
+		return
+	}
+
+	versions, err := bisect.Candidates(h.tagStorage.GetAll(), req.GoodVersion, req.BadVersion)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if atomic.AddInt32(&h.running, 1) > maxRunningBisections {
+		atomic.AddInt32(&h.running, -1)
+		writeError(w, "too many running bisections, try again later", http.StatusTooManyRequests)
+
+		return
+	}
+
+	bisectionID := uuid.New().String()
+	goodRun.GroupID = bisectionID
+	goodRun.Version = versions[0]
+
+	b := &bisection{
+		out: BisectOutput{
+			BisectionID: bisectionID,
+			Status:      queryrun.StatusRunning,
+		},
+	}
+	h.bisections.Set(bisectionID, b)
+
+	go func() {
+		defer atomic.AddInt32(&h.running, -1)
+
+		ctx, cancel := context.WithTimeout(h.ctx, bisectionTimeout)
+		defer cancel()
+
+		b.finish(h.search(ctx, bisectionID, versions, newBisectionChecker(h.queryHandler, goodRun, req.ExpectedOutput)))
+	}()
+
+	zlog.Info().Str("bisection_id", bisectionID).Int("versions", len(versions)).Msg("started a new bisection")
+
+	writeResult(w, b.get())
+}
+
+// search executes the bisection and returns its final state.
+func (h *bisectionHandler) search(ctx context.Context, bisectionID string, versions []string, checker *bisectionChecker) BisectOutput {
+	out := BisectOutput{
+		BisectionID: bisectionID,
+		Status:      queryrun.StatusSucceeded,
+	}
+
+	result, err := bisect.Search(ctx, versions, checker.check)
+	if err != nil {
+		if !errors.Is(err, bisect.ErrGoodIsBad) && !errors.Is(err, bisect.ErrBadIsGood) {
+			zlog.Error().Err(err).Str("bisection_id", bisectionID).Msg("bisection failed")
+		}
+
+		// Runs of checked versions are saved, they can be found by the bisection id.
+		out.Status = queryrun.StatusFailed
+		out.FailureReason = err.Error()
+	}
+
+	out.LastGoodVersion = result.LastGood
+	out.FirstBadVersion = result.FirstBad
+	for _, step := range result.Steps {
+		run := checker.run(step.Version)
+		out.Steps = append(out.Steps, BisectStep{
+			Version:       step.Version,
+			QueryRunID:    run.ID,
+			Status:        run.Status,
+			FailureReason: run.FailureReason,
+			Good:          step.Good,
+			Output:        run.Output,
+		})
+	}
+
+	zlog.Info().
+		Str("bisection_id", bisectionID).
+		Str("status", string(out.Status)).
+		Str("first_bad", result.FirstBad).
+		Int("steps", len(result.Steps)).
+		Msg("bisection has been finished")
+
+	return out
+}
+
+func (h *bisectionHandler) getBisection(w http.ResponseWriter, r *http.Request) {
+	b, found := h.bisections.Get(chi.URLParam(r, "id"))
+	if !found {
+		writeError(w, "bisection not found", http.StatusNotFound)
+		return
+	}
+
+	writeResult(w, b.get())
+}
+
+// bisectionChecker executes and saves runs of the bisected query.
+type bisectionChecker struct {
+	h        *queryHandler
+	template *queryrun.Run
+	expected *string
+
+	mu   sync.Mutex
+	runs map[string]*queryrun.Run
+}
+
+func newBisectionChecker(h *queryHandler, goodRun *queryrun.Run, expected *string) *bisectionChecker {
+	return &bisectionChecker{
+		h:        h,
+		template: goodRun,
+		expected: expected,
+		runs: map[string]*queryrun.Run{
+			goodRun.Version: goodRun,
+		},
+	}
+}
+
+func (c *bisectionChecker) check(ctx context.Context, version string) (bool, error) {
+	run, err := c.execute(ctx, version)
+	if err != nil {
+		return false, err
+	}
+
+	if c.expected != nil {
+		return run.Status == queryrun.StatusSucceeded && run.Output == *c.expected, nil
+	}
+
+	goodRun, err := c.execute(ctx, c.template.Version)
+	if err != nil {
+		return false, err
+	}
+
+	return sameBehavior(goodRun, run), nil
+}
+
+// sameBehavior checks whether runs of different versions have the same outcome:
+// either both have succeeded with the same output, or both have failed for the same reason.
+func sameBehavior(a, b *queryrun.Run) bool {
+	if a.Status != b.Status {
+		return false
+	}
+	if a.Status == queryrun.StatusFailed {
+		return a.FailureReason == b.FailureReason
+	}
+
+	return a.Output == b.Output
+}
+
+// execute runs the query against the version once, the following calls return the saved run.
+// Failed runs are results of the version, only cancelled runs are reported as errors.
+func (c *bisectionChecker) execute(ctx context.Context, version string) (*queryrun.Run, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, found := c.runs[version]
+	if !found {
+		run = queryrun.New(c.template.Input, c.template.Database, version, c.template.Settings)
+		run.GroupID = c.template.GroupID
+		run.Datasets = c.template.Datasets
+		run.Files = c.template.Files
+		run.Topology = c.template.Topology
+		run.Mode = c.template.Mode
+		c.runs[version] = run
+	}
+
+	if run.Status == "" {
+		err := c.h.executeRun(ctx, run)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save the run")
+		}
+	}
+
+	// The run may have failed because the bisection has timed out.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if run.Status == queryrun.StatusCancelled {
+		return nil, errors.Errorf("run %s has been cancelled", run.ID)
+	}
+
+	return run, nil
+}
+
+func (c *bisectionChecker) run(version string) *queryrun.Run {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.runs[version]
+}
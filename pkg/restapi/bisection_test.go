@@ -0,0 +1,184 @@
+package restapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/bisect"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// changedSinceRun returns "new" for 23.1 and newer versions and records executed versions.
+func changedSinceRun() (func(ctx context.Context, run *queryrun.Run) (string, error), func() []string) {
+	var mu sync.Mutex
+	var versions []string
+
+	run := func(_ context.Context, run *queryrun.Run) (string, error) {
+		mu.Lock()
+		versions = append(versions, run.Version)
+		mu.Unlock()
+
+		switch run.Version {
+		case "22.8", "22.12":
+			return "old", nil
+		default:
+			return "new", nil
+		}
+	}
+
+	executed := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), versions...)
+	}
+
+	return run, executed
+}
+
+// bisect starts the bisection and waits until it's finished.
+func (s *testServer) bisect(input BisectInput) BisectOutput {
+	var out BisectOutput
+	code, respErr := s.do(http.MethodPost, "/api/bisections", input, &out)
+	require.Equal(s.t, http.StatusOK, code)
+	require.Nil(s.t, respErr)
+	require.NotEmpty(s.t, out.BisectionID)
+
+	require.Eventually(s.t, func() bool {
+		code, respErr = s.do(http.MethodGet, "/api/bisections/"+out.BisectionID, nil, &out)
+		require.Equal(s.t, http.StatusOK, code, respErr)
+
+		return out.Status != queryrun.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return out
+}
+
+func TestBisectionHandler_OutputChanged(t *testing.T) {
+	run, executed := changedSinceRun()
+	s := newTestServer(t, run)
+
+	out := s.bisect(BisectInput{
+		Query:       "SELECT 1",
+		GoodVersion: "22.8",
+		BadVersion:  "23.3",
+	})
+
+	assert.Equal(t, queryrun.StatusSucceeded, out.Status)
+	assert.Equal(t, "22.12", out.LastGoodVersion)
+	assert.Equal(t, "23.1", out.FirstBadVersion)
+
+	// Every version is executed once.
+	assert.Len(t, executed(), len(out.Steps))
+	assert.Equal(t, "22.8", out.Steps[0].Version)
+	assert.True(t, out.Steps[0].Good)
+	assert.Equal(t, "23.3", out.Steps[1].Version)
+	assert.False(t, out.Steps[1].Good)
+
+	for _, step := range out.Steps {
+		saved, err := s.repo.Get(step.QueryRunID)
+		require.NoError(t, err)
+
+		assert.Equal(t, out.BisectionID, saved.GroupID)
+		assert.Equal(t, step.Version, saved.Version)
+		assert.Equal(t, step.Output, saved.Output)
+	}
+}
+
+func TestBisectionHandler_ExpectedOutput(t *testing.T) {
+	run, _ := changedSinceRun()
+	s := newTestServer(t, run)
+
+	// Looking for the version that fixed the output.
+	expected := "new"
+	out := s.bisect(BisectInput{
+		Query:          "SELECT 1",
+		GoodVersion:    "23.2",
+		BadVersion:     "22.8",
+		ExpectedOutput: &expected,
+	})
+
+	assert.Equal(t, queryrun.StatusSucceeded, out.Status)
+	assert.Equal(t, "23.1", out.LastGoodVersion)
+	assert.Equal(t, "22.12", out.FirstBadVersion)
+}
+
+func TestBisectionHandler_InvalidEndpoints(t *testing.T) {
+	run, _ := changedSinceRun()
+	s := newTestServer(t, run)
+
+	expected := "old"
+	out := s.bisect(BisectInput{
+		Query:          "SELECT 1",
+		GoodVersion:    "23.3",
+		BadVersion:     "22.8",
+		ExpectedOutput: &expected,
+	})
+	assert.Equal(t, queryrun.StatusFailed, out.Status)
+	assert.Contains(t, out.FailureReason, bisect.ErrGoodIsBad.Error())
+	require.Len(t, out.Steps, 1)
+	assert.False(t, out.Steps[0].Good)
+
+	out = s.bisect(BisectInput{
+		Query:       "SELECT 1",
+		GoodVersion: "23.3",
+		BadVersion:  "23.1",
+	})
+	assert.Equal(t, queryrun.StatusFailed, out.Status)
+	assert.Contains(t, out.FailureReason, bisect.ErrBadIsGood.Error())
+}
+
+func TestBisectionHandler_FailedRunsAreBad(t *testing.T) {
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		if run.Version == "23.2" || run.Version == "23.3" {
+			return "", qrunner.ErrMemoryLimitExceeded
+		}
+
+		return "ok", nil
+	})
+
+	out := s.bisect(BisectInput{
+		Query:       "SELECT 1",
+		GoodVersion: "22.8",
+		BadVersion:  "23.3",
+	})
+	assert.Equal(t, queryrun.StatusSucceeded, out.Status)
+	assert.Equal(t, "23.1", out.LastGoodVersion)
+	assert.Equal(t, "23.2", out.FirstBadVersion)
+
+	// The bad version has failed, it's reported as a step.
+	assert.Equal(t, queryrun.StatusFailed, out.Steps[1].Status)
+	assert.False(t, out.Steps[1].Good)
+}
+
+func TestBisectionHandler_NotFound(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	code, _ := s.do(http.MethodGet, "/api/bisections/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestBisectionHandler_Validation(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	tests := []BisectInput{
+		{Query: "SELECT 1", GoodVersion: "23.3"},
+		{Query: "SELECT 1", GoodVersion: "unknown", BadVersion: "23.3"},
+		{Query: "SELECT 1", GoodVersion: "23.3", BadVersion: "unknown"},
+		{Query: "SELECT 1", GoodVersion: "23.3", BadVersion: "23.3"},
+		{Query: "", GoodVersion: "22.8", BadVersion: "23.3"},
+	}
+
+	for _, input := range tests {
+		code, respErr := s.do(http.MethodPost, "/api/bisections", input, nil)
+		assert.Equal(t, http.StatusBadRequest, code, input)
+		assert.NotNil(t, respErr, input)
+	}
+}
@@ -0,0 +1,195 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/textdiff"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const DefaultMaxComparedVersions = 10
+
+type comparisonHandler struct {
+	*queryHandler
+
+	maxVersions int
+}
+
+func newComparisonHandler(qh *queryHandler, maxVersions int) *comparisonHandler {
+	if maxVersions <= 0 {
+		maxVersions = DefaultMaxComparedVersions
+	}
+
+	return &comparisonHandler{
+		queryHandler: qh,
+		maxVersions:  maxVersions,
+	}
+}
+
+func (h *comparisonHandler) handle(r chi.Router) {
+	r.Post("/comparisons", h.compare)
+}
+
+type CompareInput struct {
+	Query    string             `json:"query"`
+	Versions []string           `json:"versions"`
+	Database string             `json:"database"`
+	Settings RunSettings        `json:"settings"`
+	Datasets []string           `json:"datasets,omitempty"`
+	Files    []InputFile        `json:"files,omitempty"`
+	Topology *queryrun.Topology `json:"topology,omitempty"`
+	Mode     string             `json:"mode,omitempty"`
+}
+
+type CompareOutput struct {
+	ComparisonID string             `json:"comparison_id"`
+	Identical    bool               `json:"identical"`
+	Results      []ComparisonResult `json:"results"`
+	Diffs        []ComparisonDiff   `json:"diffs"`
+}
+
+type ComparisonResult struct {
+	Version       string          `json:"version"`
+	QueryRunID    string          `json:"query_run_id"`
+	Status        queryrun.Status `json:"status"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	Output        string          `json:"output"`
+	TimeElapsed   string          `json:"time_elapsed"`
+
+	QueryError *queryrun.QueryError `json:"query_error,omitempty"`
+}
+
+// ComparisonDiff is the difference between outputs of the first version and another version.
+type ComparisonDiff struct {
+	BaseVersion string `json:"base_version"`
+	Version     string `json:"version"`
+	Diff        string `json:"diff"`
+}
+
+// compare runs the query against several versions in parallel and compares outputs.
+//
+// Every version is executed as a separate run. Runs are linked together by the comparison id
+// and saved even if they fail. Outputs are compared with the output of the first version.
+func (h *comparisonHandler) compare(w http.ResponseWriter, r *http.Request) {
+	var req CompareInput
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Versions) < 2 {
+		writeError(w, "at least 2 versions are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Versions) > h.maxVersions {
+		writeError(w, fmt.Sprintf("versions count (%d) cannot exceed %d", len(req.Versions), h.maxVersions), http.StatusBadRequest)
+		return
+	}
+
+	comparisonID := uuid.New().String()
+	seen := make(map[string]struct{}, len(req.Versions))
+	runs := make([]*queryrun.Run, 0, len(req.Versions))
+	for _, version := range req.Versions {
+		if _, exists := seen[version]; exists {
+			writeError(w, fmt.Sprintf("version %s is duplicated", version), http.StatusBadRequest)
+			return
+		}
+		seen[version] = struct{}{}
+
+		run, ok := h.newRun(w, &RunQueryInput{
+			Query:    req.Query,
+			Version:  version,
+			Database: req.Database,
+			Settings: req.Settings,
+			Datasets: req.Datasets,
+			Files:    req.Files,
+			Topology: req.Topology,
+			Mode:     req.Mode,
+		})
+		if !ok {
+			return
+		}
+
+		run.GroupID = comparisonID
+		runs = append(runs, run)
+	}
+
+	err = h.executeRuns(r.Context(), runs)
+	if err != nil {
+		zlog.Error().Err(err).Str("comparison_id", comparisonID).Msg("comparison runs cannot be saved")
+		writeError(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	out := CompareOutput{
+		ComparisonID: comparisonID,
+		Identical:    true,
+		Results:      make([]ComparisonResult, 0, len(runs)),
+		Diffs:        make([]ComparisonDiff, 0),
+	}
+
+	base := runs[0]
+	for _, run := range runs {
+		out.Results = append(out.Results, ComparisonResult{
+			Version:       run.Version,
+			QueryRunID:    run.ID,
+			Status:        run.Status,
+			FailureReason: run.FailureReason,
+			Output:        run.Output,
+			TimeElapsed:   run.ExecutionTime.Round(time.Millisecond).String(),
+			QueryError:    run.QueryError,
+		})
+
+		if run.Status != base.Status || run.Output != base.Output {
+			out.Identical = false
+		}
+		if run.Output != base.Output {
+			out.Diffs = append(out.Diffs, ComparisonDiff{
+				BaseVersion: base.Version,
+				Version:     run.Version,
+				Diff:        textdiff.Unified(base.Version, run.Version, base.Output, run.Output),
+			})
+		}
+	}
+
+	zlog.Info().Str("comparison_id", comparisonID).Int("versions", len(runs)).Msg("saved a new comparison")
+
+	writeResult(w, out)
+}
+
+// executeRuns executes runs simultaneously and saves them.
+// Parallelism is limited by runners: when all of them are busy, runs wait until a runner is released.
+func (h *comparisonHandler) executeRuns(ctx context.Context, runs []*queryrun.Run) error {
+	errs := make([]error, len(runs))
+
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		wg.Add(1)
+		go func(i int, run *queryrun.Run) {
+			defer wg.Done()
+
+			errs[i] = h.executeRun(ctx, run)
+		}(i, run)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
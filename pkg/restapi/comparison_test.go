@@ -0,0 +1,102 @@
+package restapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func versionedRun(_ context.Context, run *queryrun.Run) (string, error) {
+	switch run.Version {
+	case "head":
+		return "", errors.New("runner failed")
+	case "23.3":
+		return "1\n2\n3\n", nil
+	default:
+		return "1\ntwo\n3\n", nil
+	}
+}
+
+func TestComparisonHandler_Compare(t *testing.T) {
+	s := newTestServer(t, versionedRun)
+
+	var out CompareOutput
+	code, respErr := s.do(http.MethodPost, "/api/comparisons", CompareInput{
+		Query:    "SELECT 1",
+		Versions: []string{"22.8", "23.3", "head"},
+	}, &out)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	assert.NotEmpty(t, out.ComparisonID)
+	assert.False(t, out.Identical)
+	require.Len(t, out.Results, 3)
+
+	assert.Equal(t, "22.8", out.Results[0].Version)
+	assert.Equal(t, queryrun.StatusSucceeded, out.Results[0].Status)
+	assert.Equal(t, "1\ntwo\n3\n", out.Results[0].Output)
+
+	assert.Equal(t, "23.3", out.Results[1].Version)
+	assert.Equal(t, queryrun.StatusSucceeded, out.Results[1].Status)
+
+	assert.Equal(t, "head", out.Results[2].Version)
+	assert.Equal(t, queryrun.StatusFailed, out.Results[2].Status)
+	assert.Equal(t, "internal error", out.Results[2].FailureReason)
+
+	require.Len(t, out.Diffs, 2)
+	assert.Equal(t, ComparisonDiff{
+		BaseVersion: "22.8",
+		Version:     "23.3",
+		Diff:        "--- 22.8\n+++ 23.3\n@@ -1,3 +1,3 @@\n 1\n-two\n+2\n 3\n",
+	}, out.Diffs[0])
+	assert.Equal(t, "head", out.Diffs[1].Version)
+
+	// All runs are saved and linked to the comparison.
+	for _, result := range out.Results {
+		run, err := s.repo.Get(result.QueryRunID)
+		require.NoError(t, err)
+
+		assert.Equal(t, out.ComparisonID, run.GroupID)
+		assert.Equal(t, result.Version, run.Version)
+		assert.Equal(t, result.Status, run.Status)
+	}
+}
+
+func TestComparisonHandler_Identical(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	var out CompareOutput
+	code, respErr := s.do(http.MethodPost, "/api/comparisons", CompareInput{
+		Query:    "SELECT 1",
+		Versions: []string{"22.8", "23.3"},
+	}, &out)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	assert.True(t, out.Identical)
+	assert.Len(t, out.Results, 2)
+	assert.Empty(t, out.Diffs)
+}
+
+func TestComparisonHandler_Validation(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	tests := []CompareInput{
+		{Query: "SELECT 1", Versions: []string{"23.3"}},
+		{Query: "SELECT 1", Versions: []string{"23.3", "23.3"}},
+		{Query: "SELECT 1", Versions: []string{"23.3", "unknown"}},
+		{Query: "", Versions: []string{"23.3", "22.8"}},
+	}
+
+	for _, input := range tests {
+		code, respErr := s.do(http.MethodPost, "/api/comparisons", input, nil)
+		assert.Equal(t, http.StatusBadRequest, code, input)
+		assert.NotNil(t, respErr, input)
+	}
+}
@@ -2,8 +2,12 @@ package restapi
 
 import (
 	"context"
+	"time"
 
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
 	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/coordinator"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
 )
 
@@ -14,4 +18,32 @@ type TagStorage interface {
 
 type QueryRunner interface {
 	RunQuery(ctx context.Context, run *queryrun.Run) (string, error)
+	RunQueryStream(ctx context.Context, run *queryrun.Run, onOutput qrunner.OutputHandler) (string, error)
+	CancelRun(ctx context.Context, runID string) (string, error)
+}
+
+type AsyncRunner interface {
+	// Submit saves the run as queued and schedules its execution.
+	Submit(run *queryrun.Run) error
+
+	// Cancel stops a queued or running run and waits until it's saved as cancelled.
+	Cancel(ctx context.Context, runID string) error
+}
+
+type DatasetRegistry interface {
+	Get(name string) (dataset.Dataset, bool)
+}
+
+// RunnerManager changes the set of runners at runtime.
+type RunnerManager interface {
+	Runners() []coordinator.RunnerInfo
+
+	// AddRunner creates and starts a runner described in the same format as in the runners config section.
+	AddRunner(ctx context.Context, spec map[string]interface{}) error
+
+	// DrainRunner stops the runner in the background after its runs are finished or the timeout expires.
+	// If the timeout is 0, the configured drain timeout is used.
+	DrainRunner(name string, timeout time.Duration) error
+	RemoveRunner(ctx context.Context, name string) error
+	SetRunnerWeight(name string, weight uint) error
 }
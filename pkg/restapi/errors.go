@@ -4,3 +4,4 @@ import "github.com/pkg/errors"
 
 var ErrUnknownDatabase = errors.New("unknown database")
 var ErrMissingRunSettings = errors.New("missing run settings")
+var ErrInvalidRunner = errors.New("invalid runner")
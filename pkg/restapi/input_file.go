@@ -0,0 +1,131 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+)
+
+// Multipart form field names of a run request.
+const (
+	multipartRequestField = "request"
+	multipartFilesField   = "files"
+)
+
+var fileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+
+// FileLimits restricts files uploaded with a run.
+type FileLimits struct {
+	MaxFiles int
+
+	// MaxTotalSize is the max total size of files in bytes.
+	MaxTotalSize uint64
+}
+
+// InputFile is a file the query can read with the file() table function.
+type InputFile struct {
+	Name string `json:"name"`
+
+	// Content is base64-encoded in JSON requests.
+	Content []byte `json:"content"`
+}
+
+// convertFiles validates uploaded files and converts them to run input files.
+func (l FileLimits) convertFiles(files []InputFile) ([]queryrun.InputFile, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if len(files) > l.MaxFiles {
+		return nil, errors.Errorf("number of files (%d) cannot exceed %d", len(files), l.MaxFiles)
+	}
+
+	var totalSize uint64
+	converted := make([]queryrun.InputFile, 0, len(files))
+	seen := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		if !fileNameRegexp.MatchString(f.Name) {
+			return nil, errors.Errorf("invalid file name %q", f.Name)
+		}
+		if _, exists := seen[f.Name]; exists {
+			return nil, errors.Errorf("file %s is duplicated", f.Name)
+		}
+		seen[f.Name] = struct{}{}
+
+		totalSize += uint64(len(f.Content))
+		converted = append(converted, queryrun.NewInputFile(f.Name, f.Content))
+	}
+
+	if totalSize > l.MaxTotalSize {
+		return nil, errors.Errorf("total size of files (%d) cannot exceed %d bytes", totalSize, l.MaxTotalSize)
+	}
+
+	return converted, nil
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// decodeMultipartRun parses a run request sent as a multipart form.
+// The request field contains the JSON request, every files field is an uploaded file.
+func (l FileLimits) decodeMultipartRun(r *http.Request, req *RunQueryInput) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	var hasRequest bool
+	var totalSize uint64
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "invalid multipart form")
+		}
+
+		switch part.FormName() {
+		case multipartRequestField:
+			err = json.NewDecoder(part).Decode(req)
+			if err != nil {
+				return errors.Wrap(err, "invalid request field")
+			}
+			hasRequest = true
+
+		case multipartFilesField:
+			// Read no more than the limit to reject large files without buffering them.
+			content, err := io.ReadAll(io.LimitReader(part, int64(l.MaxTotalSize-totalSize)+1))
+			if err != nil {
+				return errors.Wrapf(err, "failed to read file %s", part.FileName())
+			}
+
+			totalSize += uint64(len(content))
+			if totalSize > l.MaxTotalSize {
+				return errors.Errorf("total size of files cannot exceed %d bytes", l.MaxTotalSize)
+			}
+
+			req.Files = append(req.Files, InputFile{
+				Name:    part.FileName(),
+				Content: content,
+			})
+
+		default:
+			return errors.Errorf("unknown form field %s", part.FormName())
+		}
+	}
+
+	if !hasRequest {
+		return errors.Errorf("missed %s field", multipartRequestField)
+	}
+
+	return nil
+}
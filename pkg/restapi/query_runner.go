@@ -1,14 +1,21 @@
 package restapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
+	"unicode/utf8"
 
+	"github.com/lodthe/clickhouse-playground/internal/asyncrun"
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbdriver"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
 	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
 	"github.com/lodthe/clickhouse-playground/internal/qrunner"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
@@ -16,32 +23,65 @@ import (
 )
 
 const (
-	ClickHouseDatabase = "clickhouse"
+	ClickHouseDatabase = string(dbsettings.TypeClickHouse)
+)
+
+// Execution modes of a run request.
+const (
+	ModeServer = string(queryrun.ModeServer)
+	ModeLocal  = string(queryrun.ModeLocal)
+
+	// ModeAuto executes the query without a server if it doesn't need server features.
+	ModeAuto = "AUTO"
 )
 
 type queryHandler struct {
 	r       QueryRunner
+	async   AsyncRunner
 	runRepo queryrun.Repository
 
 	tagStorage TagStorage
 
 	maxQueryLength  uint64
 	maxOutputLength uint64
+
+	settingsPolicy chspec.SettingsPolicy
+	datasets       DatasetRegistry
+	fileLimits     FileLimits
+
+	// maxClusterNodes limits the number of servers in a requested topology, clusters are disabled if it's 0.
+	maxClusterNodes int
+
+	// defaultMode is used if a request has no mode.
+	defaultMode string
 }
 
-func newQueryHandler(r QueryRunner, runRepo queryrun.Repository, storage TagStorage, maxQueryLength, maxOutputLength uint64) *queryHandler {
+func newQueryHandler(r QueryRunner, async AsyncRunner, runRepo queryrun.Repository, storage TagStorage, maxQueryLength, maxOutputLength uint64,
+	settingsPolicy chspec.SettingsPolicy, datasets DatasetRegistry, fileLimits FileLimits, maxClusterNodes int, defaultMode string) *queryHandler {
+	if defaultMode == "" {
+		defaultMode = ModeServer
+	}
+
 	return &queryHandler{
 		r:               r,
+		async:           async,
 		runRepo:         runRepo,
 		tagStorage:      storage,
 		maxQueryLength:  maxQueryLength,
 		maxOutputLength: maxOutputLength,
+		settingsPolicy:  settingsPolicy,
+		datasets:        datasets,
+		fileLimits:      fileLimits,
+		maxClusterNodes: maxClusterNodes,
+		defaultMode:     defaultMode,
 	}
 }
 
 func (h *queryHandler) handle(r chi.Router) {
 	r.Post("/runs", h.runQuery)
+	r.Post("/runs/stream", h.runQueryStream)
 	r.Get("/runs/{id}", h.getQueryRun)
+	r.Delete("/runs/{id}", h.cancelQueryRun)
 }
 
 type RunQueryInput struct {
@@ -49,64 +89,178 @@ type RunQueryInput struct {
 	Version  string      `json:"version"`
 	Database string      `json:"database"`
 	Settings RunSettings `json:"settings"`
-}
 
-type RunSettings struct {
-	ClickHouseSettings *ClickHouseSettings `json:"clickhouse,omitempty"`
+	// Datasets are names of configured datasets loaded before the query.
+	Datasets []string `json:"datasets,omitempty"`
+
+	// Files can be read by the query with the file() table function.
+	Files []InputFile `json:"files,omitempty"`
+
+	// If Topology is set, the query is run against a cluster with Keeper.
+	Topology *queryrun.Topology `json:"topology,omitempty"`
+
+	// Mode is SERVER, LOCAL or AUTO. If empty, the default mode of the server is used.
+	Mode string `json:"mode,omitempty"`
+
+	// If Async is true, the run is executed in background and its id is returned immediately.
+	Async bool `json:"async"`
 }
 
-type ClickHouseSettings struct {
+// RunSettings contains settings of databases, every database has its own section
+// keyed by the database type, e.g. "clickhouse".
+type RunSettings map[dbsettings.Type]*DatabaseSettings
+
+type DatabaseSettings struct {
 	OutputFormat string `json:"output_format"`
+
+	// If Structured is true, the result of the last statement is also returned as typed JSON.
+	Structured bool `json:"structured"`
+
+	// If SplitStatements is true, statements are executed separately and their results are returned separately.
+	SplitStatements bool `json:"split_statements"`
+
+	// Settings are database settings applied to the query, they must be allowed by the server.
+	Settings map[string]SettingValue `json:"settings,omitempty"`
+}
+
+// SettingValue is a setting value passed as a JSON string, number or boolean.
+type SettingValue string
+
+func (v *SettingValue) UnmarshalJSON(data []byte) error {
+	var str string
+	if json.Unmarshal(data, &str) == nil {
+		*v = SettingValue(str)
+		return nil
+	}
+
+	var scalar any
+	err := json.Unmarshal(data, &scalar)
+	if err != nil {
+		return err
+	}
+
+	switch scalar.(type) {
+	case float64, bool:
+		*v = SettingValue(data)
+		return nil
+
+	default:
+		return errors.New("setting value must be a string, number or boolean")
+	}
 }
 
 type RunQueryOutput struct {
-	QueryRunID  string `json:"query_run_id"`
+	QueryRunID  string          `json:"query_run_id"`
+	Status      queryrun.Status `json:"status"`
+	Output      string          `json:"output"`
+	TimeElapsed string          `json:"time_elapsed,omitempty"`
+	CacheHit    bool            `json:"cache_hit"`
+
+	// QueryError is set if the database has rejected the query.
+	QueryError *queryrun.QueryError `json:"query_error,omitempty"`
+
+	Statements []StatementOutput   `json:"statements,omitempty"`
+	ResultSet  *queryrun.ResultSet `json:"result_set,omitempty"`
+}
+
+type StatementOutput struct {
+	Statement   string `json:"statement"`
 	Output      string `json:"output"`
+	Error       string `json:"error,omitempty"`
 	TimeElapsed string `json:"time_elapsed"`
+	RowsRead    uint64 `json:"rows_read,omitempty"`
+	BytesRead   uint64 `json:"bytes_read,omitempty"`
 }
 
-func convertSettings(req *RunQueryInput) (runsettings.RunSettings, error) {
-	var runSettings runsettings.RunSettings
+func newStatementOutputs(statements []queryrun.StatementResult) []StatementOutput {
+	if len(statements) == 0 {
+		return nil
+	}
 
-	switch req.Database {
-	case ClickHouseDatabase:
-		if req.Settings.ClickHouseSettings == nil {
-			return &runsettings.ClickHouseSettings{}, nil
-		}
+	out := make([]StatementOutput, 0, len(statements))
+	for _, stmt := range statements {
+		out = append(out, StatementOutput{
+			Statement:   stmt.Statement,
+			Output:      stmt.Output,
+			Error:       stmt.Error,
+			TimeElapsed: stmt.ExecutionTime.Round(time.Millisecond).String(),
+			RowsRead:    stmt.RowsRead,
+			BytesRead:   stmt.BytesRead,
+		})
+	}
 
-		runSettings = &runsettings.ClickHouseSettings{
-			OutputFormat: req.Settings.ClickHouseSettings.OutputFormat,
-		}
+	return out
+}
 
-	default:
+// convertSettings converts the settings section of the run database. Database settings must be allowed by the policy.
+func (h *queryHandler) convertSettings(req *RunQueryInput) (runsettings.RunSettings, error) {
+	driver, found := dbdriver.Get(dbsettings.Type(req.Database))
+	if !found {
 		return nil, ErrUnknownDatabase
 	}
 
-	return runSettings, nil
+	var input dbdriver.SettingsInput
+	if section := req.Settings[driver.Type()]; section != nil {
+		input.OutputFormat = section.OutputFormat
+		input.Structured = section.Structured
+		input.SplitStatements = section.SplitStatements
+
+		if len(section.Settings) > 0 {
+			input.Settings = make(map[string]string, len(section.Settings))
+			for name, value := range section.Settings {
+				input.Settings[name] = string(value)
+			}
+		}
+	}
+
+	err := h.settingsPolicy.Check(input.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.ConvertSettings(input)
 }
 
-func (h *queryHandler) runQuery(w http.ResponseWriter, r *http.Request) {
+// decodeRun parses and validates a run request.
+// If the request is invalid, an error is written and false is returned.
+func (h *queryHandler) decodeRun(w http.ResponseWriter, r *http.Request) (*queryrun.Run, *RunQueryInput, bool) {
 	var req RunQueryInput
-	err := json.NewDecoder(r.Body).Decode(&req)
+	var err error
+	if isMultipart(r) {
+		err = h.fileLimits.decodeMultipartRun(r, &req)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&req)
+	}
 	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, nil, false
 	}
 
+	run, ok := h.newRun(w, &req)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return run, &req, true
+}
+
+// newRun validates the run request and creates a new run.
+// If the request is invalid, an error is written and false is returned.
+func (h *queryHandler) newRun(w http.ResponseWriter, req *RunQueryInput) (*queryrun.Run, bool) {
 	if req.Query == "" {
 		writeError(w, "query cannot be empty", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 	if uint64(len(req.Query)) > h.maxQueryLength {
 		msg := fmt.Sprintf("query length (%d) cannot exceed %d", len(req.Query), h.maxQueryLength)
 		writeError(w, msg, http.StatusBadRequest)
 
-		return
+		return nil, false
 	}
 
 	if !h.tagStorage.Exists(req.Version) {
 		writeError(w, "unknown version", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	// Set default database for backward compatibility
@@ -114,27 +268,201 @@ func (h *queryHandler) runQuery(w http.ResponseWriter, r *http.Request) {
 		req.Database = ClickHouseDatabase
 	}
 
-	runSettings, err := convertSettings(&req)
+	runSettings, err := h.convertSettings(req)
 	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, false
+	}
+
+	datasets, ok := h.resolveDatasets(w, req.Datasets)
+	if !ok {
+		return nil, false
+	}
+
+	files, err := h.fileLimits.convertFiles(req.Files)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	err = h.checkTopology(req.Topology)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	mode, err := h.resolveMode(req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
 	}
 
 	run := queryrun.New(req.Query, req.Database, req.Version, runSettings)
+	run.Datasets = datasets
+	run.Files = files
+	run.Topology = req.Topology
+	run.Mode = mode
+
+	return run, true
+}
+
+// resolveMode picks how the query is executed. In the AUTO mode, the query is executed without a server
+// if the database supports it and the query doesn't need server features.
+func (h *queryHandler) resolveMode(req *RunQueryInput) (queryrun.Mode, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = h.defaultMode
+	}
+
+	driver, _ := dbdriver.Get(dbsettings.Type(req.Database))
+	local, supported := driver.(dbdriver.LocalDriver)
+
+	// Datasets, files, clusters and structured results are implemented on top of a server.
+	section := req.Settings[dbsettings.Type(req.Database)]
+	needsServer := len(req.Datasets) > 0 || len(req.Files) > 0 || req.Topology != nil ||
+		(section != nil && section.Structured)
+
+	switch mode {
+	case ModeServer:
+		return queryrun.ModeServer, nil
+
+	case ModeLocal:
+		if !supported {
+			return "", errors.Errorf("database %s doesn't support the local mode", req.Database)
+		}
+		if needsServer {
+			return "", errors.New("datasets, files, topology and structured results are not supported in the local mode")
+		}
+
+		return queryrun.ModeLocal, nil
+
+	case ModeAuto:
+		if !supported || needsServer || local.NeedsServer(req.Query) {
+			return queryrun.ModeServer, nil
+		}
+
+		return queryrun.ModeLocal, nil
+
+	default:
+		return "", errors.Errorf("unknown mode %s (supported: %s, %s, %s)", mode, ModeServer, ModeLocal, ModeAuto)
+	}
+}
+
+func (h *queryHandler) checkTopology(topology *queryrun.Topology) error {
+	if topology == nil {
+		return nil
+	}
+
+	if h.maxClusterNodes == 0 {
+		return errors.New("clusters are disabled")
+	}
+	if topology.Shards < 1 || topology.Replicas < 1 {
+		return errors.New("topology must have at least one shard and one replica")
+	}
+	if topology.Exceeds(h.maxClusterNodes) {
+		return errors.Errorf("number of cluster nodes cannot exceed %d", h.maxClusterNodes)
+	}
+
+	return nil
+}
+
+// resolveDatasets converts dataset names to references to their current versions.
+// If any dataset is unknown, an error is written and false is returned.
+func (h *queryHandler) resolveDatasets(w http.ResponseWriter, names []string) ([]queryrun.DatasetRef, bool) {
+	if len(names) == 0 {
+		return nil, true
+	}
+
+	refs := make([]queryrun.DatasetRef, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, exists := seen[name]; exists {
+			writeError(w, fmt.Sprintf("dataset %s is duplicated", name), http.StatusBadRequest)
+			return nil, false
+		}
+		seen[name] = struct{}{}
+
+		var d dataset.Dataset
+		found := false
+		if h.datasets != nil {
+			d, found = h.datasets.Get(name)
+		}
+		if !found {
+			writeError(w, fmt.Sprintf("unknown dataset %s", name), http.StatusBadRequest)
+			return nil, false
+		}
+
+		refs = append(refs, queryrun.DatasetRef{
+			Name:    d.Name,
+			Version: d.Version,
+		})
+	}
+
+	return refs, true
+}
+
+// runErrorStatus converts a runner error to the response status code and message.
+func runErrorStatus(err error) (msg string, code int) {
+	switch {
+	case errors.Is(err, qrunner.ErrNoAvailableRunners):
+		return qrunner.ErrNoAvailableRunners.Error(), http.StatusTooManyRequests
+
+	case errors.Is(err, asyncrun.ErrQueueFull):
+		return asyncrun.ErrQueueFull.Error(), http.StatusTooManyRequests
+
+	case errors.Is(err, qrunner.ErrMemoryLimitExceeded):
+		return qrunner.ErrMemoryLimitExceeded.Error(), http.StatusUnprocessableEntity
+
+	case errors.Is(err, qrunner.ErrRunNotSupported):
+		return qrunner.ErrRunNotSupported.Error(), http.StatusBadRequest
+
+	default:
+		return "internal error", http.StatusInternalServerError
+	}
+}
+
+func (h *queryHandler) runQuery(w http.ResponseWriter, r *http.Request) {
+	run, req, ok := h.decodeRun(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Async {
+		h.submitRun(w, run)
+		return
+	}
 
 	startedAt := time.Now()
 	output, err := h.r.RunQuery(r.Context(), run)
+	if errors.Is(err, qrunner.ErrRunCancelled) {
+		run.Status = queryrun.StatusCancelled
+		err = nil
+	}
 	if err != nil {
-		zlog.Error().Err(err).Interface("request", req).Msg("query run failed")
+		zlog.Error().Err(err).Interface("run", run).Msg("query run failed")
+
+		msg, code := runErrorStatus(err)
+		if errors.Is(err, qrunner.ErrNoAvailableRunners) || errors.Is(err, qrunner.ErrRunNotSupported) {
+			writeError(w, msg, code)
+			return
+		}
 
-		switch {
-		case errors.Is(err, qrunner.ErrNoAvailableRunners):
-			writeError(w, err.Error(), http.StatusTooManyRequests)
+		// The run has been executed, it's saved with the failure details, e.g. the container state.
+		run.Status = queryrun.StatusFailed
+		run.FailureReason = msg
+		run.Output = truncateOutput(output, h.maxOutputLength)
+		run.ExecutionTime = time.Since(startedAt)
 
-		default:
-			writeError(w, "internal error", http.StatusInternalServerError)
+		err = h.runRepo.Create(run)
+		if err != nil {
+			zlog.Error().Err(err).Interface("model", run).Msg("a failed run cannot be saved")
+			writeError(w, msg, code)
+
+			return
 		}
 
+		writeRunError(w, msg, code, run.ID)
+
 		return
 	}
 	if uint64(len(output)) > h.maxOutputLength {
@@ -147,6 +475,9 @@ func (h *queryHandler) runQuery(w http.ResponseWriter, r *http.Request) {
 	timeElapsed := time.Since(startedAt)
 	run.Output = output
 	run.ExecutionTime = timeElapsed
+	if run.Status == "" {
+		run.Status = queryrun.StatusSucceeded
+	}
 
 	err = h.runRepo.Create(run)
 	if err != nil {
@@ -160,8 +491,110 @@ func (h *queryHandler) runQuery(w http.ResponseWriter, r *http.Request) {
 
 	writeResult(w, RunQueryOutput{
 		QueryRunID:  run.ID,
+		Status:      run.Status,
 		Output:      run.Output,
 		TimeElapsed: timeElapsed.Round(time.Millisecond).String(),
+		CacheHit:    run.CacheHit,
+		QueryError:  run.QueryError,
+		Statements:  newStatementOutputs(run.Statements),
+		ResultSet:   run.ResultSet,
+	})
+}
+
+// executeRun runs the query and saves the finished run.
+// Execution errors are saved in the run, an error is returned only if the run cannot be saved.
+func (h *queryHandler) executeRun(ctx context.Context, run *queryrun.Run) error {
+	startedAt := time.Now()
+	output, err := h.runWhenAvailable(ctx, run)
+
+	run.Output = output
+	run.ExecutionTime = time.Since(startedAt)
+
+	switch {
+	case errors.Is(err, qrunner.ErrRunCancelled):
+		run.Status = queryrun.StatusCancelled
+
+	case err != nil:
+		zlog.Error().Err(err).Interface("run", run).Msg("query run failed")
+
+		run.Status = queryrun.StatusFailed
+		run.FailureReason, _ = runErrorStatus(err)
+
+	case uint64(len(output)) > h.maxOutputLength:
+		run.Status = queryrun.StatusFailed
+		run.FailureReason = fmt.Sprintf("output length (%d) cannot exceed %d", len(output), h.maxOutputLength)
+		run.Output = truncateOutput(output, h.maxOutputLength)
+
+	default:
+		if run.Status == "" {
+			run.Status = queryrun.StatusSucceeded
+		}
+	}
+
+	return h.runRepo.Create(run)
+}
+
+// truncateOutput cuts the output to at most n bytes without splitting a UTF-8 character.
+func truncateOutput(output string, n uint64) string {
+	if uint64(len(output)) <= n {
+		return output
+	}
+
+	end := int(n)
+	for end > 0 && !utf8.RuneStart(output[end]) {
+		end--
+	}
+
+	return output[:end]
+}
+
+// runWhenAvailable runs the query, if all runners are busy, it waits until a runner is released
+// and retries until the context is done.
+func (h *queryHandler) runWhenAvailable(ctx context.Context, run *queryrun.Run) (string, error) {
+	notifier, _ := h.r.(qrunner.AvailabilityNotifier)
+
+	for {
+		var released <-chan struct{}
+		if notifier != nil {
+			released = notifier.Released()
+		}
+
+		output, err := h.r.RunQuery(ctx, run)
+		if !errors.Is(err, qrunner.ErrNoAvailableRunners) || released == nil {
+			return output, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return output, err
+		case <-released:
+		}
+	}
+}
+
+// submitRun schedules the run execution in background and returns its id.
+// The run status and output can be retrieved later via the get endpoint.
+func (h *queryHandler) submitRun(w http.ResponseWriter, run *queryrun.Run) {
+	if h.async == nil {
+		writeError(w, "async runs are disabled", http.StatusBadRequest)
+		return
+	}
+
+	err := h.async.Submit(run)
+	if err != nil {
+		zlog.Error().Err(err).Interface("run", run).Msg("query run cannot be submitted")
+
+		msg, code := runErrorStatus(err)
+		writeError(w, msg, code)
+
+		return
+	}
+
+	zlog.Info().Str("id", run.ID).Msg("submitted a new async run")
+
+	writeResult(w, RunQueryOutput{
+		QueryRunID: run.ID,
+		Status:     queryrun.StatusQueued,
 	})
 }
 
@@ -170,12 +603,25 @@ type GetQueryRunInput struct {
 }
 
 type GetQueryRunOutput struct {
-	QueryRunID string                  `json:"query_run_id"`
-	Database   string                  `json:"database,omitempty"`
-	Version    string                  `json:"version"`
-	Settings   runsettings.RunSettings `json:"settings,omitempty"`
-	Input      string                  `json:"input"`
-	Output     string                  `json:"output"`
+	QueryRunID    string                   `json:"query_run_id"`
+	Status        queryrun.Status          `json:"status"`
+	FailureReason string                   `json:"failure_reason,omitempty"`
+	GroupID       string                   `json:"group_id,omitempty"`
+	Database      string                   `json:"database,omitempty"`
+	Version       string                   `json:"version"`
+	Settings      runsettings.RunSettings  `json:"settings,omitempty"`
+	Datasets      []queryrun.DatasetRef    `json:"datasets,omitempty"`
+	Files         []queryrun.InputFile     `json:"files,omitempty"`
+	Topology      *queryrun.Topology       `json:"topology,omitempty"`
+	Mode          queryrun.Mode            `json:"mode,omitempty"`
+	Input         string                   `json:"input"`
+	Output        string                   `json:"output"`
+	QueryError    *queryrun.QueryError     `json:"query_error,omitempty"`
+	Container     *queryrun.ContainerState `json:"container,omitempty"`
+	Statements    []StatementOutput        `json:"statements,omitempty"`
+	ResultSet     *queryrun.ResultSet      `json:"result_set,omitempty"`
+	TimeElapsed   string                   `json:"time_elapsed,omitempty"`
+	CacheHit      bool                     `json:"cache_hit"`
 }
 
 func (h *queryHandler) getQueryRun(w http.ResponseWriter, r *http.Request) {
@@ -197,12 +643,119 @@ func (h *queryHandler) getQueryRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeResult(w, GetQueryRunOutput{
+	out := GetQueryRunOutput{
+		QueryRunID:    run.ID,
+		Status:        run.CurrentStatus(),
+		FailureReason: run.FailureReason,
+		GroupID:       run.GroupID,
+		Database:      run.Database,
+		Version:       run.Version,
+		Settings:      run.Settings,
+		Datasets:      run.Datasets,
+		Files:         run.Files,
+		Topology:      run.Topology,
+		Mode:          run.Mode,
+		Input:         run.Input,
+		Output:        run.Output,
+		QueryError:    run.QueryError,
+		Container:     run.Container,
+		Statements:    newStatementOutputs(run.Statements),
+		ResultSet:     run.ResultSet,
+		CacheHit:      run.CacheHit,
+	}
+	if run.ExecutionTime != 0 {
+		out.TimeElapsed = run.ExecutionTime.Round(time.Millisecond).String()
+	}
+
+	writeResult(w, out)
+}
+
+// cancelQueryRun stops a queued or running query and returns its partial output.
+//
+// Async runs are stored while they are executed, so they are cancelled by the async runner.
+// Sync runs are saved only when they are finished, so they are cancelled by the query runner directly.
+func (h *queryHandler) cancelQueryRun(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, "missed id", http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.runRepo.Get(id)
+	switch {
+	case errors.Is(err, queryrun.ErrNotFound):
+		output, err := h.r.CancelRun(r.Context(), id)
+		if errors.Is(err, qrunner.ErrRunNotFound) {
+			writeError(w, "run not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			zlog.Error().Err(err).Str("id", id).Msg("failed to cancel a run")
+			writeError(w, "internal error", http.StatusInternalServerError)
+
+			return
+		}
+
+		writeResult(w, RunQueryOutput{
+			QueryRunID: id,
+			Status:     queryrun.StatusCancelled,
+			Output:     output,
+		})
+
+		return
+
+	case err != nil:
+		zlog.Error().Err(err).Str("id", id).Msg("failed to find a run")
+		writeError(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	if run.CurrentStatus().IsFinal() {
+		writeError(w, fmt.Sprintf("run has already been finished with status %s", run.CurrentStatus()), http.StatusConflict)
+		return
+	}
+
+	run, err = h.cancelStoredRun(r.Context(), run)
+	if err != nil {
+		zlog.Error().Err(err).Str("id", id).Msg("failed to cancel a run")
+		writeError(w, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writeResult(w, RunQueryOutput{
 		QueryRunID: run.ID,
-		Database:   run.Database,
-		Version:    run.Version,
-		Settings:   run.Settings,
-		Input:      run.Input,
+		Status:     run.CurrentStatus(),
 		Output:     run.Output,
 	})
 }
+
+// cancelStoredRun cancels an unfinished async run and returns its actual state.
+func (h *queryHandler) cancelStoredRun(ctx context.Context, run *queryrun.Run) (*queryrun.Run, error) {
+	err := qrunner.ErrRunNotFound
+	if h.async != nil {
+		err = h.async.Cancel(ctx, run.ID)
+	}
+	if err == nil {
+		return h.runRepo.Get(run.ID)
+	}
+	if !errors.Is(err, qrunner.ErrRunNotFound) {
+		return nil, err
+	}
+
+	// The run is not processed by the async runner, e.g. the server has been restarted.
+	// Remove its container if it's left and mark the run as cancelled.
+	_, err = h.r.CancelRun(ctx, run.ID)
+	if err != nil && !errors.Is(err, qrunner.ErrRunNotFound) {
+		return nil, errors.Wrap(err, "runner cancellation failed")
+	}
+
+	run.Status = queryrun.StatusCancelled
+	err = h.runRepo.Update(run)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save the run")
+	}
+
+	return run, nil
+}
@@ -0,0 +1,669 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/asyncrun"
+	"github.com/lodthe/clickhouse-playground/internal/dataset"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dbsettings/runsettings"
+	"github.com/lodthe/clickhouse-playground/internal/dockertag"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/qrunner/stubrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tagStorageMock struct {
+	tags []string
+}
+
+func (t tagStorageMock) GetAll() []dockertag.Image {
+	images := make([]dockertag.Image, 0, len(t.tags))
+	for _, tag := range t.tags {
+		images = append(images, dockertag.Image{Tag: tag})
+	}
+
+	return images
+}
+
+func (t tagStorageMock) Exists(tag string) bool {
+	for _, known := range t.tags {
+		if known == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+	repo    *queryrun.MemoryRepo
+}
+
+func newTestServer(t *testing.T, run stubrunner.Run) *testServer {
+	datasets, err := dataset.NewRegistry([]dataset.Dataset{
+		{Name: "tpch", Version: "2", Path: "/datasets/tpch.sql", Format: dataset.FormatSQL},
+	})
+	require.NoError(t, err)
+
+	repo := queryrun.NewMemoryRepository(100, 0)
+	runner := stubrunner.New(context.Background(), "stub", run)
+
+	executor := asyncrun.New(context.Background(), zerolog.Nop(), runner, repo, asyncrun.DefaultConfig)
+	executor.Start()
+	t.Cleanup(func() {
+		assert.NoError(t, executor.Stop(context.Background()))
+	})
+
+	return &testServer{
+		t:    t,
+		repo: repo,
+		handler: NewRouter(RouterOpts{
+			Logger:          zerolog.Nop(),
+			Runner:          runner,
+			AsyncRunner:     executor,
+			TagStorage:      tagStorageMock{tags: []string{"head", "23.3", "23.2", "23.1", "22.12", "22.8"}},
+			RunRepo:         repo,
+			Timeout:         10 * time.Second,
+			MaxQueryLength:  100,
+			MaxOutputLength: 100,
+			SettingsPolicy:  chspec.SettingsPolicy{Allowed: []string{"max_threads", "allow_experimental_*"}},
+			Datasets:        datasets,
+			FileLimits:      FileLimits{MaxFiles: 2, MaxTotalSize: 16},
+			MaxClusterNodes: 4,
+		}),
+	}
+}
+
+// do sends a request and decodes the response result into out.
+func (s *testServer) do(method, path string, body any, out any) (int, *ErrorResponse) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		require.NoError(s.t, json.NewEncoder(&reqBody).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &reqBody)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	resp := Response{Result: out}
+	require.NoError(s.t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	return rec.Code, resp.Error
+}
+
+func echoRun(_ context.Context, run *queryrun.Run) (string, error) {
+	return "echo: " + run.Input, nil
+}
+
+func TestQueryHandler_RunAndGet(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{
+		Query:   "SELECT 1",
+		Version: "23.3",
+		Settings: RunSettings{
+			dbsettings.TypeClickHouse: &DatabaseSettings{OutputFormat: "JSON"},
+		},
+	}, &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	assert.NotEmpty(t, runOut.QueryRunID)
+	assert.Equal(t, queryrun.StatusSucceeded, runOut.Status)
+	assert.Equal(t, "echo: SELECT 1", runOut.Output)
+
+	// Settings are decoded separately because it's an interface.
+	var getOut struct {
+		GetQueryRunOutput
+		Settings json.RawMessage `json:"settings"`
+	}
+	code, respErr = s.do(http.MethodGet, "/api/runs/"+runOut.QueryRunID, nil, &getOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	assert.Equal(t, runOut.QueryRunID, getOut.QueryRunID)
+	assert.Equal(t, queryrun.StatusSucceeded, getOut.Status)
+	assert.Equal(t, ClickHouseDatabase, getOut.Database)
+	assert.Equal(t, "23.3", getOut.Version)
+	assert.Equal(t, "SELECT 1", getOut.Input)
+	assert.Equal(t, "echo: SELECT 1", getOut.Output)
+	assert.JSONEq(t, `{"OutputFormat": "JSON"}`, string(getOut.Settings))
+}
+
+func TestQueryHandler_RunAsync(t *testing.T) {
+	release := make(chan struct{})
+	s := newTestServer(t, func(ctx context.Context, run *queryrun.Run) (string, error) {
+		<-release
+		return "done", nil
+	})
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{
+		Query:   "SELECT sleep(3)",
+		Version: "head",
+		Async:   true,
+	}, &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	assert.Equal(t, queryrun.StatusQueued, runOut.Status)
+	assert.Empty(t, runOut.Output)
+
+	getStatus := func() (queryrun.Status, string) {
+		var getOut struct {
+			GetQueryRunOutput
+			Settings json.RawMessage `json:"settings"`
+		}
+
+		code, respErr := s.do(http.MethodGet, "/api/runs/"+runOut.QueryRunID, nil, &getOut)
+		require.Equal(t, http.StatusOK, code)
+		require.Nil(t, respErr)
+
+		return getOut.Status, getOut.Output
+	}
+
+	require.Eventually(t, func() bool {
+		status, _ := getStatus()
+		return status == queryrun.StatusRunning
+	}, 5*time.Second, 5*time.Millisecond)
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		status, output := getStatus()
+		return status == queryrun.StatusSucceeded && output == "done"
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestQueryHandler_RunValidation(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	cases := []struct {
+		name  string
+		input RunQueryInput
+	}{
+		{name: "empty query", input: RunQueryInput{Version: "23.3"}},
+		{name: "too long query", input: RunQueryInput{Query: string(make([]byte, 101)), Version: "23.3"}},
+		{name: "unknown version", input: RunQueryInput{Query: "SELECT 1", Version: "1.1"}},
+		{name: "unknown database", input: RunQueryInput{Query: "SELECT 1", Version: "23.3", Database: "mysql"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, respErr := s.do(http.MethodPost, "/api/runs", tc.input, nil)
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.NotNil(t, respErr)
+		})
+	}
+}
+
+func TestQueryHandler_RunWithSettings(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", json.RawMessage(`{
+		"query": "SELECT 1",
+		"version": "head",
+		"settings": {"clickhouse": {"settings": {"max_threads": 1, "allow_experimental_analyzer": true}}}
+	}`), &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	run, err := s.repo.Get(runOut.QueryRunID)
+	require.NoError(t, err)
+	assert.Equal(t, &runsettings.ClickHouseSettings{
+		Settings: map[string]string{"max_threads": "1", "allow_experimental_analyzer": "true"},
+	}, run.Settings)
+}
+
+func TestQueryHandler_RunWithDeniedSettings(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	cases := []string{
+		`{"max_memory_usage": "0"}`,
+		`{"max_threads": [1]}`,
+		`{"config-file": "/etc/passwd"}`,
+	}
+
+	for _, settings := range cases {
+		body := json.RawMessage(`{"query": "SELECT 1", "version": "head", "settings": {"clickhouse": {"settings": ` + settings + `}}}`)
+		code, respErr := s.do(http.MethodPost, "/api/runs", body, nil)
+		assert.Equal(t, http.StatusBadRequest, code, settings)
+		assert.NotNil(t, respErr, settings)
+	}
+}
+
+func TestQueryHandler_RunWithDatasets(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{
+		Query:    "SELECT count() FROM lineitem",
+		Version:  "head",
+		Datasets: []string{"tpch"},
+	}, &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	var getOut struct {
+		GetQueryRunOutput
+		Settings json.RawMessage `json:"settings"`
+	}
+	code, respErr = s.do(http.MethodGet, "/api/runs/"+runOut.QueryRunID, nil, &getOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, []queryrun.DatasetRef{{Name: "tpch", Version: "2"}}, getOut.Datasets)
+
+	for _, datasets := range [][]string{{"unknown"}, {"tpch", "tpch"}} {
+		code, respErr = s.do(http.MethodPost, "/api/runs", RunQueryInput{
+			Query:    "SELECT 1",
+			Version:  "head",
+			Datasets: datasets,
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotNil(t, respErr)
+	}
+}
+
+func TestQueryHandler_RunWithFiles(t *testing.T) {
+	var files []queryrun.InputFile
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		files = run.Files
+		return "", nil
+	})
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", json.RawMessage(`{
+		"query": "SELECT * FROM file('data.csv')",
+		"version": "head",
+		"files": [{"name": "data.csv", "content": "MSwyCg=="}]
+	}`), &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+
+	require.Len(t, files, 1)
+	assert.Equal(t, "1,2\n", string(files[0].Content))
+
+	run, err := s.repo.Get(runOut.QueryRunID)
+	require.NoError(t, err)
+	require.Len(t, run.Files, 1)
+	assert.Equal(t, "data.csv", run.Files[0].Name)
+	assert.Equal(t, 4, run.Files[0].Size)
+	assert.Equal(t, files[0].SHA256, run.Files[0].SHA256)
+
+	cases := [][]InputFile{
+		{{Name: "a.csv"}, {Name: "b.csv"}, {Name: "c.csv"}},
+		{{Name: "../a.csv"}},
+		{{Name: "a.csv"}, {Name: "a.csv"}},
+		{{Name: "a.csv", Content: []byte("too large content")}},
+	}
+	for _, files := range cases {
+		code, respErr = s.do(http.MethodPost, "/api/runs", RunQueryInput{
+			Query:   "SELECT 1",
+			Version: "head",
+			Files:   files,
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotNil(t, respErr)
+	}
+}
+
+func TestQueryHandler_RunWithMultipartFiles(t *testing.T) {
+	var files []queryrun.InputFile
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		files = run.Files
+		return "", nil
+	})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("request", `{"query": "SELECT * FROM file('data.tsv')", "version": "head"}`))
+	fw, err := mw.CreateFormFile("files", "data.tsv")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("1\t2\n"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/runs", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.Len(t, files, 1)
+	assert.Equal(t, "data.tsv", files[0].Name)
+	assert.Equal(t, "1\t2\n", string(files[0].Content))
+}
+
+func TestQueryHandler_RunWithTopology(t *testing.T) {
+	var topology *queryrun.Topology
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		topology = run.Topology
+		return "", nil
+	})
+
+	code, respErr := s.do(http.MethodPost, "/api/runs", json.RawMessage(`{
+		"query": "CREATE TABLE t ON CLUSTER default (x UInt8) ENGINE = Memory",
+		"version": "head",
+		"topology": {"shards": 2, "replicas": 2}
+	}`), nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, &queryrun.Topology{Shards: 2, Replicas: 2}, topology)
+
+	for _, topology := range []queryrun.Topology{{Shards: 0, Replicas: 1}, {Shards: 1, Replicas: -1}, {Shards: 3, Replicas: 2}, {Shards: 1 << 32, Replicas: 1 << 32}} {
+		code, respErr = s.do(http.MethodPost, "/api/runs", RunQueryInput{
+			Query:    "SELECT 1",
+			Version:  "head",
+			Topology: &topology,
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotNil(t, respErr)
+	}
+}
+
+func TestQueryHandler_RunMode(t *testing.T) {
+	var mode queryrun.Mode
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		mode = run.Mode
+		return "", nil
+	})
+
+	cases := []struct {
+		name     string
+		input    RunQueryInput
+		expected queryrun.Mode
+	}{
+		{name: "default", input: RunQueryInput{Query: "SELECT 1"}, expected: queryrun.ModeServer},
+		{name: "local", input: RunQueryInput{Query: "SELECT 1", Mode: ModeLocal}, expected: queryrun.ModeLocal},
+		{name: "auto without server features", input: RunQueryInput{Query: "SELECT 1", Mode: ModeAuto}, expected: queryrun.ModeLocal},
+		{name: "auto with system tables", input: RunQueryInput{Query: "SELECT * FROM system.tables", Mode: ModeAuto}, expected: queryrun.ModeServer},
+		{name: "auto with datasets", input: RunQueryInput{Query: "SELECT 1", Mode: ModeAuto, Datasets: []string{"tpch"}}, expected: queryrun.ModeServer},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.input.Version = "head"
+			code, respErr := s.do(http.MethodPost, "/api/runs", tc.input, nil)
+			require.Equal(t, http.StatusOK, code, respErr)
+			assert.Equal(t, tc.expected, mode)
+		})
+	}
+
+	for _, input := range []RunQueryInput{
+		{Query: "SELECT 1", Version: "head", Mode: "REMOTE"},
+		{Query: "SELECT 1", Version: "head", Mode: ModeLocal, Datasets: []string{"tpch"}},
+		{Query: "SELECT 1", Version: "head", Mode: ModeLocal, Topology: &queryrun.Topology{Shards: 2, Replicas: 1}},
+	} {
+		code, respErr := s.do(http.MethodPost, "/api/runs", input, nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.NotNil(t, respErr)
+	}
+}
+
+func TestQueryHandler_RunFailed(t *testing.T) {
+	s := newTestServer(t, stubrunner.StubRun)
+
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{Query: "SELECT 1", Version: "head"}, nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.NotNil(t, respErr)
+}
+
+func TestQueryHandler_RunQueryError(t *testing.T) {
+	queryErr := &queryrun.QueryError{Code: 62, Name: "SYNTAX_ERROR", Message: "Syntax error", ExitCode: 62}
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		run.QueryError = queryErr
+		return "Code: 62. DB::Exception: Syntax error. (SYNTAX_ERROR)\n", nil
+	})
+
+	var runOut RunQueryOutput
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{Query: "SELEC 1", Version: "head"}, &runOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, queryrun.StatusSucceeded, runOut.Status)
+	assert.Equal(t, queryErr, runOut.QueryError)
+
+	var getOut struct {
+		GetQueryRunOutput
+		Settings json.RawMessage `json:"settings"`
+	}
+	code, respErr = s.do(http.MethodGet, "/api/runs/"+runOut.QueryRunID, nil, &getOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, queryErr, getOut.QueryError)
+}
+
+func TestQueryHandler_RunMemoryLimitExceeded(t *testing.T) {
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		run.Container = &queryrun.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}
+		return "", errors.Wrap(qrunner.ErrMemoryLimitExceeded, "failed to run query")
+	})
+
+	code, respErr := s.do(http.MethodPost, "/api/runs", RunQueryInput{Query: "SELECT 1", Version: "head"}, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	require.NotNil(t, respErr)
+	assert.Equal(t, qrunner.ErrMemoryLimitExceeded.Error(), respErr.Message)
+	require.NotEmpty(t, respErr.QueryRunID)
+
+	// The failed run is saved with the container state.
+	saved, err := s.repo.Get(respErr.QueryRunID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusFailed, saved.Status)
+	assert.Equal(t, qrunner.ErrMemoryLimitExceeded.Error(), saved.FailureReason)
+	assert.Equal(t, &queryrun.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}, saved.Container)
+}
+
+func TestQueryHandler_GetNotFound(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	code, respErr := s.do(http.MethodGet, "/api/runs/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.NotNil(t, respErr)
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func parseEvents(t *testing.T, body string) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			default:
+				t.Fatalf("unexpected line %q", line)
+			}
+		}
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestQueryHandler_RunStream(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	body := `{"query": "SELECT 1", "version": "head"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/runs/stream", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	events := parseEvents(t, rec.Body.String())
+	require.Len(t, events, 3)
+
+	assert.Equal(t, EventRun, events[0].name)
+
+	assert.Equal(t, EventOutput, events[1].name)
+	assert.JSONEq(t, `{"stream": "stdout", "data": "echo: SELECT 1"}`, events[1].data)
+
+	assert.Equal(t, EventResult, events[2].name)
+
+	var result StreamResultEvent
+	require.NoError(t, json.Unmarshal([]byte(events[2].data), &result))
+	assert.Equal(t, queryrun.StatusSucceeded, result.Status)
+	assert.JSONEq(t, `{"query_run_id": "`+result.QueryRunID+`"}`, events[0].data)
+
+	run, err := s.repo.Get(result.QueryRunID)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: SELECT 1", run.Output)
+}
+
+func TestQueryHandler_RunStreamOutputLimit(t *testing.T) {
+	s := newTestServer(t, func(_ context.Context, _ *queryrun.Run) (string, error) {
+		return strings.Repeat("a", 101), nil
+	})
+
+	body := `{"query": "SELECT 1", "version": "head"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/runs/stream", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	events := parseEvents(t, rec.Body.String())
+	require.Len(t, events, 2)
+	assert.Equal(t, EventError, events[1].name)
+}
+
+func TestQueryHandler_RunStreamFailed(t *testing.T) {
+	s := newTestServer(t, func(_ context.Context, run *queryrun.Run) (string, error) {
+		run.Container = &queryrun.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}
+		return "", errors.Wrap(qrunner.ErrMemoryLimitExceeded, "failed to run query")
+	})
+
+	body := `{"query": "SELECT 1", "version": "head"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/runs/stream", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	events := parseEvents(t, rec.Body.String())
+	require.Len(t, events, 2)
+	assert.Equal(t, EventError, events[1].name)
+
+	var respErr ErrorResponse
+	require.NoError(t, json.Unmarshal([]byte(events[1].data), &respErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, respErr.Code)
+	require.NotEmpty(t, respErr.QueryRunID)
+
+	// The failed run is saved with the container state.
+	saved, err := s.repo.Get(respErr.QueryRunID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusFailed, saved.Status)
+	assert.Equal(t, qrunner.ErrMemoryLimitExceeded.Error(), saved.FailureReason)
+	assert.Equal(t, &queryrun.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}, saved.Container)
+}
+
+// blockingRun returns a run function that sends the run id to the returned channel
+// and blocks until the run is cancelled.
+func blockingRun() (stubrunner.Run, <-chan string) {
+	started := make(chan string, 1)
+
+	return func(ctx context.Context, run *queryrun.Run) (string, error) {
+		started <- run.ID
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	}, started
+}
+
+func TestQueryHandler_CancelSync(t *testing.T) {
+	run, started := blockingRun()
+	s := newTestServer(t, run)
+
+	type result struct {
+		code int
+		out  RunQueryOutput
+	}
+	done := make(chan result)
+	go func() {
+		var out RunQueryOutput
+		code, _ := s.do(http.MethodPost, "/api/runs", RunQueryInput{Query: "SELECT sleep(3)", Version: "head"}, &out)
+		done <- result{code: code, out: out}
+	}()
+
+	id := <-started
+
+	var cancelOut RunQueryOutput
+	code, respErr := s.do(http.MethodDelete, "/api/runs/"+id, nil, &cancelOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, queryrun.StatusCancelled, cancelOut.Status)
+
+	res := <-done
+	assert.Equal(t, http.StatusOK, res.code)
+	assert.Equal(t, id, res.out.QueryRunID)
+	assert.Equal(t, queryrun.StatusCancelled, res.out.Status)
+
+	saved, err := s.repo.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusCancelled, saved.Status)
+
+	// A finished run cannot be cancelled.
+	code, respErr = s.do(http.MethodDelete, "/api/runs/"+id, nil, nil)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.NotNil(t, respErr)
+}
+
+func TestQueryHandler_CancelAsync(t *testing.T) {
+	run, started := blockingRun()
+	s := newTestServer(t, run)
+
+	var runOut RunQueryOutput
+	code, _ := s.do(http.MethodPost, "/api/runs", RunQueryInput{Query: "SELECT sleep(3)", Version: "head", Async: true}, &runOut)
+	require.Equal(t, http.StatusOK, code)
+
+	<-started
+
+	var cancelOut RunQueryOutput
+	code, respErr := s.do(http.MethodDelete, "/api/runs/"+runOut.QueryRunID, nil, &cancelOut)
+	require.Equal(t, http.StatusOK, code)
+	require.Nil(t, respErr)
+	assert.Equal(t, queryrun.StatusCancelled, cancelOut.Status)
+
+	saved, err := s.repo.Get(runOut.QueryRunID)
+	require.NoError(t, err)
+	assert.Equal(t, queryrun.StatusCancelled, saved.Status)
+}
+
+func TestQueryHandler_CancelUnknown(t *testing.T) {
+	s := newTestServer(t, echoRun)
+
+	code, respErr := s.do(http.MethodDelete, "/api/runs/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.NotNil(t, respErr)
+}
+
+func TestTruncateOutput(t *testing.T) {
+	assert.Equal(t, "abc", truncateOutput("abc", 5))
+	assert.Equal(t, "ab", truncateOutput("abc", 2))
+
+	// "ж" takes 2 bytes, it's not split.
+	assert.Equal(t, "a", truncateOutput("aжb", 2))
+	assert.Equal(t, "aж", truncateOutput("aжb", 3))
+}
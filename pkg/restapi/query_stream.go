@@ -0,0 +1,138 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/lodthe/clickhouse-playground/internal/qrunner"
+	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+
+	"github.com/pkg/errors"
+	zlog "github.com/rs/zerolog/log"
+)
+
+type StreamOutputEvent struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+type StreamRunEvent struct {
+	QueryRunID string `json:"query_run_id"`
+}
+
+type StreamResultEvent struct {
+	QueryRunID  string          `json:"query_run_id"`
+	Status      queryrun.Status `json:"status"`
+	TimeElapsed string          `json:"time_elapsed"`
+	CacheHit    bool            `json:"cache_hit"`
+
+	QueryError *queryrun.QueryError `json:"query_error,omitempty"`
+	ResultSet  *queryrun.ResultSet  `json:"result_set,omitempty"`
+}
+
+// runQueryStream runs a query and streams its output as Server-Sent Events.
+//
+// The run id is sent in the first "run" event, so the run can be cancelled while it's executed.
+// Output chunks are sent as "output" events while the query is being executed.
+// When the run is finished and saved, a "result" event is sent.
+// If something goes wrong, an "error" event is sent and the stream is closed.
+// If the run has been executed, it's saved and the error event contains the run id.
+func (h *queryHandler) runQueryStream(w http.ResponseWriter, r *http.Request) {
+	run, _, ok := h.decodeRun(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := newSSEWriter(w)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	defer events.close()
+
+	events.send(EventRun, StreamRunEvent{QueryRunID: run.ID})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Stop the query as soon as the output limit is exceeded.
+	var outputLength uint64
+	onOutput := func(chunk qrunner.OutputChunk) {
+		if atomic.AddUint64(&outputLength, uint64(len(chunk.Data))) > h.maxOutputLength {
+			cancel()
+			return
+		}
+
+		events.send(EventOutput, StreamOutputEvent{
+			Stream: string(chunk.Stream),
+			Data:   chunk.Data,
+		})
+	}
+
+	startedAt := time.Now()
+	output, err := h.r.RunQueryStream(ctx, run, onOutput)
+	if errors.Is(err, qrunner.ErrRunCancelled) {
+		run.Status = queryrun.StatusCancelled
+		err = nil
+	}
+	if atomic.LoadUint64(&outputLength) > h.maxOutputLength || uint64(len(output)) > h.maxOutputLength {
+		events.sendError(fmt.Sprintf("output length cannot exceed %d", h.maxOutputLength), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		zlog.Error().Err(err).Interface("run", run).Msg("query run failed")
+
+		msg, code := runErrorStatus(err)
+		if errors.Is(err, qrunner.ErrNoAvailableRunners) || errors.Is(err, qrunner.ErrRunNotSupported) {
+			events.sendError(msg, code)
+			return
+		}
+
+		// The run has been executed, it's saved with the failure details, e.g. the container state.
+		run.Status = queryrun.StatusFailed
+		run.FailureReason = msg
+		run.Output = truncateOutput(output, h.maxOutputLength)
+		run.ExecutionTime = time.Since(startedAt)
+
+		err = h.runRepo.Create(run)
+		if err != nil {
+			zlog.Error().Err(err).Interface("model", run).Msg("a failed run cannot be saved")
+			events.sendError(msg, code)
+
+			return
+		}
+
+		events.sendRunError(msg, code, run.ID)
+
+		return
+	}
+
+	timeElapsed := time.Since(startedAt)
+	run.Output = output
+	run.ExecutionTime = timeElapsed
+	if run.Status == "" {
+		run.Status = queryrun.StatusSucceeded
+	}
+
+	err = h.runRepo.Create(run)
+	if err != nil {
+		zlog.Error().Err(err).Interface("model", run).Msg("a run cannot be saved")
+		events.sendError("internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	zlog.Info().Str("id", run.ID).Dur("elapsed", timeElapsed).Msg("saved a new streamed run")
+
+	events.send(EventResult, StreamResultEvent{
+		QueryRunID:  run.ID,
+		Status:      run.Status,
+		TimeElapsed: timeElapsed.Round(time.Millisecond).String(),
+		CacheHit:    run.CacheHit,
+		QueryError:  run.QueryError,
+		ResultSet:   run.ResultSet,
+	})
+}
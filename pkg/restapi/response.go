@@ -15,9 +15,17 @@ type Response struct {
 type ErrorResponse struct {
 	Message string `json:"message"`
 	Code    int    `json:"code"`
+
+	// QueryRunID is set if the failed run has been saved.
+	QueryRunID string `json:"query_run_id,omitempty"`
 }
 
 func writeError(w http.ResponseWriter, msg string, code int) {
+	writeRunError(w, msg, code, "")
+}
+
+// writeRunError writes an error of the saved run, its details can be retrieved by the run id.
+func writeRunError(w http.ResponseWriter, msg string, code int, runID string) {
 	if code < 600 { // nolint
 		w.WriteHeader(code)
 	} else {
@@ -26,8 +34,9 @@ func writeError(w http.ResponseWriter, msg string, code int) {
 
 	writeResponse(w, &Response{
 		Error: &ErrorResponse{
-			Message: msg,
-			Code:    code,
+			Message:    msg,
+			Code:       code,
+			QueryRunID: runID,
 		},
 	})
 }
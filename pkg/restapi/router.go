@@ -1,6 +1,7 @@
 package restapi
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/lodthe/clickhouse-playground/internal/metrics"
 	"github.com/lodthe/clickhouse-playground/internal/queryrun"
+	"github.com/lodthe/clickhouse-playground/pkg/chspec"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,19 +18,54 @@ import (
 )
 
 type RouterOpts struct {
+	// Context is the parent context of background jobs like bisections.
+	// If nil, context.Background() is used.
+	Context context.Context
+
 	Logger     zerolog.Logger
 	Runner     QueryRunner
 	TagStorage TagStorage
 	RunRepo    queryrun.Repository
 
+	// AsyncRunner processes runs submitted in async mode. If nil, async mode is disabled.
+	AsyncRunner AsyncRunner
+
 	Timeout       time.Duration
 	CacheDisabled bool
 
 	MaxQueryLength  uint64
 	MaxOutputLength uint64
+
+	// MaxComparedVersions limits the number of versions in a comparison.
+	MaxComparedVersions int
+
+	// SettingsPolicy restricts ClickHouse settings users can pass, no settings are allowed by default.
+	SettingsPolicy chspec.SettingsPolicy
+
+	// Datasets contains datasets users can attach to runs. If nil, no datasets are available.
+	Datasets DatasetRegistry
+
+	// FileLimits restricts files uploaded with runs.
+	FileLimits FileLimits
+
+	// MaxClusterNodes limits the number of servers in a requested cluster topology.
+	// If 0, clusters are disabled.
+	MaxClusterNodes int
+
+	// DefaultMode is the execution mode of requests without a mode: SERVER (default), LOCAL or AUTO.
+	DefaultMode string
+
+	// RunnerManager changes runners via the admin API. The admin API is enabled
+	// only if both the manager and the admin token are set.
+	RunnerManager RunnerManager
+	AdminToken    string
 }
 
 func NewRouter(opts RouterOpts) http.Handler {
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(metricsMiddleware)
@@ -56,8 +93,15 @@ func NewRouter(opts RouterOpts) http.Handler {
 	}))
 
 	r.Route("/api", func(r chi.Router) {
-		newQueryHandler(opts.Runner, opts.RunRepo, opts.TagStorage, opts.MaxQueryLength, opts.MaxOutputLength).handle(r)
+		qh := newQueryHandler(opts.Runner, opts.AsyncRunner, opts.RunRepo, opts.TagStorage, opts.MaxQueryLength, opts.MaxOutputLength, opts.SettingsPolicy, opts.Datasets, opts.FileLimits, opts.MaxClusterNodes, opts.DefaultMode)
+		qh.handle(r)
+		newComparisonHandler(qh, opts.MaxComparedVersions).handle(r)
+		newBisectionHandler(opts.Context, qh).handle(r)
 		newImageTagHandler(opts.TagStorage).handle(r)
+
+		if opts.RunnerManager != nil && opts.AdminToken != "" {
+			newAdminHandler(opts.RunnerManager, opts.AdminToken).handle(r)
+		}
 	})
 
 	return r
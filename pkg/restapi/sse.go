@@ -0,0 +1,91 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	zlog "github.com/rs/zerolog/log"
+)
+
+const (
+	EventRun    = "run"
+	EventOutput = "output"
+	EventResult = "result"
+	EventError  = "error"
+)
+
+// sseWriter writes Server-Sent Events to the client.
+//
+// Events can be sent from several goroutines. After the writer is closed,
+// events are silently dropped because the response cannot be used anymore.
+type sseWriter struct {
+	lock    sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  bool
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseWriter{
+		w:       w,
+		flusher: flusher,
+	}, nil
+}
+
+func (s *sseWriter) send(event string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		zlog.Error().Err(err).Str("event", event).Msg("event encoding failed")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		zlog.Debug().Err(err).Str("event", event).Msg("failed to send an event")
+		return
+	}
+
+	s.flusher.Flush()
+}
+
+func (s *sseWriter) sendError(msg string, code int) {
+	s.sendRunError(msg, code, "")
+}
+
+// sendRunError sends an error of the run that has been saved with the failure details.
+func (s *sseWriter) sendRunError(msg string, code int, runID string) {
+	s.send(EventError, ErrorResponse{
+		Message:    msg,
+		Code:       code,
+		QueryRunID: runID,
+	})
+}
+
+func (s *sseWriter) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+}
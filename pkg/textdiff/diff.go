@@ -0,0 +1,241 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines printed around changes.
+const DefaultContext = 3
+
+// maxTableSize limits memory used for the longest common subsequence table.
+// Larger inputs are reported as fully replaced.
+const maxTableSize = 4_000_000
+
+type OpType byte
+
+const (
+	OpEqual  OpType = ' '
+	OpDelete OpType = '-'
+	OpInsert OpType = '+'
+)
+
+type Op struct {
+	Type OpType
+	Line string
+}
+
+// Lines computes a line-based edit script that transforms a into b.
+// The script is based on the longest common subsequence of lines.
+func Lines(a, b string) []Op {
+	return diff(splitLines(a), splitLines(b))
+}
+
+// Unified returns the difference between a and b in the unified diff format.
+// Names are used as file names in the header. If a and b are equal, an empty string is returned.
+func Unified(nameA, nameB, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := Lines(a, b)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+
+	for _, h := range hunks(ops, DefaultContext) {
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(h.startA, h.countA), hunkRange(h.startB, h.countB))
+		for _, op := range h.ops {
+			sb.WriteByte(byte(op.Type))
+			sb.WriteString(op.Line)
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func diff(a, b []string) []Op {
+	// Skip the common prefix and suffix, they are usually the largest part of similar outputs.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, Op{Type: OpEqual, Line: line})
+	}
+
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Type: OpEqual, Line: line})
+	}
+
+	return ops
+}
+
+func diffMiddle(a, b []string) []Op {
+	var ops []Op
+
+	if (len(a)+1)*(len(b)+1) > maxTableSize {
+		for _, line := range a {
+			ops = append(ops, Op{Type: OpDelete, Line: line})
+		}
+		for _, line := range b {
+			ops = append(ops, Op{Type: OpInsert, Line: line})
+		}
+
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+				lcs[i*width+j] = lcs[(i+1)*width+j]
+			default:
+				lcs[i*width+j] = lcs[i*width+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, Op{Type: OpEqual, Line: a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			ops = append(ops, Op{Type: OpDelete, Line: a[i]})
+			i++
+		default:
+			ops = append(ops, Op{Type: OpInsert, Line: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, Op{Type: OpDelete, Line: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, Op{Type: OpInsert, Line: b[j]})
+	}
+
+	return ops
+}
+
+type hunk struct {
+	startA, countA int
+	startB, countB int
+	ops            []Op
+}
+
+// hunks groups changes with surrounding context lines.
+// Changes separated by no more than 2*context equal lines are merged into one hunk.
+func hunks(ops []Op, context int) []hunk {
+	var result []hunk
+
+	lineA, lineB := 0, 0
+	start := -1 // The index of the first op of the current hunk.
+	var cur hunk
+
+	for i := 0; i < len(ops); i++ {
+		if ops[i].Type == OpEqual {
+			lineA++
+			lineB++
+
+			continue
+		}
+
+		if start == -1 {
+			// Start a new hunk with the preceding context.
+			from := i - context
+			if from < 0 {
+				from = 0
+			}
+
+			start = from
+			cur = hunk{startA: lineA - (i - from), startB: lineB - (i - from)}
+			cur.ops = append(cur.ops, ops[from:i]...)
+		}
+
+		cur.ops = append(cur.ops, ops[i])
+		if ops[i].Type == OpDelete {
+			lineA++
+		} else {
+			lineB++
+		}
+
+		// Find the next change and decide whether it belongs to the same hunk.
+		next := i + 1
+		for next < len(ops) && ops[next].Type == OpEqual {
+			next++
+		}
+
+		if next < len(ops) && next-i-1 <= 2*context {
+			cur.ops = append(cur.ops, ops[i+1:next]...)
+			lineA += next - i - 1
+			lineB += next - i - 1
+			i = next - 1
+
+			continue
+		}
+
+		// Close the hunk with the following context.
+		to := i + 1 + context
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		cur.ops = append(cur.ops, ops[i+1:to]...)
+		lineA += to - i - 1
+		lineB += to - i - 1
+		i = to - 1
+
+		for _, op := range cur.ops {
+			if op.Type != OpInsert {
+				cur.countA++
+			}
+			if op.Type != OpDelete {
+				cur.countB++
+			}
+		}
+
+		result = append(result, cur)
+		start = -1
+	}
+
+	return result
+}
+
+// hunkRange formats a hunk range with 1-based line numbers.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,93 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLines(t *testing.T) {
+	ops := Lines("a\nb\nc\n", "a\nc\nd\n")
+	assert.Equal(t, []Op{
+		{Type: OpEqual, Line: "a"},
+		{Type: OpDelete, Line: "b"},
+		{Type: OpEqual, Line: "c"},
+		{Type: OpInsert, Line: "d"},
+	}, ops)
+
+	assert.Empty(t, Lines("", ""))
+	assert.Equal(t, []Op{{Type: OpInsert, Line: "x"}}, Lines("", "x\n"))
+	assert.Equal(t, []Op{{Type: OpDelete, Line: "x"}}, Lines("x", ""))
+}
+
+func TestUnified_Equal(t *testing.T) {
+	assert.Empty(t, Unified("a", "b", "1\n2\n", "1\n2\n"))
+}
+
+func TestUnified_SingleHunk(t *testing.T) {
+	expected := `--- 22.8
++++ 23.3
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+`
+	assert.Equal(t, expected, Unified("22.8", "23.3", "1\n2\n3\n", "1\ntwo\n3\n"))
+}
+
+func TestUnified_SeveralHunks(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		line := string(rune('a' + i))
+		a = append(a, line)
+		b = append(b, line)
+	}
+	b[1] = "B"
+	b[18] = "S"
+
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -16,5 +16,5 @@
+ p
+ q
+ r
+-s
++S
+ t
+`
+	assert.Equal(t, expected, Unified("old", "new", strings.Join(a, "\n"), strings.Join(b, "\n")))
+}
+
+func TestUnified_MergedHunks(t *testing.T) {
+	expected := `--- old
++++ new
+@@ -1,6 +1,5 @@
+ a
+-b
+ c
+ d
+-e
++E
+ f
+`
+	assert.Equal(t, expected, Unified("old", "new", "a\nb\nc\nd\ne\nf", "a\nc\nd\nE\nf"))
+}
+
+func TestUnified_EmptySide(t *testing.T) {
+	expected := `--- old
++++ new
+@@ -0,0 +1,2 @@
++1
++2
+`
+	assert.Equal(t, expected, Unified("old", "new", "", "1\n2\n"))
+}